@@ -1,7 +1,7 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"net/http"
 	_ "net/http/pprof" // 导入 pprof 包，确保 pprof 路由被注册
@@ -11,9 +11,14 @@ import (
 	"time"
 
 	"github.com/aloys.zy/aloys-webhook-example/internal/configs"
+	"github.com/aloys.zy/aloys-webhook-example/internal/controller/cpu_oversell"
+	"github.com/aloys.zy/aloys-webhook-example/internal/health"
+	"github.com/aloys.zy/aloys-webhook-example/internal/lifecycle"
+	"github.com/aloys.zy/aloys-webhook-example/internal/manifests"
+	"github.com/aloys.zy/aloys-webhook-example/internal/registry"
 	"github.com/aloys.zy/aloys-webhook-example/internal/routers/api"
+	"github.com/aloys.zy/aloys-webhook-example/internal/tls"
 	"github.com/aloys.zy/aloys-webhook-example/internal/util"
-	"golang.org/x/net/context"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
@@ -22,66 +27,87 @@ var (
 	setupLog = ctrl.Log.WithName("setup")
 )
 
-// 启动服务
-func startServers(cfg *configs.Config) (metricsServer, webhookServer *http.Server, err error) {
-	webhookServer = api.WebhookStart(cfg)
-	if webhookServer == nil {
-		return nil, nil, fmt.Errorf("failed to start webhook server")
-	}
-	metricsServer = api.MetricsStart(cfg)
+// 创建服务并加入 lifecycle.Manager，关闭时按添加的逆序执行：先 pprof 和 webhook，最后 metrics
+func addServers(cfg *configs.Config, mgr *lifecycle.Manager, namespaceLabels registry.NamespaceLabelsFunc) (*lifecycle.Server, error) {
+	metricsServer := api.MetricsStart(cfg)
 	if metricsServer == nil {
-		return nil, nil, fmt.Errorf("failed to start metrics server")
+		return nil, fmt.Errorf("failed to create metrics server")
+	}
+	webhookServer := api.WebhookStart(cfg, namespaceLabels)
+	if webhookServer == nil {
+		return nil, fmt.Errorf("failed to create webhook server")
 	}
 
-	// 启用 pprof 服务
+	webhook := lifecycle.NewServer("webhook", webhookServer, true, cfg.ShutdownTimeout)
+	mgr.Add("metrics-server", lifecycle.NewServer("metrics", metricsServer, cfg.MetricsSecure, cfg.ShutdownTimeout))
+	mgr.Add("webhook-server", webhook)
+
+	// 启用 pprof 服务，使用 http.DefaultServeMux 上注册的 pprof 路由
 	if cfg.EnablePprof {
-		go func() {
-			defer setupLog.Info("Pprof server goroutine has exited")
-			err := http.ListenAndServe(fmt.Sprintf("%s", cfg.PprofAddr), nil)
-			if err != nil && !errors.Is(err, http.ErrServerClosed) {
-				setupLog.Error(err, "Failed to listen and serve Pprof server:")
-				panic(err)
-			} else if errors.Is(err, http.ErrServerClosed) {
-				setupLog.Info("Pprof server closed gracefully")
-			}
-		}()
+		pprofServer := &http.Server{Addr: cfg.PprofAddr}
+		mgr.Add("pprof-server", lifecycle.NewServer("pprof", pprofServer, false, cfg.ShutdownTimeout))
 	}
 
-	setupLog.WithName("startServers").Info("Metrics and webhook servers started successfully",
+	setupLog.WithName("addServers").Info("Metrics and webhook servers created successfully",
 		"webhookPort", cfg.WebhookBindPort, "metricsPort", cfg.MetricsBindPort)
 
-	return metricsServer, webhookServer, nil
+	return webhook, nil
 }
 
-// 处理信号并优雅关闭服务器
-func handleSignals(metricsServer, webhookServer *http.Server) {
-	componentLogger := setupLog.WithName("handleSignals")
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
-
-	<-signalChan
-	componentLogger.V(1).Info("Received shutdown signal, shutting down servers gracefully...")
+// 注册就绪和存活检查项
+func addHealthChecks() {
+	health.AddLivezCheck("ping", health.Ping)
+
+	health.AddReadyzCheck("shutdown", health.ShutdownChecker)
+	health.AddReadyzCheck("tls-certificate", func(_ *http.Request) error {
+		return tls.GetCertWatcher().Check()
+	})
+	health.AddReadyzCheck("kube-apiserver", util.CheckAPIServer)
+	// pod DNS 注入依赖 coreDNS 地址，获取不到时注入的配置是不完整的
+	health.AddReadyzCheck("dns-ips", func(req *http.Request) error {
+		_, _, err := util.GetDNSIP(req.Context())
+		return err
+	})
+}
 
-	// 创建上下文，带有时限
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// unregister 删除所有自注册的 webhook 配置
+func unregister() {
+	configs.InitConfig()
+	cfg := configs.GetConfig()
 
-	// 关闭 webhook 服务器
-	if err := webhookServer.Shutdown(ctx); err != nil {
-		componentLogger.Error(err, "Error shutting down webhook server")
-	} else {
-		componentLogger.Info("Webhook server shut down successfully")
+	if err := util.InitClientSet(); err != nil {
+		setupLog.Error(err, "util.GetClientSet failed")
+		os.Exit(1)
 	}
 
-	// 关闭 metrics 服务器
-	if err := metricsServer.Shutdown(ctx); err != nil {
-		componentLogger.Error(err, "Error shutting down metrics server")
-	} else {
-		componentLogger.Info("Metrics server shut down successfully")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	registrar := manifests.NewRegistrar(util.GetClientSet(), cfg, manifests.CABundleFromFile(""))
+	if err := registrar.Unregister(ctx); err != nil {
+		setupLog.Error(err, "Failed to unregister webhook configurations")
+		os.Exit(1)
 	}
 }
 
 func main() {
+	// 子命令，不启动服务：
+	// gen-manifests 根据注册的 webhook 生成 webhook 配置；unregister 删除自注册的 webhook 配置，用于卸载
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "gen-manifests":
+			if err := manifests.Run(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		case "unregister":
+			// 去掉子命令名称，其余参数与 manager 相同
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			unregister()
+			return
+		}
+	}
+
 	// 初始化配置
 	configs.InitConfig()
 	cfg := configs.GetConfig()
@@ -95,13 +121,116 @@ func main() {
 	// 初始化event
 	util.InitializeEventRecorder()
 
-	// 启动服务
-	metricsServer, webhookServer, err := startServers(cfg)
+	// 收到 SIGINT/SIGTERM 后 ctx 结束，lifecycle.Manager 开始关闭所有组件
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	mgr := lifecycle.NewManager(cfg.ShutdownTimeout)
+	// 排空阶段让就绪检查失败，等 Endpoints 摘除本副本后再关闭服务，避免 failurePolicy: Fail 的请求失败
+	mgr.SetDrainDuration(cfg.ShutdownDrainDuration)
+	mgr.OnDrain(health.StartDraining)
+
+	// 自签证书模式：签发证书并注入 caBundle，之后定期检查是否需要重新签发
+	var bootstrapper *tls.CertBootstrapper
+	if cfg.CertBootstrap {
+		bootstrapper = tls.NewCertBootstrapper(util.GetClientSet(), cfg)
+		if err := bootstrapper.Bootstrap(ctx); err != nil {
+			setupLog.Error(err, "Failed to bootstrap self-signed certificates")
+			os.Exit(1)
+		}
+		mgr.Add("cert-bootstrap", bootstrapper)
+	}
+
+	// 加载证书并监听证书文件变化
+	tls.InitCertWatcher(cfg)
+	mgr.Add("cert-watcher", tls.GetCertWatcher())
+
+	// 设置启用的 webhook，配置文件存在时覆盖命令行参数，之后监听配置文件变化
+	if err := registry.SetEnabled(cfg.EnabledWebhooks); err != nil {
+		setupLog.Error(err, "Invalid --enabled-webhooks")
+		os.Exit(1)
+	}
+	if cfg.WebhookConfigFile != "" {
+		configWatcher := registry.NewConfigWatcher(cfg.WebhookConfigFile, cfg.WebhookConfigReloadInterval)
+		if err := configWatcher.Load(); err != nil {
+			setupLog.Error(err, "Failed to load webhook config file, using --enabled-webhooks", "file", cfg.WebhookConfigFile)
+		}
+		mgr.Add("webhook-config-watcher", configWatcher)
+	}
+
+	// 超卖策略与节点标签使用相同的比例范围，加载策略之前设置
+	if err := cpu_oversell.SetRatioBounds(cfg.OversellMinRatio, cfg.OversellMaxRatio); err != nil {
+		setupLog.Error(err, "Invalid --oversell-min-ratio or --oversell-max-ratio")
+		os.Exit(1)
+	}
+	// 允许修改超卖标签和注解的用户和组，白名单为空时只有节点自己的 kubelet 可以修改
+	cpu_oversell.SetAllowList(cfg.OversellAllowedUsers, cfg.OversellAllowedGroups, cfg.OversellAllowAllUsers)
+	if registry.IsEnabled("validating-node-oversell") {
+		if cfg.OversellAllowAllUsers {
+			setupLog.Info("Oversell allow-lists are disabled by --oversell-allow-all-users, anyone who can update nodes may change the oversell labels and annotations")
+		} else if len(cfg.OversellAllowedUsers) == 0 && len(cfg.OversellAllowedGroups) == 0 {
+			setupLog.Info("Oversell allow-lists are empty, only the kubelet of each node may change the oversell labels and annotations",
+				"flags", "--oversell-allowed-users, --oversell-allowed-groups")
+		}
+	}
+
+	// cpu 超卖策略：监听 ConfigMap，同步完成前就绪检查失败，避免按没有策略处理节点
+	if cfg.CPUOversellPolicyConfigMap != "" {
+		policyWatcher, err := cpu_oversell.NewPolicyWatcher(util.GetClientSet(), util.EventRecorder(), cfg.CPUOversellPolicyNamespace, cfg.CPUOversellPolicyConfigMap)
+		if err != nil {
+			setupLog.Error(err, "Failed to create cpu oversell policy watcher")
+			os.Exit(1)
+		}
+		mgr.Add("cpu-oversell-policy-watcher", policyWatcher)
+		health.AddReadyzCheck("cpu-oversell-policy", health.SyncedChecker(policyWatcher.HasSynced))
+	}
+	// 关闭超卖后主动恢复节点的 allocatable，不等 kubelet 下一次上报状态
+	restorer, err := cpu_oversell.NewAllocatableRestorer(util.GetClientSet(), util.EventRecorder())
 	if err != nil {
-		setupLog.Error(err, "Failed to start servers")
+		setupLog.Error(err, "Failed to create cpu oversell allocatable restorer")
 		os.Exit(1)
 	}
+	mgr.Add("cpu-oversell-restorer", restorer)
+
+	// 自注册 webhook 配置，集群中的 webhook 配置与本服务启用的 webhook 保持一致
+	var registrar *manifests.Registrar
+	if cfg.WebhookSelfRegister {
+		caBundle := manifests.CABundleFromFile(cfg.WebhookCABundleFile)
+		if bootstrapper != nil {
+			caBundle = func() ([]byte, error) { return bootstrapper.CABundle(), nil }
+		}
+		registrar = manifests.NewRegistrar(util.GetClientSet(), cfg, caBundle)
+	}
 
-	// 处理信号并优雅关闭服务器
-	handleSignals(metricsServer, webhookServer)
+	// 分发请求时从缓存读取 namespace 的标签，同步完成前就绪检查失败
+	namespaces := util.NewNamespaceCache(util.GetClientSet())
+	mgr.Add("namespace-cache", namespaces)
+	health.AddReadyzCheck("namespace-cache", health.SyncedChecker(namespaces.HasSynced))
+
+	addHealthChecks()
+
+	// 创建服务
+	webhookServer, err := addServers(cfg, mgr, namespaces.Labels)
+	if err != nil {
+		setupLog.Error(err, "Failed to create servers")
+		os.Exit(1)
+	}
+	// 在服务之后添加，关闭时先于服务停止，开启 --webhook-unregister-on-stop 时先删除 webhook 配置再关闭服务。
+	// webhook 服务开始监听并且就绪检查（证书、namespace 缓存等）通过后才注册
+	if registrar != nil {
+		registrar.WaitFor(func(ctx context.Context) error {
+			if !webhookServer.Serving() {
+				return fmt.Errorf("webhook server is not serving yet")
+			}
+			return health.Ready(ctx)
+		})
+		mgr.Add("webhook-registrar", registrar)
+	}
+
+	// 启动所有组件，直到收到退出信号或某个组件异常退出
+	if err := mgr.Run(ctx); err != nil {
+		setupLog.Error(err, "Manager exited with error")
+		os.Exit(1)
+	}
+	setupLog.Info("All servers shut down gracefully")
 }
@@ -0,0 +1,180 @@
+// Package admission 提供泛型的准入处理框架：解码请求中的对象、生成 JSON Patch 和转换错误，
+// webhook 的作者只需要实现 Mutate 或 Validate 函数。
+package admission
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/setting"
+	"github.com/aloys.zy/aloys-webhook-example/internal/util"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// MutateFunc 直接修改 obj，框架比较修改前后的对象生成 JSON Patch。
+// 返回 Denied 错误时拒绝请求，返回其他错误时按处理失败拒绝请求。
+type MutateFunc[T runtime.Object] func(ctx context.Context, req *admissionv1.AdmissionRequest, obj, old T) error
+
+// ValidateFunc 检查 obj，返回 nil 时允许请求，返回 Denied 错误时拒绝请求并把原因返回给用户
+type ValidateFunc[T runtime.Object] func(ctx context.Context, req *admissionv1.AdmissionRequest, obj, old T) error
+
+// Handler 是以对象类型为参数的准入处理器，T 是对象的指针类型，比如 *corev1.Pod。
+//
+// 传给 Mutate 和 Validate 的 obj 和 old：
+//   - CREATE、CONNECT：obj 是请求中的对象，old 为零值
+//   - UPDATE：obj 是新对象，old 是旧对象
+//   - DELETE：obj 和 old 都是被删除的对象（OldObject）
+//
+// Mutate 和 Validate 只能设置一个。
+type Handler[T runtime.Object] struct {
+	// Name 用于日志
+	Name string
+	// Resources 是允许的资源，为空时不检查
+	Resources []metav1.GroupVersionResource
+	// New 返回用于解码的空对象
+	New func() T
+	// Mutate 修改对象
+	Mutate MutateFunc[T]
+	// Validate 检查对象
+	Validate ValidateFunc[T]
+}
+
+// AdmitHandler 返回可以注册到 registry 的 AdmitHandler，v1beta1 的请求转换为 v1 处理
+func (h *Handler[T]) AdmitHandler() setting.AdmitHandler {
+	return setting.NewDelegateToV1AdmitHandler(h.AdmitV1)
+}
+
+// AdmitV1 处理 v1 的 AdmissionReview
+func (h *Handler[T]) AdmitV1(ctx context.Context, ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+	return h.Admit(ctx, ar.Request)
+}
+
+// Admit 检查资源、解码对象，调用 Mutate 或 Validate 并生成响应
+func (h *Handler[T]) Admit(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	setupLog := ctrl.Log.WithName("admission").WithValues("webhook", h.Name)
+
+	if req == nil {
+		return setting.ToV1AdmissionResponse(fmt.Errorf("admission review has no request"))
+	}
+	if !h.allowResource(req.Resource) {
+		err := fmt.Errorf("expect resource to be one of %v, got %v", h.Resources, req.Resource)
+		setupLog.Error(err, "InvalidResource")
+		return setting.ToV1AdmissionResponse(err)
+	}
+
+	obj, old, err := h.decode(req)
+	if err != nil {
+		setupLog.Error(err, "Failed to decode object", "operation", req.Operation)
+		return setting.ToV1AdmissionResponse(err)
+	}
+
+	if h.Validate != nil {
+		return toResponse(h.Validate(ctx, req, obj, old))
+	}
+
+	if h.Mutate == nil {
+		return setting.ToV1AdmissionResponse(fmt.Errorf("webhook %s has neither Mutate nor Validate", h.Name))
+	}
+	// 保存修改前的对象，用于生成 Patch
+	original := obj.DeepCopyObject()
+	if err := h.Mutate(ctx, req, obj, old); err != nil {
+		return toResponse(err)
+	}
+	return util.GeneratePatchAndResponse(original, obj, true, "", "")
+}
+
+// allowResource 检查请求的资源是否在 Resources 中
+func (h *Handler[T]) allowResource(gvr metav1.GroupVersionResource) bool {
+	if len(h.Resources) == 0 {
+		return true
+	}
+	for _, r := range h.Resources {
+		if r == gvr {
+			return true
+		}
+	}
+	return false
+}
+
+// decode 解码请求中的对象，DELETE 时请求只有 OldObject
+func (h *Handler[T]) decode(req *admissionv1.AdmissionRequest) (obj, old T, err error) {
+	switch req.Operation {
+	case admissionv1.Update:
+		if obj, err = h.decodeRaw(req.Object.Raw); err != nil {
+			return obj, old, err
+		}
+		if old, err = h.decodeRaw(req.OldObject.Raw); err != nil {
+			return obj, old, fmt.Errorf("failed to decode old object: %w", err)
+		}
+		return obj, old, nil
+	case admissionv1.Delete:
+		if old, err = h.decodeRaw(req.OldObject.Raw); err != nil {
+			return obj, old, fmt.Errorf("failed to decode old object: %w", err)
+		}
+		return old, old, nil
+	default:
+		obj, err = h.decodeRaw(req.Object.Raw)
+		return obj, old, err
+	}
+}
+
+// decodeRaw 把原始 JSON 解码为 T
+func (h *Handler[T]) decodeRaw(raw []byte) (T, error) {
+	var zero T
+	if len(raw) == 0 {
+		return zero, fmt.Errorf("object is empty")
+	}
+	decoded, _, err := setting.Codecs.UniversalDeserializer().Decode(raw, nil, h.New())
+	if err != nil {
+		return zero, err
+	}
+	obj, ok := decoded.(T)
+	if !ok {
+		return zero, fmt.Errorf("expect object to be %T, got %T", zero, decoded)
+	}
+	return obj, nil
+}
+
+// DeniedError 表示请求被拒绝，原因会返回给用户
+type DeniedError struct {
+	Message string
+}
+
+func (e *DeniedError) Error() string {
+	return e.Message
+}
+
+// Denied 返回拒绝请求的错误
+func Denied(message string) error {
+	return &DeniedError{Message: message}
+}
+
+// Deniedf 按格式返回拒绝请求的错误
+func Deniedf(format string, args ...interface{}) error {
+	return &DeniedError{Message: fmt.Sprintf(format, args...)}
+}
+
+// toResponse 把 Mutate 或 Validate 返回的错误转换为 AdmissionResponse
+func toResponse(err error) *admissionv1.AdmissionResponse {
+	if err == nil {
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+	var denied *DeniedError
+	if errors.As(err, &denied) {
+		return &admissionv1.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Message: denied.Message,
+				Reason:  metav1.StatusReasonForbidden,
+				Code:    http.StatusForbidden,
+			},
+		}
+	}
+	return setting.ToV1AdmissionResponse(err)
+}
@@ -0,0 +1,161 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestHandlerAdmit(t *testing.T) {
+	pods := metav1.GroupVersionResource{Version: "v1", Resource: "pods"}
+	newPod := func(name string, labels map[string]string) []byte {
+		raw, err := json.Marshal(&corev1.Pod{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	node, err := json.Marshal(&corev1.Node{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Node"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 把 old 的标签复制到 obj 上，可以检查 UPDATE 和 DELETE 时传入的对象
+	copyLabels := func(_ context.Context, _ *admissionv1.AdmissionRequest, obj, old *corev1.Pod) error {
+		if old == nil {
+			obj.Labels = map[string]string{"old": "none"}
+			return nil
+		}
+		obj.Labels = map[string]string{"old": old.Name, "obj": obj.Name}
+		return nil
+	}
+	denyAll := func(_ context.Context, _ *admissionv1.AdmissionRequest, obj, _ *corev1.Pod) error {
+		return Deniedf("pod %s is not allowed", obj.Name)
+	}
+	fail := func(_ context.Context, _ *admissionv1.AdmissionRequest, _, _ *corev1.Pod) error {
+		return errors.New("internal error")
+	}
+
+	testCases := []struct {
+		name       string
+		mutate     MutateFunc[*corev1.Pod]
+		validate   ValidateFunc[*corev1.Pod]
+		request    admissionv1.AdmissionRequest
+		allowed    bool
+		code       int32
+		wantLabels map[string]string
+	}{
+		{
+			name:       "create",
+			mutate:     copyLabels,
+			request:    admissionv1.AdmissionRequest{Resource: pods, Operation: admissionv1.Create, Object: runtime.RawExtension{Raw: newPod("new", nil)}},
+			allowed:    true,
+			wantLabels: map[string]string{"old": "none"},
+		},
+		{
+			name:   "update decodes old object",
+			mutate: copyLabels,
+			request: admissionv1.AdmissionRequest{Resource: pods, Operation: admissionv1.Update,
+				Object: runtime.RawExtension{Raw: newPod("new", nil)}, OldObject: runtime.RawExtension{Raw: newPod("old", nil)}},
+			allowed:    true,
+			wantLabels: map[string]string{"old": "old", "obj": "new"},
+		},
+		{
+			name:       "delete uses old object",
+			mutate:     copyLabels,
+			request:    admissionv1.AdmissionRequest{Resource: pods, Operation: admissionv1.Delete, OldObject: runtime.RawExtension{Raw: newPod("old", nil)}},
+			allowed:    true,
+			wantLabels: map[string]string{"old": "old", "obj": "old"},
+		},
+		{
+			name:     "validate allows",
+			validate: func(context.Context, *admissionv1.AdmissionRequest, *corev1.Pod, *corev1.Pod) error { return nil },
+			request:  admissionv1.AdmissionRequest{Resource: pods, Operation: admissionv1.Create, Object: runtime.RawExtension{Raw: newPod("new", nil)}},
+			allowed:  true,
+		},
+		{
+			name:     "validate denies",
+			validate: denyAll,
+			request:  admissionv1.AdmissionRequest{Resource: pods, Operation: admissionv1.Create, Object: runtime.RawExtension{Raw: newPod("new", nil)}},
+			code:     http.StatusForbidden,
+		},
+		{
+			name:    "mutate fails",
+			mutate:  fail,
+			request: admissionv1.AdmissionRequest{Resource: pods, Operation: admissionv1.Create, Object: runtime.RawExtension{Raw: newPod("new", nil)}},
+		},
+		{
+			name:    "unexpected resource",
+			mutate:  copyLabels,
+			request: admissionv1.AdmissionRequest{Resource: metav1.GroupVersionResource{Version: "v1", Resource: "nodes"}, Operation: admissionv1.Create, Object: runtime.RawExtension{Raw: node}},
+		},
+		{
+			name:    "unexpected object",
+			mutate:  copyLabels,
+			request: admissionv1.AdmissionRequest{Resource: pods, Operation: admissionv1.Create, Object: runtime.RawExtension{Raw: node}},
+		},
+		{
+			name:    "empty object",
+			mutate:  copyLabels,
+			request: admissionv1.AdmissionRequest{Resource: pods, Operation: admissionv1.Delete},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler[*corev1.Pod]{
+				Name:      "test",
+				Resources: []metav1.GroupVersionResource{pods},
+				New:       func() *corev1.Pod { return &corev1.Pod{} },
+				Mutate:    tc.mutate,
+				Validate:  tc.validate,
+			}
+			resp := h.Admit(context.Background(), &tc.request)
+			if resp.Allowed != tc.allowed {
+				t.Fatalf("expected allowed %v, got %v: %v", tc.allowed, resp.Allowed, resp.Result)
+			}
+			if tc.code != 0 && (resp.Result == nil || resp.Result.Code != tc.code) {
+				t.Errorf("expected code %d, got %v", tc.code, resp.Result)
+			}
+			if tc.wantLabels == nil {
+				return
+			}
+
+			raw := tc.request.Object.Raw
+			if tc.request.Operation == admissionv1.Delete {
+				raw = tc.request.OldObject.Raw
+			}
+			patch, err := jsonpatch.DecodePatch(resp.Patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			patched, err := patch.Apply(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var pod corev1.Pod
+			if err := json.Unmarshal(patched, &pod); err != nil {
+				t.Fatal(err)
+			}
+			if len(pod.Labels) != len(tc.wantLabels) {
+				t.Fatalf("expected labels %v, got %v", tc.wantLabels, pod.Labels)
+			}
+			for k, v := range tc.wantLabels {
+				if pod.Labels[k] != v {
+					t.Errorf("expected labels %v, got %v", tc.wantLabels, pod.Labels)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package admission
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"time"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/metrics"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// MutatorFailurePolicy 决定 mutator 返回错误时流水线如何处理
+type MutatorFailurePolicy string
+
+const (
+	// MutatorFail 拒绝整个请求，是默认值
+	MutatorFail MutatorFailurePolicy = "Fail"
+	// MutatorSkip 丢弃这个 mutator 的修改，继续执行后面的 mutator
+	MutatorSkip MutatorFailurePolicy = "Skip"
+)
+
+// mutator 的执行结果，用于指标
+const (
+	resultChanged   = "changed"
+	resultUnchanged = "unchanged"
+	resultSkipped   = "skipped"
+	resultFailed    = "failed"
+	resultDenied    = "denied"
+)
+
+// Mutator 是流水线中的一步
+type Mutator[T runtime.Object] struct {
+	// Name 用于日志和指标
+	Name   string
+	Mutate MutateFunc[T]
+	// FailurePolicy 为空时使用 MutatorFail。返回 Denied 错误时总是拒绝请求，不受失败策略影响。
+	// panic 按失败处理：MutatorSkip 丢弃修改继续执行，MutatorFail 继续 panic。
+	FailurePolicy MutatorFailurePolicy
+}
+
+// Pipeline 把多个 mutator 组合为一个 MutateFunc，在同一个 webhook 上按顺序执行。
+// 每个 mutator 看到的是前一个 mutator 修改后的对象，Handler 最后对比原始对象生成一个合并的 JSON Patch，
+// 比每个功能一个 MutatingWebhookConfiguration 减少了 API server 调用 webhook 的次数。
+func Pipeline[T runtime.Object](webhook string, mutators ...Mutator[T]) MutateFunc[T] {
+	for _, m := range mutators {
+		if m.Name == "" || m.Mutate == nil {
+			panic(fmt.Sprintf("admission: invalid mutator %q in pipeline %s", m.Name, webhook))
+		}
+		switch m.FailurePolicy {
+		case "", MutatorFail, MutatorSkip:
+		default:
+			panic(fmt.Sprintf("admission: invalid failure policy %q of mutator %s in pipeline %s", m.FailurePolicy, m.Name, webhook))
+		}
+	}
+
+	return func(ctx context.Context, req *admissionv1.AdmissionRequest, obj, old T) error {
+		setupLog := ctrl.Log.WithName("admission").WithValues("webhook", webhook, "uid", req.UID)
+
+		for _, m := range mutators {
+			// 保存执行前的对象，失败跳过时恢复，同时用于判断是否有修改
+			before := obj.DeepCopyObject()
+			start := time.Now()
+			err := callMutator(ctx, m, req, obj, old)
+			duration := time.Since(start)
+
+			var denied *DeniedError
+			var panicked *mutatorPanic
+			switch {
+			case err == nil:
+				result := resultUnchanged
+				if !equality.Semantic.DeepEqual(before, runtime.Object(obj)) {
+					result = resultChanged
+				}
+				metrics.RecordMutator(webhook, m.Name, result, duration)
+			case errors.As(err, &denied):
+				metrics.RecordMutator(webhook, m.Name, resultDenied, duration)
+				return err
+			case m.FailurePolicy == MutatorSkip:
+				metrics.RecordMutator(webhook, m.Name, resultSkipped, duration)
+				if errors.As(err, &panicked) {
+					setupLog.Error(err, "Mutator panicked, skip its changes", "mutator", m.Name, "stack", string(panicked.stack))
+				} else {
+					setupLog.Error(err, "Mutator failed, skip its changes", "mutator", m.Name)
+				}
+				restore(obj, before)
+			case errors.As(err, &panicked):
+				// 失败策略为 Fail 时继续 panic，由 webhook 的错误策略处理
+				metrics.RecordMutator(webhook, m.Name, resultFailed, duration)
+				setupLog.Error(err, "Mutator panicked", "mutator", m.Name, "stack", string(panicked.stack))
+				panic(panicked.value)
+			default:
+				metrics.RecordMutator(webhook, m.Name, resultFailed, duration)
+				setupLog.Error(err, "Mutator failed", "mutator", m.Name)
+				return fmt.Errorf("mutator %s: %w", m.Name, err)
+			}
+		}
+		return nil
+	}
+}
+
+// mutatorPanic 是 mutator panic 时的值和调用栈
+type mutatorPanic struct {
+	value interface{}
+	stack []byte
+}
+
+func (p *mutatorPanic) Error() string {
+	return fmt.Sprintf("panic: %v", p.value)
+}
+
+// callMutator 调用 mutator，panic 时返回 mutatorPanic，由 mutator 的失败策略决定跳过还是继续 panic
+func callMutator[T runtime.Object](ctx context.Context, m Mutator[T], req *admissionv1.AdmissionRequest, obj, old T) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &mutatorPanic{value: r, stack: debug.Stack()}
+		}
+	}()
+	return m.Mutate(ctx, req, obj, old)
+}
+
+// restore 把 backup 的内容复制回 obj，丢弃 mutator 做的部分修改
+func restore(obj, backup runtime.Object) {
+	reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(backup).Elem())
+}
@@ -0,0 +1,203 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestPipeline(t *testing.T) {
+	// setLabel 添加标签，并把前一个 mutator 添加的标签数量记录下来，用于检查执行顺序
+	setLabel := func(key string) MutateFunc[*corev1.Pod] {
+		return func(_ context.Context, _ *admissionv1.AdmissionRequest, pod, _ *corev1.Pod) error {
+			if pod.Labels == nil {
+				pod.Labels = map[string]string{}
+			}
+			pod.Labels[key] = string(rune('0' + len(pod.Labels)))
+			return nil
+		}
+	}
+	// failAfterChange 修改对象后返回错误，修改应该被丢弃
+	failAfterChange := func(_ context.Context, _ *admissionv1.AdmissionRequest, pod, _ *corev1.Pod) error {
+		pod.Labels["partial"] = "yes"
+		pod.Spec.NodeName = "partial"
+		return errors.New("failed")
+	}
+	// panicAfterChange 修改对象后 panic，失败策略为 Skip 时修改应该被丢弃
+	panicAfterChange := func(_ context.Context, _ *admissionv1.AdmissionRequest, pod, _ *corev1.Pod) error {
+		pod.Labels["partial"] = "yes"
+		pod.Spec.NodeName = "partial"
+		panic("boom")
+	}
+	deny := func(context.Context, *admissionv1.AdmissionRequest, *corev1.Pod, *corev1.Pod) error {
+		return Denied("denied")
+	}
+
+	testCases := []struct {
+		name       string
+		mutators   []Mutator[*corev1.Pod]
+		allowed    bool
+		wantLabels map[string]string
+	}{
+		{
+			name: "each mutator sees the previous output",
+			mutators: []Mutator[*corev1.Pod]{
+				{Name: "a", Mutate: setLabel("a")},
+				{Name: "b", Mutate: setLabel("b")},
+			},
+			allowed:    true,
+			wantLabels: map[string]string{"a": "0", "b": "1"},
+		},
+		{
+			name: "skip discards the failed mutator changes",
+			mutators: []Mutator[*corev1.Pod]{
+				{Name: "a", Mutate: setLabel("a")},
+				{Name: "partial", Mutate: failAfterChange, FailurePolicy: MutatorSkip},
+				{Name: "b", Mutate: setLabel("b")},
+			},
+			allowed:    true,
+			wantLabels: map[string]string{"a": "0", "b": "1"},
+		},
+		{
+			name: "skip recovers a panicking mutator",
+			mutators: []Mutator[*corev1.Pod]{
+				{Name: "a", Mutate: setLabel("a")},
+				{Name: "panic", Mutate: panicAfterChange, FailurePolicy: MutatorSkip},
+				{Name: "b", Mutate: setLabel("b")},
+			},
+			allowed:    true,
+			wantLabels: map[string]string{"a": "0", "b": "1"},
+		},
+		{
+			name: "fail rejects the request",
+			mutators: []Mutator[*corev1.Pod]{
+				{Name: "a", Mutate: setLabel("a")},
+				{Name: "partial", Mutate: failAfterChange},
+			},
+		},
+		{
+			name: "denied ignores skip",
+			mutators: []Mutator[*corev1.Pod]{
+				{Name: "deny", Mutate: deny, FailurePolicy: MutatorSkip},
+			},
+		},
+	}
+
+	raw, err := json.Marshal(&corev1.Pod{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &Handler[*corev1.Pod]{
+				Name:   "test",
+				New:    func() *corev1.Pod { return &corev1.Pod{} },
+				Mutate: Pipeline("test", tc.mutators...),
+			}
+			resp := h.Admit(context.Background(), &admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			})
+			if resp.Allowed != tc.allowed {
+				t.Fatalf("expected allowed %v, got %v: %v", tc.allowed, resp.Allowed, resp.Result)
+			}
+			if !tc.allowed {
+				return
+			}
+
+			patch, err := jsonpatch.DecodePatch(resp.Patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			patched, err := patch.Apply(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var pod corev1.Pod
+			if err := json.Unmarshal(patched, &pod); err != nil {
+				t.Fatal(err)
+			}
+			if pod.Spec.NodeName != "" {
+				t.Errorf("expected changes of the skipped mutator to be discarded, got nodeName %q", pod.Spec.NodeName)
+			}
+			if len(pod.Labels) != len(tc.wantLabels) {
+				t.Fatalf("expected labels %v, got %v", tc.wantLabels, pod.Labels)
+			}
+			for k, v := range tc.wantLabels {
+				if pod.Labels[k] != v {
+					t.Errorf("expected labels %v, got %v", tc.wantLabels, pod.Labels)
+				}
+			}
+		})
+	}
+}
+
+func TestPipelinePanic(t *testing.T) {
+	panicking := func(context.Context, *admissionv1.AdmissionRequest, *corev1.Pod, *corev1.Pod) error {
+		panic("boom")
+	}
+
+	testCases := []struct {
+		name       string
+		policy     MutatorFailurePolicy
+		wantResult string
+		wantPanic  bool
+	}{
+		{name: "skip", policy: MutatorSkip, wantResult: resultSkipped},
+		{name: "fail", policy: MutatorFail, wantResult: resultFailed, wantPanic: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			webhook := "test-panic-" + tc.name
+			mutate := Pipeline(webhook, Mutator[*corev1.Pod]{Name: "panic", Mutate: panicking, FailurePolicy: tc.policy})
+
+			var recovered interface{}
+			func() {
+				defer func() { recovered = recover() }()
+				if err := mutate(context.Background(), &admissionv1.AdmissionRequest{}, &corev1.Pod{}, nil); err != nil {
+					t.Errorf("Pipeline() error = %v", err)
+				}
+			}()
+			if (recovered != nil) != tc.wantPanic {
+				t.Errorf("Pipeline() panic = %v, want panic %v", recovered, tc.wantPanic)
+			}
+			if got := mutatorRuns(t, webhook, "panic", tc.wantResult); got != 1 {
+				t.Errorf("webhook_mutator_runs_total{result=%q} = %v, want 1", tc.wantResult, got)
+			}
+		})
+	}
+}
+
+// mutatorRuns 返回 webhook_mutator_runs_total 中一个 mutator 某种结果的次数
+func mutatorRuns(t *testing.T, webhook, mutator, result string) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"webhook": webhook, "mutator": mutator, "result": result}
+	for _, f := range families {
+		if f.GetName() != "webhook_mutator_runs_total" {
+			continue
+		}
+	metrics:
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if want[l.GetName()] != l.GetValue() {
+					continue metrics
+				}
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/metrics"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+type decision struct {
+	err     *Error
+	expires time.Time
+}
+
+// RequestAuthorizer 与 kubebuilder 受保护的 metrics 一致：先通过 TokenReview 认证调用方，
+// 再通过 SubjectAccessReview 检查调用方对请求路径（非资源 URL）是否有对应 verb 的权限。
+// 认证和授权结果都缓存 ttl 时间。
+type RequestAuthorizer struct {
+	client        kubernetes.Interface
+	authenticator *TokenAuthenticator
+	ttl           time.Duration
+
+	mu    sync.Mutex
+	cache map[string]decision
+	now   func() time.Time
+}
+
+// NewRequestAuthorizer 创建 RequestAuthorizer
+func NewRequestAuthorizer(client kubernetes.Interface, ttl time.Duration) *RequestAuthorizer {
+	return &RequestAuthorizer{
+		client:        client,
+		authenticator: newTokenAuthenticator(client, ttl),
+		ttl:           ttl,
+		cache:         make(map[string]decision),
+		now:           time.Now,
+	}
+}
+
+// Authorize 检查用户是否有权限以 verb 访问非资源 URL path
+func (a *RequestAuthorizer) Authorize(ctx context.Context, user *authenticationv1.UserInfo, verb, path string) error {
+	key := decisionKey(user, verb, path)
+
+	a.mu.Lock()
+	d, ok := a.cache[key]
+	a.mu.Unlock()
+	if ok && a.now().Before(d.expires) {
+		if d.err != nil {
+			return d.err
+		}
+		return nil
+	}
+
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	review, err := a.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			Groups: user.Groups,
+			UID:    user.UID,
+			Extra:  extra,
+			NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+				Path: path,
+				Verb: verb,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		// SubjectAccessReview 调用失败不缓存，下次请求重试
+		return &Error{Reason: "access_review_failed", Code: http.StatusInternalServerError,
+			msg: fmt.Sprintf("failed to review access: %v", err)}
+	}
+
+	d = decision{expires: a.now().Add(a.ttl)}
+	if !review.Status.Allowed {
+		d.err = &Error{Reason: "forbidden", Code: http.StatusForbidden,
+			msg: fmt.Sprintf("user %q cannot %s path %q: %s", user.Username, verb, path, review.Status.Reason)}
+	}
+	a.store(key, d)
+
+	if d.err != nil {
+		return d.err
+	}
+	return nil
+}
+
+// store 写入缓存，条目达到上限时先腾出空间
+func (a *RequestAuthorizer) store(key string, d decision) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	prune(a.cache, a.now(), func(d decision) time.Time { return d.expires })
+	a.cache[key] = d
+}
+
+// WithAuthnAuthz 包装处理函数，在调用处理函数之前认证并授权调用方
+func (a *RequestAuthorizer) WithAuthnAuthz(next http.Handler) http.Handler {
+	setupLog := ctrl.Log.WithName("request-authorizer")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// 与 kube-apiserver 一致，非资源 URL 的 verb 是小写的 HTTP 方法
+		verb := strings.ToLower(req.Method)
+		user, err := a.authenticator.Authenticate(req.Context(), bearerToken(req))
+		if err == nil {
+			err = a.Authorize(req.Context(), user, verb, req.URL.Path)
+		}
+		if err != nil {
+			authErr := &Error{Reason: "unauthorized", Code: http.StatusForbidden, msg: err.Error()}
+			errors.As(err, &authErr)
+			metrics.RecordAuthRejection(req.URL.Path, authErr.Reason)
+			setupLog.Info("Rejected request",
+				"reason", authErr.Reason,
+				"error", err.Error(),
+				"method", req.Method,
+				"url", req.URL.String(),
+				"remoteAddr", req.RemoteAddr,
+			)
+			http.Error(w, authErr.Error(), authErr.Code)
+			return
+		}
+
+		setupLog.V(1).Info("Authorized request", "user", user.Username, "verb", verb, "path", req.URL.Path)
+		next.ServeHTTP(w, req)
+	})
+}
+
+// decisionKey 由用户、用户组、verb 和路径组成缓存 key，用户组排序后拼接
+func decisionKey(user *authenticationv1.UserInfo, verb, path string) string {
+	groups := append([]string(nil), user.Groups...)
+	sort.Strings(groups)
+	return strings.Join([]string{user.Username, user.UID, strings.Join(groups, ","), verb, path}, "\x00")
+}
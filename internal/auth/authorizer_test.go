@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestWithAuthnAuthz(t *testing.T) {
+	users := map[string]authenticationv1.UserInfo{
+		"prometheus-token": {Username: "system:serviceaccount:monitoring:prometheus"},
+		"other-token":      {Username: "someone"},
+	}
+
+	testCases := []struct {
+		name           string
+		method         string
+		authorization  string
+		expectedStatus int
+	}{
+		{
+			name:           "allowed",
+			method:         http.MethodGet,
+			authorization:  "Bearer prometheus-token",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "verb not allowed",
+			method:         http.MethodPost,
+			authorization:  "Bearer prometheus-token",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "user not allowed",
+			method:         http.MethodGet,
+			authorization:  "Bearer other-token",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "invalid token",
+			method:         http.MethodGet,
+			authorization:  "Bearer unknown-token",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "missing token",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			client := newFakeClient(users, &calls)
+			client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+				attrs := review.Spec.NonResourceAttributes
+				review.Status.Allowed = review.Spec.User == "system:serviceaccount:monitoring:prometheus" &&
+					attrs != nil && attrs.Path == "/metrics" && attrs.Verb == "get"
+				return true, review, nil
+			})
+
+			authorizer := NewRequestAuthorizer(client, time.Minute)
+			handler := authorizer.WithAuthnAuthz(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(tc.method, "/metrics", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", tc.expectedStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/metrics"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// maxCacheEntries 是缓存的最大条目数，达到上限时先清理过期条目，仍然达到上限时删除最早过期的条目
+	maxCacheEntries = 1024
+	// maxFailureTTL 是认证失败结果的最长缓存时间，减少无效 token 占用的缓存
+	maxFailureTTL = 10 * time.Second
+)
+
+// Error 表示调用方身份校验失败，Reason 用于日志和 metrics
+type Error struct {
+	Reason string
+	Code   int
+	msg    string
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+type cacheEntry struct {
+	user    authenticationv1.UserInfo
+	err     *Error
+	expires time.Time
+}
+
+// TokenAuthenticator 通过 TokenReview 校验请求头中的 bearer token，
+// 并只允许白名单中的用户或用户组调用。校验结果按 token 缓存 ttl 时间。
+type TokenAuthenticator struct {
+	client        kubernetes.Interface
+	ttl           time.Duration
+	restricted    bool
+	allowedUsers  map[string]struct{}
+	allowedGroups map[string]struct{}
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]cacheEntry
+	now   func() time.Time
+}
+
+// NewTokenAuthenticator 创建 TokenAuthenticator，users 和 groups 不能同时为空
+func NewTokenAuthenticator(client kubernetes.Interface, ttl time.Duration, users, groups []string) (*TokenAuthenticator, error) {
+	if len(users) == 0 && len(groups) == 0 {
+		return nil, fmt.Errorf("token authentication requires at least one allowed user or group")
+	}
+	a := newTokenAuthenticator(client, ttl)
+	a.restricted = true
+	for _, u := range users {
+		a.allowedUsers[u] = struct{}{}
+	}
+	for _, g := range groups {
+		a.allowedGroups[g] = struct{}{}
+	}
+	return a, nil
+}
+
+// newTokenAuthenticator 创建不限制用户的 TokenAuthenticator，只要 token 有效即认证通过，
+// 调用方需要自行做授权，比如 RequestAuthorizer 使用 SubjectAccessReview
+func newTokenAuthenticator(client kubernetes.Interface, ttl time.Duration) *TokenAuthenticator {
+	return &TokenAuthenticator{
+		client:        client,
+		ttl:           ttl,
+		allowedUsers:  make(map[string]struct{}),
+		allowedGroups: make(map[string]struct{}),
+		cache:         make(map[[sha256.Size]byte]cacheEntry),
+		now:           time.Now,
+	}
+}
+
+// Authenticate 校验 token 并返回对应的用户信息，用户不在白名单中时返回错误
+func (a *TokenAuthenticator) Authenticate(ctx context.Context, token string) (*authenticationv1.UserInfo, error) {
+	if token == "" {
+		return nil, &Error{Reason: "missing_token", Code: http.StatusUnauthorized, msg: "bearer token is required"}
+	}
+	key := sha256.Sum256([]byte(token))
+
+	a.mu.Lock()
+	entry, ok := a.cache[key]
+	a.mu.Unlock()
+	if ok && a.now().Before(entry.expires) {
+		if entry.err != nil {
+			return nil, entry.err
+		}
+		return &entry.user, nil
+	}
+
+	review, err := a.client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		// TokenReview 调用失败不缓存，下次请求重试
+		return nil, &Error{Reason: "token_review_failed", Code: http.StatusInternalServerError,
+			msg: fmt.Sprintf("failed to review token: %v", err)}
+	}
+
+	entry = cacheEntry{user: review.Status.User, expires: a.now().Add(a.ttl)}
+	switch {
+	case !review.Status.Authenticated:
+		entry.err = &Error{Reason: "invalid_token", Code: http.StatusUnauthorized,
+			msg: fmt.Sprintf("token is not authenticated: %s", review.Status.Error)}
+	case !a.allowed(review.Status.User):
+		entry.err = &Error{Reason: "user_not_allowed", Code: http.StatusForbidden,
+			msg: fmt.Sprintf("user %q is not allowed to call the webhook", review.Status.User.Username)}
+	}
+	if entry.err != nil {
+		entry.expires = a.now().Add(min(a.ttl, maxFailureTTL))
+	}
+	a.store(key, entry)
+
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	return &entry.user, nil
+}
+
+// allowed 判断用户或其所属的任一用户组是否在白名单中
+func (a *TokenAuthenticator) allowed(user authenticationv1.UserInfo) bool {
+	if !a.restricted {
+		return true
+	}
+	if _, ok := a.allowedUsers[user.Username]; ok {
+		return true
+	}
+	for _, g := range user.Groups {
+		if _, ok := a.allowedGroups[g]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// store 写入缓存，条目达到上限时先腾出空间
+func (a *TokenAuthenticator) store(key [sha256.Size]byte, entry cacheEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	prune(a.cache, a.now(), func(e cacheEntry) time.Time { return e.expires })
+	a.cache[key] = entry
+}
+
+// prune 在缓存达到 maxCacheEntries 时清理过期条目，仍然达到上限时删除最早过期的条目，
+// 这样调用方发送大量不同的 token 也不会让缓存无限增长
+func prune[K comparable, V any](cache map[K]V, now time.Time, expires func(V) time.Time) {
+	if len(cache) < maxCacheEntries {
+		return
+	}
+	for k, v := range cache {
+		if !now.Before(expires(v)) {
+			delete(cache, k)
+		}
+	}
+	for len(cache) >= maxCacheEntries {
+		var oldest K
+		var oldestExpires time.Time
+		first := true
+		for k, v := range cache {
+			if e := expires(v); first || e.Before(oldestExpires) {
+				oldest, oldestExpires, first = k, e, false
+			}
+		}
+		delete(cache, oldest)
+	}
+}
+
+// WithTokenAuth 包装函数，在调用处理函数之前校验 Authorization 请求头
+func (a *TokenAuthenticator) WithTokenAuth(next http.HandlerFunc) http.HandlerFunc {
+	setupLog := ctrl.Log.WithName("token-auth")
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		user, err := a.Authenticate(req.Context(), bearerToken(req))
+		if err != nil {
+			reject(w, req, err)
+			return
+		}
+
+		setupLog.V(1).Info("Authenticated webhook caller", "user", user.Username, "path", req.URL.Path)
+		next.ServeHTTP(w, req)
+	}
+}
+
+// WithBearerToken 包装函数，拒绝没有 bearer token 的请求。只检查请求头，开销很小，放在并发限制之前，
+// 没有 token 的调用方不会占用并发槽位；token 是否有效由并发限制之后的 WithTokenAuth 调用 TokenReview 校验
+func WithBearerToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if bearerToken(req) == "" {
+			reject(w, req, &Error{Reason: "missing_token", Code: http.StatusUnauthorized, msg: "bearer token is required"})
+			return
+		}
+		next.ServeHTTP(w, req)
+	}
+}
+
+// reject 记录认证失败的指标和日志，并返回对应的 HTTP 状态码
+func reject(w http.ResponseWriter, req *http.Request, err error) {
+	setupLog := ctrl.Log.WithName("token-auth")
+
+	authErr := &Error{Reason: "unauthenticated", Code: http.StatusUnauthorized, msg: err.Error()}
+	errors.As(err, &authErr)
+	metrics.RecordAuthRejection(req.URL.Path, authErr.Reason)
+	setupLog.Info("Rejected webhook caller",
+		"reason", authErr.Reason,
+		"error", err.Error(),
+		"method", req.Method,
+		"url", req.URL.String(),
+		"remoteAddr", req.RemoteAddr,
+	)
+	http.Error(w, authErr.Error(), authErr.Code)
+}
+
+// bearerToken 从 Authorization 请求头中取出 bearer token
+func bearerToken(req *http.Request) string {
+	header := req.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(prefix):])
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newFakeClient 返回一个 fake clientset，TokenReview 按 token 返回 users 中对应的用户，并统计调用次数
+func newFakeClient(users map[string]authenticationv1.UserInfo, calls *int) *fake.Clientset {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		*calls++
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if user, ok := users[review.Spec.Token]; ok {
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: user}
+		} else {
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: false, Error: "invalid token"}
+		}
+		return true, review, nil
+	})
+	return client
+}
+
+func TestWithTokenAuth(t *testing.T) {
+	users := map[string]authenticationv1.UserInfo{
+		"apiserver-token": {Username: "system:apiserver"},
+		"group-token":     {Username: "someone", Groups: []string{"webhook-callers"}},
+		"other-token":     {Username: "someone-else", Groups: []string{"system:authenticated"}},
+	}
+
+	testCases := []struct {
+		name           string
+		authorization  string
+		expectedStatus int
+	}{
+		{
+			name:           "allowed user",
+			authorization:  "Bearer apiserver-token",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "allowed group",
+			authorization:  "bearer group-token",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "authenticated but not allowed",
+			authorization:  "Bearer other-token",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "invalid token",
+			authorization:  "Bearer unknown-token",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "missing token",
+			authorization:  "",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			authenticator, err := NewTokenAuthenticator(newFakeClient(users, &calls), time.Minute,
+				[]string{"system:apiserver"}, []string{"webhook-callers"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			handler := authenticator.WithTokenAuth(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/mutating-pod-dns", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", tc.expectedStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestWithBearerToken(t *testing.T) {
+	testCases := []struct {
+		name           string
+		authorization  string
+		expectedStatus int
+	}{
+		{name: "token present", authorization: "Bearer any-token", expectedStatus: http.StatusOK},
+		{name: "missing token", expectedStatus: http.StatusUnauthorized},
+		{name: "other scheme", authorization: "Basic dXNlcjpwYXNz", expectedStatus: http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := WithBearerToken(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodPost, "/mutating-pod-dns", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", tc.expectedStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestTokenAuthenticatorCache(t *testing.T) {
+	calls := 0
+	users := map[string]authenticationv1.UserInfo{"apiserver-token": {Username: "system:apiserver"}}
+	authenticator, err := NewTokenAuthenticator(newFakeClient(users, &calls), time.Minute, []string{"system:apiserver"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	authenticator.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := authenticator.Authenticate(context.Background(), "apiserver-token"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := authenticator.Authenticate(context.Background(), "unknown-token"); err == nil {
+			t.Fatal("expected unknown token to be rejected")
+		}
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 TokenReview calls within the TTL, got %d", calls)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := authenticator.Authenticate(context.Background(), "apiserver-token"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("expected the cached result to expire after the TTL, got %d TokenReview calls", calls)
+	}
+}
+
+func TestTokenAuthenticatorCacheBounded(t *testing.T) {
+	calls := 0
+	users := map[string]authenticationv1.UserInfo{"apiserver-token": {Username: "system:apiserver"}}
+	authenticator, err := NewTokenAuthenticator(newFakeClient(users, &calls), time.Hour, []string{"system:apiserver"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	authenticator.now = func() time.Time { return now }
+
+	if _, err := authenticator.Authenticate(context.Background(), "apiserver-token"); err != nil {
+		t.Fatal(err)
+	}
+	// 大量不同的无效 token 不会让缓存超过上限
+	for i := 0; i < 2*maxCacheEntries; i++ {
+		now = now.Add(time.Millisecond)
+		if _, err := authenticator.Authenticate(context.Background(), fmt.Sprintf("bogus-%d", i)); err == nil {
+			t.Fatal("expected bogus token to be rejected")
+		}
+	}
+	if got := len(authenticator.cache); got > maxCacheEntries {
+		t.Errorf("cache has %d entries, want at most %d", got, maxCacheEntries)
+	}
+
+	// 失败结果最多缓存 maxFailureTTL，成功结果缓存 ttl
+	calls = 0
+	now = now.Add(maxFailureTTL)
+	if _, err := authenticator.Authenticate(context.Background(), fmt.Sprintf("bogus-%d", 2*maxCacheEntries-1)); err == nil {
+		t.Fatal("expected bogus token to be rejected")
+	}
+	if calls != 1 {
+		t.Errorf("expected the failed result to expire after %v, got %d TokenReview calls", maxFailureTTL, calls)
+	}
+}
+
+func TestNewTokenAuthenticatorRequiresAllowList(t *testing.T) {
+	if _, err := NewTokenAuthenticator(fake.NewSimpleClientset(), time.Minute, nil, nil); err == nil {
+		t.Error("expected an error when no allowed users or groups are configured")
+	}
+}
@@ -2,8 +2,13 @@ package configs
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	ubzap "go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -17,6 +22,80 @@ type Config struct {
 	EnablePprof     bool
 	WebhookBindPort int
 	MetricsBindPort int
+	ShutdownTimeout time.Duration
+	// 收到退出信号后先让就绪检查失败，等待 Endpoints 摘除本副本，再停止接收新请求
+	ShutdownDrainDuration time.Duration
+
+	// TLS 证书配置
+	CertFile           string
+	KeyFile            string
+	CertReloadInterval time.Duration
+	TLSProfile         string
+	TLSMinVersion      string
+	TLSCipherSuites    StringSlice
+
+	// 自签证书配置，开启后不再依赖 cert-manager
+	CertBootstrap           bool
+	CertSecretName          string
+	CertValidity            time.Duration
+	CertRenewBefore         time.Duration
+	WebhookServiceName      string
+	WebhookServiceNamespace string
+
+	// 双向 TLS 配置，校验 kube-apiserver 的客户端证书
+	ClientAuthMode     string
+	ClientCAFile       string
+	ClientAllowedNames StringSlice
+
+	// bearer token 认证配置，通过 TokenReview 校验 kube-apiserver 的身份
+	TokenAuth          bool
+	TokenAuthCacheTTL  time.Duration
+	TokenAllowedUsers  StringSlice
+	TokenAllowedGroups StringSlice
+
+	// metrics 服务配置，开启后使用 HTTPS，并通过 TokenReview 和 SubjectAccessReview 校验访问 /metrics 的请求
+	MetricsSecure       bool
+	MetricsAuthCacheTTL time.Duration
+
+	// 每个 webhook endpoint 的并发限制，key 是 endpoint 路径；未单独配置的 endpoint 使用默认值
+	MaxInflight        IntMap
+	MaxQueue           IntMap
+	DefaultMaxInflight int
+	DefaultMaxQueue    int
+	QueueTimeout       time.Duration
+	FailurePolicies    StringMap
+
+	// 每个 webhook 处理函数的时间预算，key 是 endpoint 路径；超时后按 TimeoutFallbacks 返回 Allow 或 Deny
+	HandlerTimeouts  DurationMap
+	TimeoutFallbacks StringMap
+	// 每个 webhook 处理函数返回内部错误或 panic 时的响应，key 是 endpoint 路径，值为 Allow 或 Deny
+	ErrorPolicies StringMap
+
+	// 启用的 webhook，配置文件存在时以配置文件为准，文件变化后无需重启即可生效
+	EnabledWebhooks             StringSlice
+	WebhookConfigFile           string
+	WebhookConfigReloadInterval time.Duration
+
+	// 自注册 webhook 配置，启动时通过 server-side apply 创建或更新启用的 webhook 配置
+	WebhookSelfRegister     bool
+	WebhookUnregisterOnStop bool
+	WebhookRegisterForce    bool
+	WebhookRegisterInterval time.Duration
+	WebhookCABundleFile     string
+	WebhookConfigNamePrefix string
+	WebhookServicePort      int
+	// WebhookDispatch 为 true 时只注册 mutate 和 validate 两个 webhook 配置，失败策略和 selector 相同的 webhook 合并为其中的一个 webhook
+	WebhookDispatch bool
+
+	// cpu 超卖策略所在的 ConfigMap，为空时只使用节点的 cpu_oversell 标签
+	CPUOversellPolicyConfigMap string
+	CPUOversellPolicyNamespace string
+	// 节点超卖标签允许的比例范围，以及允许修改超卖标签和注解的用户和组，用户和组都为空时不限制修改者
+	OversellMinRatio      float64
+	OversellMaxRatio      float64
+	OversellAllowedUsers  StringSlice
+	OversellAllowedGroups StringSlice
+	OversellAllowAllUsers bool
 
 	// 其他配置项
 }
@@ -38,6 +117,56 @@ func InitConfig() {
 		flag.StringVar(&cfg.PprofAddr, "pprof-addr", "localhost:6060", "The address on which to expose the pprof handler")
 		flag.IntVar(&cfg.WebhookBindPort, "webhook_bind_address", 9443, "Secure port that the webhook-template listens on")
 		flag.IntVar(&cfg.MetricsBindPort, "metrics_bind_address", 8443, "Port that the metrics server listens on.")
+		flag.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "Maximum time to wait for each server or background worker to stop.")
+		flag.DurationVar(&cfg.ShutdownDrainDuration, "shutdown-drain-duration", 10*time.Second, "How long to keep serving after SIGTERM with readiness failing, so the Service stops routing new admission requests before the servers shut down. 0 disables draining.")
+		flag.StringVar(&cfg.CertFile, "tls-cert-file", "./certs/tls.crt", "File containing the x509 certificate for the webhook server.")
+		flag.StringVar(&cfg.KeyFile, "tls-private-key-file", "./certs/tls.key", "File containing the x509 private key matching --tls-cert-file.")
+		flag.DurationVar(&cfg.CertReloadInterval, "cert-reload-interval", 10*time.Second, "How often to check the certificate files for changes.")
+		flag.StringVar(&cfg.TLSProfile, "tls-profile", "Intermediate", "TLS security profile of the webhook server: Old, Intermediate, Modern or Custom.")
+		flag.StringVar(&cfg.TLSMinVersion, "tls-min-version", "VersionTLS12", "Minimum TLS version when --tls-profile=Custom: VersionTLS10, VersionTLS11, VersionTLS12 or VersionTLS13.")
+		flag.Var(&cfg.TLSCipherSuites, "tls-cipher-suites", "Comma-separated list of cipher suite names when --tls-profile=Custom, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Empty uses the Go defaults.")
+		flag.BoolVar(&cfg.CertBootstrap, "cert-bootstrap", false, "Generate a self-signed CA and serving certificate and inject the caBundle into the webhook configurations instead of relying on cert-manager.")
+		flag.StringVar(&cfg.CertSecretName, "cert-secret-name", "webhook-server-cert", "Name of the secret that stores the self-signed certificates.")
+		flag.DurationVar(&cfg.CertValidity, "cert-validity", 365*24*time.Hour, "Validity of the self-signed serving certificate.")
+		flag.DurationVar(&cfg.CertRenewBefore, "cert-renew-before", 30*24*time.Hour, "Re-issue the self-signed certificates this long before they expire.")
+		flag.StringVar(&cfg.WebhookServiceName, "webhook-service-name", "aloys-webhook-webhook-service", "Name of the Service in front of the webhook server.")
+		flag.StringVar(&cfg.WebhookServiceNamespace, "webhook-service-namespace", defaultNamespace(), "Namespace of the Service in front of the webhook server.")
+		flag.StringVar(&cfg.ClientAuthMode, "client-auth-mode", "off", "Client certificate authentication for admission calls: off, optional or required.")
+		flag.StringVar(&cfg.ClientCAFile, "client-ca-file", "", "CA bundle used to verify client certificates presented by the kube-apiserver.")
+		flag.Var(&cfg.ClientAllowedNames, "client-allowed-names", "Comma-separated list of client certificate CNs or SANs that may call the webhooks. Empty allows any verified client.")
+		flag.BoolVar(&cfg.TokenAuth, "webhook-token-auth", false, "Authenticate admission calls by reviewing the bearer token in the Authorization header with a TokenReview.")
+		flag.DurationVar(&cfg.TokenAuthCacheTTL, "webhook-token-cache-ttl", 2*time.Minute, "How long to cache TokenReview results.")
+		flag.Var(&cfg.TokenAllowedUsers, "webhook-token-allowed-users", "Comma-separated list of users allowed to call the webhooks when --webhook-token-auth is set.")
+		flag.Var(&cfg.TokenAllowedGroups, "webhook-token-allowed-groups", "Comma-separated list of groups allowed to call the webhooks when --webhook-token-auth is set.")
+		flag.BoolVar(&cfg.MetricsSecure, "metrics-secure", false, "Serve metrics over HTTPS with the webhook serving certificate, and require callers of /metrics to be authenticated and authorized. Health endpoints stay unauthenticated.")
+		flag.DurationVar(&cfg.MetricsAuthCacheTTL, "metrics-auth-cache-ttl", time.Minute, "How long to cache TokenReview and SubjectAccessReview results for the metrics endpoint.")
+		flag.Var(&cfg.MaxInflight, "webhook-max-inflight", "Comma-separated list of path=N setting the maximum number of concurrent requests per webhook endpoint, e.g. /mutating-cpu-oversell=20.")
+		flag.Var(&cfg.MaxQueue, "webhook-max-queue", "Comma-separated list of path=N setting how many requests may wait for a free slot per webhook endpoint.")
+		flag.IntVar(&cfg.DefaultMaxInflight, "webhook-default-max-inflight", 0, "Maximum number of concurrent requests for endpoints not listed in --webhook-max-inflight. 0 means unlimited.")
+		flag.IntVar(&cfg.DefaultMaxQueue, "webhook-default-max-queue", 0, "Queue length for endpoints not listed in --webhook-max-queue.")
+		flag.DurationVar(&cfg.QueueTimeout, "webhook-queue-timeout", 2*time.Second, "Maximum time a request waits in the queue before it is shed.")
+		flag.Var(&cfg.FailurePolicies, "webhook-failure-policy", "Comma-separated list of path=Fail|Ignore deciding whether shed requests are denied or allowed. Defaults to the failure policy the webhook is registered with.")
+		flag.Var(&cfg.HandlerTimeouts, "webhook-handler-timeout", "Comma-separated list of path=duration limiting how long a webhook handler may run, e.g. /mutating-pod-dns=3s. Handlers are always bounded by the timeout the API server sends.")
+		flag.Var(&cfg.TimeoutFallbacks, "webhook-timeout-fallback", "Comma-separated list of path=Allow|Deny deciding the response when a handler runs out of time. Defaults to Allow for webhooks registered with failurePolicy Ignore and Deny otherwise.")
+		flag.Var(&cfg.ErrorPolicies, "webhook-error-policy", "Comma-separated list of path=Allow|Deny deciding the response when a handler fails with an internal error or panics. Defaults to the error policy the webhook is registered with; /mutate and /validate default to Deny.")
+		flag.Var(&cfg.EnabledWebhooks, "enabled-webhooks", "Comma-separated list of registered webhook names to serve, \"*\" serves all of them. Empty serves the webhooks enabled by default.")
+		flag.StringVar(&cfg.WebhookConfigFile, "webhook-config-file", "", "YAML file with an enabledWebhooks list, usually a mounted ConfigMap. Overrides --enabled-webhooks and is reloaded when it changes.")
+		flag.DurationVar(&cfg.WebhookConfigReloadInterval, "webhook-config-reload-interval", 10*time.Second, "How often to check --webhook-config-file for changes.")
+		flag.BoolVar(&cfg.WebhookSelfRegister, "webhook-self-register", false, "Create or update the webhook configurations of the enabled webhooks at startup with server-side apply, and delete the ones of disabled webhooks.")
+		flag.BoolVar(&cfg.WebhookUnregisterOnStop, "webhook-unregister-on-stop", false, "Delete the self-registered webhook configurations when the manager stops. Only for single-replica or uninstall scenarios, a rolling update would remove the webhooks.")
+		flag.BoolVar(&cfg.WebhookRegisterForce, "webhook-register-force", false, "Take over fields of the self-registered webhook configurations that are managed by another field manager, e.g. Argo CD or Flux. By default a conflict fails the registration and logs the conflicting managers.")
+		flag.DurationVar(&cfg.WebhookRegisterInterval, "webhook-register-interval", time.Minute, "How often to re-apply the self-registered webhook configurations.")
+		flag.StringVar(&cfg.WebhookCABundleFile, "webhook-ca-bundle-file", "", "CA bundle to put into the self-registered webhook configurations when --cert-bootstrap is not set, e.g. ca.crt of the cert-manager secret. Empty leaves caBundle to the CA injector.")
+		flag.StringVar(&cfg.WebhookConfigNamePrefix, "webhook-config-name-prefix", "aloys-webhook-", "Prefix of the self-registered webhook configuration names, should match namePrefix of the kustomize overlay.")
+		flag.IntVar(&cfg.WebhookServicePort, "webhook-service-port", 9443, "Port of the webhook Service referenced by the self-registered webhook configurations.")
+		flag.BoolVar(&cfg.WebhookDispatch, "webhook-dispatch", false, "Self-register the enabled webhooks as one mutating and one validating configuration dispatched under /mutate and /validate, with one webhook per group of webhooks sharing failure policy and selectors, instead of one configuration per webhook.")
+		flag.StringVar(&cfg.CPUOversellPolicyConfigMap, "cpu-oversell-policy-configmap", "", "Name of the ConfigMap holding the cpu oversell policy in its policy.yaml key. The ConfigMap is watched and changes apply without restart. Empty resolves the ratio only from the cpu_oversell node label.")
+		flag.StringVar(&cfg.CPUOversellPolicyNamespace, "cpu-oversell-policy-namespace", defaultNamespace(), "Namespace of --cpu-oversell-policy-configmap.")
+		flag.Float64Var(&cfg.OversellMinRatio, "oversell-min-ratio", 0.1, "Smallest oversell ratio accepted in the <resource>_oversell node labels and in the --cpu-oversell-policy-configmap policy.")
+		flag.Float64Var(&cfg.OversellMaxRatio, "oversell-max-ratio", 10, "Largest oversell ratio accepted in the <resource>_oversell node labels and in the --cpu-oversell-policy-configmap policy.")
+		flag.Var(&cfg.OversellAllowedUsers, "oversell-allowed-users", "Comma-separated list of users allowed to change the oversell labels and annotations of nodes. Empty together with --oversell-allowed-groups only allows the kubelet of each node, unless --oversell-allow-all-users is set.")
+		flag.Var(&cfg.OversellAllowedGroups, "oversell-allowed-groups", "Comma-separated list of groups allowed to change the oversell labels and annotations of nodes, e.g. system:masters.")
+		flag.BoolVar(&cfg.OversellAllowAllUsers, "oversell-allow-all-users", false, "Allow everyone who can update nodes to change the oversell labels and annotations, ignoring --oversell-allowed-users and --oversell-allowed-groups. A warning is logged at startup.")
 
 		// 定义自定义的 Zap 选项
 		opts := zap.Options{
@@ -74,6 +203,124 @@ func InitConfig() {
 	})
 }
 
+// StringSlice 是逗号分隔的字符串列表参数，可以重复指定
+type StringSlice []string
+
+func (s *StringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *StringSlice) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*s = append(*s, v)
+		}
+	}
+	return nil
+}
+
+// StringMap 是逗号分隔的 key=value 参数，可以重复指定
+type StringMap map[string]string
+
+func (m *StringMap) String() string {
+	keys := make([]string, 0, len(*m))
+	for k := range *m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+(*m)[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m *StringMap) Set(value string) error {
+	if *m == nil {
+		*m = make(StringMap)
+	}
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return fmt.Errorf("invalid key=value pair %q", pair)
+		}
+		(*m)[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return nil
+}
+
+// IntMap 是逗号分隔的 key=N 参数，可以重复指定
+type IntMap map[string]int
+
+func (m *IntMap) String() string {
+	strs := make(StringMap, len(*m))
+	for k, v := range *m {
+		strs[k] = strconv.Itoa(v)
+	}
+	return strs.String()
+}
+
+func (m *IntMap) Set(value string) error {
+	var strs StringMap
+	if err := strs.Set(value); err != nil {
+		return err
+	}
+	if *m == nil {
+		*m = make(IntMap)
+	}
+	for k, v := range strs {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid value for %q: %w", k, err)
+		}
+		(*m)[k] = n
+	}
+	return nil
+}
+
+// DurationMap 是逗号分隔的 key=duration 参数，可以重复指定
+type DurationMap map[string]time.Duration
+
+func (m *DurationMap) String() string {
+	strs := make(StringMap, len(*m))
+	for k, v := range *m {
+		strs[k] = v.String()
+	}
+	return strs.String()
+}
+
+func (m *DurationMap) Set(value string) error {
+	var strs StringMap
+	if err := strs.Set(value); err != nil {
+		return err
+	}
+	if *m == nil {
+		*m = make(DurationMap)
+	}
+	for k, v := range strs {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid value for %q: %w", k, err)
+		}
+		(*m)[k] = d
+	}
+	return nil
+}
+
+// defaultNamespace 优先使用 POD_NAMESPACE 环境变量，其次读取 serviceaccount 挂载的 namespace 文件
+func defaultNamespace() string {
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns
+	}
+	if data, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace"); err == nil && len(data) > 0 {
+		return string(data)
+	}
+	return "aloys-webhook-system"
+}
+
 // GetConfig 返回全局配置实例
 func GetConfig() *Config {
 	if cfg == nil {
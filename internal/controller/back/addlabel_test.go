@@ -17,6 +17,7 @@ limitations under the License.
 package back
 
 import (
+	"context"
 	"encoding/json"
 	"reflect"
 	"testing"
@@ -60,7 +61,7 @@ func TestAddLabel(t *testing.T) {
 				t.Fatal(err)
 			}
 			review := admissionv1.AdmissionReview{Request: &v1.AdmissionRequest{Object: runtime.RawExtension{Raw: raw}}}
-			response := AddLabel(review)
+			response := AddLabel(context.Background(), review)
 			if response.Patch != nil {
 				patchObj, err := jsonpatch.DecodePatch(response.Patch)
 				if err != nil {
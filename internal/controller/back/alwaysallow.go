@@ -17,29 +17,25 @@ limitations under the License.
 package back
 
 import (
-	"fmt"
+	"context"
 	"time"
 
-	"github.com/aloys.zy/aloys-webhook-example/internal/setting"
+	"github.com/aloys.zy/aloys-webhook-example/internal/admission"
 	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 )
 
 // AlwaysAllowDelayFiveSeconds sleeps for five seconds and allows all requests made to this function.
-func AlwaysAllowDelayFiveSeconds(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
-	nodeResource := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "nodes"}
-	if ar.Request.Resource != nodeResource {
-		err := fmt.Errorf("expect resource to be %s", nodeResource)
-		klog.Error(err)
-		return setting.ToV1AdmissionResponse(err)
-	}
-	klog.V(2).Info("always-allow-with-delay sleeping for 5 seconds")
-	time.Sleep(5 * time.Second)
-	klog.V(2).Info("calling always-allow")
-	reviewResponse := admissionv1.AdmissionResponse{
-		Allowed: true,
-		Result:  &metav1.Status{Message: "this webhook-template allows all requests"},
-	}
-	return &reviewResponse
-}
+var AlwaysAllowDelayFiveSeconds = (&admission.Handler[*corev1.Node]{
+	Name:      "always-allow-delay-5s",
+	Resources: []metav1.GroupVersionResource{{Group: "", Version: "v1", Resource: "nodes"}},
+	New:       func() *corev1.Node { return &corev1.Node{} },
+	Validate: func(_ context.Context, _ *admissionv1.AdmissionRequest, _, _ *corev1.Node) error {
+		klog.V(2).Info("always-allow-with-delay sleeping for 5 seconds")
+		time.Sleep(5 * time.Second)
+		klog.V(2).Info("calling always-allow")
+		return nil
+	},
+}).AdmitV1
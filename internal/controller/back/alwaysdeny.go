@@ -17,16 +17,20 @@ limitations under the License.
 package back
 
 import (
+	"context"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/admission"
 	admissionv1 "k8s.io/api/admission/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 )
 
 // AlwaysDeny all requests made to this function.
-func AlwaysDeny(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
-	klog.V(2).Info("calling always-deny")
-	reviewResponse := admissionv1.AdmissionResponse{}
-	reviewResponse.Allowed = false
-	reviewResponse.Result = &metav1.Status{Message: "this webhook denies all requests"}
-	return &reviewResponse
-}
+var AlwaysDeny = (&admission.Handler[*metav1.PartialObjectMetadata]{
+	Name: "always-deny",
+	New:  func() *metav1.PartialObjectMetadata { return &metav1.PartialObjectMetadata{} },
+	Validate: func(_ context.Context, _ *admissionv1.AdmissionRequest, _, _ *metav1.PartialObjectMetadata) error {
+		klog.V(2).Info("calling always-deny")
+		return admission.Denied("this webhook denies all requests")
+	},
+}).AdmitV1
@@ -17,91 +17,64 @@ limitations under the License.
 package back
 
 import (
-	"github.com/aloys.zy/aloys-webhook-example/internal/setting"
+	"context"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/admission"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 )
 
-const (
-	configMapPatch1 string = `[
-         { "op": "add", "path": "/data/mutation-stage-1", "value": "yes" }
-     ]`
-	configMapPatch2 string = `[
-         { "op": "add", "path": "/data/mutation-stage-2", "value": "yes" }
-     ]`
-)
+var configMapResource = metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "configmaps"}
 
-// deny configmaps with specific key-value pair.
-func AdmitConfigMaps(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
-	klog.V(2).Info("admitting configmaps")
-	configMapResource := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "configmaps"}
-	if ar.Request.Resource != configMapResource {
-		klog.Errorf("expect resource to be %s", configMapResource)
-		return nil
-	}
+// AdmitConfigMaps deny configmaps with specific key-value pair.
+var AdmitConfigMaps = (&admission.Handler[*corev1.ConfigMap]{
+	Name:      "configmaps",
+	Resources: []metav1.GroupVersionResource{configMapResource},
+	New:       func() *corev1.ConfigMap { return &corev1.ConfigMap{} },
+	Validate:  admitConfigMap,
+}).AdmitV1
 
-	var raw []byte
-	if ar.Request.Operation == admissionv1.Delete {
-		raw = ar.Request.OldObject.Raw
-	} else {
-		raw = ar.Request.Object.Raw
-	}
-	configmap := corev1.ConfigMap{}
-	deserializer := setting.Codecs.UniversalDeserializer()
-	if _, _, err := deserializer.Decode(raw, nil, &configmap); err != nil {
-		klog.Error(err)
-		return setting.ToV1AdmissionResponse(err)
-	}
-	reviewResponse := admissionv1.AdmissionResponse{}
-	reviewResponse.Allowed = true
-	for k, v := range configmap.Data {
-		if k == "webhook-e2e-test" && v == "webhook-disallow" &&
-			(ar.Request.Operation == admissionv1.Create || ar.Request.Operation == admissionv1.Update) {
-			reviewResponse.Allowed = false
-			reviewResponse.Result = &metav1.Status{
-				Reason: "the configmap contains unwanted key and value",
-			}
-		}
-		if k == "webhook-e2e-test" && v == "webhook-nondeletable" && ar.Request.Operation == admissionv1.Delete {
-			reviewResponse.Allowed = false
-			reviewResponse.Result = &metav1.Status{
-				Reason: "the configmap cannot be deleted because it contains unwanted key and value",
-			}
-		}
+func admitConfigMap(_ context.Context, req *admissionv1.AdmissionRequest, configmap, _ *corev1.ConfigMap) error {
+	klog.V(2).Info("admitting configmaps")
+	switch v := configmap.Data["webhook-e2e-test"]; {
+	case v == "webhook-disallow" && (req.Operation == admissionv1.Create || req.Operation == admissionv1.Update):
+		return admission.Denied("the configmap contains unwanted key and value")
+	case v == "webhook-nondeletable" && req.Operation == admissionv1.Delete:
+		return admission.Denied("the configmap cannot be deleted because it contains unwanted key and value")
 	}
-	return &reviewResponse
+	return nil
 }
 
-func MutateConfigmaps(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+// MutateConfigmaps adds mutation-stage-1 and then mutation-stage-2 to the data of configmaps.
+var MutateConfigmaps = (&admission.Handler[*corev1.ConfigMap]{
+	Name:      "mutating-configmaps",
+	Resources: []metav1.GroupVersionResource{configMapResource},
+	New:       func() *corev1.ConfigMap { return &corev1.ConfigMap{} },
+	Mutate:    mutateConfigMap,
+}).AdmitV1
+
+func mutateConfigMap(_ context.Context, _ *admissionv1.AdmissionRequest, configmap, _ *corev1.ConfigMap) error {
 	klog.V(2).Info("mutating configmaps")
-	configMapResource := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "configmaps"}
-	if ar.Request.Resource != configMapResource {
-		klog.Errorf("expect resource to be %s", configMapResource)
-		return nil
-	}
+	configmap.Data = mutateStages(configmap.Data)
+	return nil
+}
 
-	raw := ar.Request.Object.Raw
-	configmap := corev1.ConfigMap{}
-	deserializer := setting.Codecs.UniversalDeserializer()
-	if _, _, err := deserializer.Decode(raw, nil, &configmap); err != nil {
-		klog.Error(err)
-		return setting.ToV1AdmissionResponse(err)
+// mutateStages 依次添加 mutation-stage-1 和 mutation-stage-2，用于验证多个 mutating webhook 的调用顺序
+func mutateStages(data map[string]string) map[string]string {
+	start, stage1 := data["mutation-start"] == "yes", data["mutation-stage-1"] == "yes"
+	if !start && !stage1 {
+		return data
 	}
-	reviewResponse := admissionv1.AdmissionResponse{}
-	reviewResponse.Allowed = true
-	if configmap.Data["mutation-start"] == "yes" {
-		reviewResponse.Patch = []byte(configMapPatch1)
+	if data == nil {
+		data = map[string]string{}
 	}
-	if configmap.Data["mutation-stage-1"] == "yes" {
-		reviewResponse.Patch = []byte(configMapPatch2)
+	if start {
+		data["mutation-stage-1"] = "yes"
 	}
-
-	if len(reviewResponse.Patch) != 0 {
-		pt := admissionv1.PatchTypeJSONPatch
-		reviewResponse.PatchType = &pt
+	if stage1 {
+		data["mutation-stage-2"] = "yes"
 	}
-
-	return &reviewResponse
+	return data
 }
@@ -17,59 +17,31 @@ limitations under the License.
 package back
 
 import (
-	"fmt"
+	"context"
 
-	"github.com/aloys.zy/aloys-webhook-example/internal/setting"
+	"github.com/aloys.zy/aloys-webhook-example/internal/admission"
 	admissionv1 "k8s.io/api/admission/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/klog/v2"
 )
 
 // AdmitCRD This function expects all CRDs submitted to it to be apiextensions.k8s.io/v1beta1 or apiextensions.k8s.io/v1.
-func AdmitCRD(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
-	klog.V(2).Info("admitting crd")
-
-	resource := "customresourcedefinitions"
-	v1beta1GVR := metav1.GroupVersionResource{Group: apiextensionsv1beta1.GroupName, Version: "v1beta1", Resource: resource}
-	v1GVR := metav1.GroupVersionResource{Group: apiextensionsv1.GroupName, Version: "v1", Resource: resource}
-
-	reviewResponse := admissionv1.AdmissionResponse{}
-	reviewResponse.Allowed = true
-
-	raw := ar.Request.Object.Raw
-	var labels map[string]string
-
-	switch ar.Request.Resource {
-	case v1beta1GVR:
-		crd := apiextensionsv1beta1.CustomResourceDefinition{}
-		deserializer := setting.Codecs.UniversalDeserializer()
-		if _, _, err := deserializer.Decode(raw, nil, &crd); err != nil {
-			klog.Error(err)
-			return setting.ToV1AdmissionResponse(err)
+// 只检查标签，两个版本都用 Unstructured 解码
+var AdmitCRD = (&admission.Handler[*unstructured.Unstructured]{
+	Name: "crd",
+	Resources: []metav1.GroupVersionResource{
+		{Group: apiextensionsv1beta1.GroupName, Version: "v1beta1", Resource: "customresourcedefinitions"},
+		{Group: apiextensionsv1.GroupName, Version: "v1", Resource: "customresourcedefinitions"},
+	},
+	New: func() *unstructured.Unstructured { return &unstructured.Unstructured{} },
+	Validate: func(_ context.Context, _ *admissionv1.AdmissionRequest, crd, _ *unstructured.Unstructured) error {
+		klog.V(2).Info("admitting crd")
+		if crd.GetLabels()["webhook-e2e-test"] == "webhook-disallow" {
+			return admission.Denied("the crd contains unwanted label")
 		}
-		labels = crd.Labels
-	case v1GVR:
-		crd := apiextensionsv1.CustomResourceDefinition{}
-		deserializer := setting.Codecs.UniversalDeserializer()
-		if _, _, err := deserializer.Decode(raw, nil, &crd); err != nil {
-			klog.Error(err)
-			return setting.ToV1AdmissionResponse(err)
-		}
-		labels = crd.Labels
-	default:
-		err := fmt.Errorf("expect resource to be one of [%v, %v] but got %v", v1beta1GVR, v1GVR, ar.Request.Resource)
-		klog.Error(err)
-		return setting.ToV1AdmissionResponse(err)
-	}
-
-	if v, ok := labels["webhook-e2e-test"]; ok {
-		if v == "webhook-disallow" {
-			reviewResponse.Allowed = false
-			reviewResponse.Result = &metav1.Status{Message: "the crd contains unwanted label"}
-		}
-	}
-	return &reviewResponse
-
-}
+		return nil
+	},
+}).AdmitV1
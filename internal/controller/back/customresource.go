@@ -17,88 +17,51 @@ limitations under the License.
 package back
 
 import (
-	"encoding/json"
+	"context"
 
-	"github.com/aloys.zy/aloys-webhook-example/internal/setting"
+	"github.com/aloys.zy/aloys-webhook-example/internal/admission"
 	admissionv1 "k8s.io/api/admission/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/klog/v2"
 )
 
-const (
-	customResourcePatch1 string = `[
-         { "op": "add", "path": "/data/mutation-stage-1", "value": "yes" }
-     ]`
-	customResourcePatch2 string = `[
-         { "op": "add", "path": "/data/mutation-stage-2", "value": "yes" }
-     ]`
-)
+// MutateCustomResource adds mutation-stage-1 and then mutation-stage-2 to the data of custom resources.
+var MutateCustomResource = (&admission.Handler[*unstructured.Unstructured]{
+	Name:   "mutating-custom-resource",
+	New:    func() *unstructured.Unstructured { return &unstructured.Unstructured{} },
+	Mutate: mutateCustomResource,
+}).AdmitV1
 
-func MutateCustomResource(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+func mutateCustomResource(_ context.Context, _ *admissionv1.AdmissionRequest, cr, _ *unstructured.Unstructured) error {
 	klog.V(2).Info("mutating custom resource")
-	cr := struct {
-		metav1.ObjectMeta
-		Data map[string]string
-	}{}
-
-	raw := ar.Request.Object.Raw
-	err := json.Unmarshal(raw, &cr)
+	data, _, err := unstructured.NestedStringMap(cr.Object, "data")
 	if err != nil {
-		klog.Error(err)
-		return setting.ToV1AdmissionResponse(err)
-	}
-
-	reviewResponse := admissionv1.AdmissionResponse{}
-	reviewResponse.Allowed = true
-
-	if cr.Data["mutation-start"] == "yes" {
-		reviewResponse.Patch = []byte(customResourcePatch1)
+		return err
 	}
-	if cr.Data["mutation-stage-1"] == "yes" {
-		reviewResponse.Patch = []byte(customResourcePatch2)
+	if data = mutateStages(data); data == nil {
+		return nil
 	}
-	if len(reviewResponse.Patch) != 0 {
-		pt := admissionv1.PatchTypeJSONPatch
-		reviewResponse.PatchType = &pt
-	}
-	return &reviewResponse
+	return unstructured.SetNestedStringMap(cr.Object, data, "data")
 }
 
-func AdmitCustomResource(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
-	klog.V(2).Info("admitting custom resource")
-	cr := struct {
-		metav1.ObjectMeta
-		Data map[string]string
-	}{}
+// AdmitCustomResource denies custom resources with specific key-value pair.
+var AdmitCustomResource = (&admission.Handler[*unstructured.Unstructured]{
+	Name:     "custom-resource",
+	New:      func() *unstructured.Unstructured { return &unstructured.Unstructured{} },
+	Validate: admitCustomResource,
+}).AdmitV1
 
-	var raw []byte
-	if ar.Request.Operation == admissionv1.Delete {
-		raw = ar.Request.OldObject.Raw
-	} else {
-		raw = ar.Request.Object.Raw
-	}
-	err := json.Unmarshal(raw, &cr)
+func admitCustomResource(_ context.Context, req *admissionv1.AdmissionRequest, cr, _ *unstructured.Unstructured) error {
+	klog.V(2).Info("admitting custom resource")
+	data, _, err := unstructured.NestedStringMap(cr.Object, "data")
 	if err != nil {
-		klog.Error(err)
-		return setting.ToV1AdmissionResponse(err)
+		return err
 	}
-
-	reviewResponse := admissionv1.AdmissionResponse{}
-	reviewResponse.Allowed = true
-	for k, v := range cr.Data {
-		if k == "webhook-e2e-test" && v == "webhook-disallow" &&
-			(ar.Request.Operation == admissionv1.Create || ar.Request.Operation == admissionv1.Update) {
-			reviewResponse.Allowed = false
-			reviewResponse.Result = &metav1.Status{
-				Reason: "the custom resource contains unwanted data",
-			}
-		}
-		if k == "webhook-e2e-test" && v == "webhook-nondeletable" && ar.Request.Operation == admissionv1.Delete {
-			reviewResponse.Allowed = false
-			reviewResponse.Result = &metav1.Status{
-				Reason: "the custom resource cannot be deleted because it contains unwanted key and value",
-			}
-		}
+	switch v := data["webhook-e2e-test"]; {
+	case v == "webhook-disallow" && (req.Operation == admissionv1.Create || req.Operation == admissionv1.Update):
+		return admission.Denied("the custom resource contains unwanted data")
+	case v == "webhook-nondeletable" && req.Operation == admissionv1.Delete:
+		return admission.Denied("the custom resource cannot be deleted because it contains unwanted key and value")
 	}
-	return &reviewResponse
+	return nil
 }
@@ -16,95 +16,96 @@ limitations under the License.
 package back
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
+	"github.com/aloys.zy/aloys-webhook-example/internal/admission"
 	"github.com/aloys.zy/aloys-webhook-example/internal/configs"
-	"github.com/aloys.zy/aloys-webhook-example/internal/setting"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 )
 
-const (
-	podsInitContainerPatch string = `[
-		 {"op":"add","path":"/spec/initContainers","value":[{"image":"webhook-template-added-image","name":"webhook-template-added-init-container","resources":{}}]}
-	]`
-	podsSidecarPatch string = `[
-		{"op":"add", "path":"/spec/containers/-","value":{"image":"%v","name":"webhook-template-added-sidecar","resources":{}}}
-	]`
-)
+var podResource = metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
+
+func newPod() *corev1.Pod { return &corev1.Pod{} }
 
 // AdmitPods only allow pods to pull images from specific registry.
-func AdmitPods(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+var AdmitPods = (&admission.Handler[*corev1.Pod]{
+	Name:      "pods",
+	Resources: []metav1.GroupVersionResource{podResource},
+	New:       newPod,
+	Validate:  admitPod,
+}).AdmitV1
+
+func admitPod(_ context.Context, _ *admissionv1.AdmissionRequest, pod, _ *corev1.Pod) error {
 	klog.V(2).Info("admitting pods")
-	podResource := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
-	if ar.Request.Resource != podResource {
-		err := fmt.Errorf("expect resource to be %s", podResource)
-		klog.Error(err)
-		return setting.ToV1AdmissionResponse(err)
-	}
-
-	raw := ar.Request.Object.Raw
-	pod := corev1.Pod{}
-	deserializer := setting.Codecs.UniversalDeserializer()
-	if _, _, err := deserializer.Decode(raw, nil, &pod); err != nil {
-		klog.Error(err)
-		return setting.ToV1AdmissionResponse(err)
-	}
-	reviewResponse := admissionv1.AdmissionResponse{}
-	reviewResponse.Allowed = true
-
 	var msg string
 	if v, ok := pod.Labels["webhook-template-e2e-test"]; ok {
 		if v == "webhook-template-disallow" {
-			reviewResponse.Allowed = false
 			msg = msg + "the pod contains unwanted label; "
 		}
 		if v == "wait-forever" {
-			reviewResponse.Allowed = false
 			msg = msg + "the pod response should not be sent; "
 			<-make(chan int) // Sleep forever - no one sends to this channel
 		}
 	}
 	for _, container := range pod.Spec.Containers {
 		if strings.Contains(container.Name, "webhook-template-disallow") {
-			reviewResponse.Allowed = false
 			msg = msg + "the pod contains unwanted container name; "
 		}
 	}
-	if !reviewResponse.Allowed {
-		reviewResponse.Result = &metav1.Status{Message: strings.TrimSpace(msg)}
+	if msg != "" {
+		return admission.Denied(strings.TrimSpace(msg))
 	}
-	return &reviewResponse
+	return nil
 }
 
-func MutatePods(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
-	shouldPatchPod := func(pod *corev1.Pod) bool {
-		if pod.Name != "webhook-template-to-be-mutated" {
-			return false
-		}
-		return !hasContainer(pod.Spec.InitContainers, "webhook-template-added-init-container")
+// MutatePods adds an init container to the pod named webhook-template-to-be-mutated.
+var MutatePods = (&admission.Handler[*corev1.Pod]{
+	Name:      "mutating-pods",
+	Resources: []metav1.GroupVersionResource{podResource},
+	New:       newPod,
+	Mutate:    mutatePod,
+}).AdmitV1
+
+func mutatePod(_ context.Context, _ *admissionv1.AdmissionRequest, pod, _ *corev1.Pod) error {
+	klog.V(2).Info("mutating pods")
+	if pod.Name != "webhook-template-to-be-mutated" ||
+		hasContainer(pod.Spec.InitContainers, "webhook-template-added-init-container") {
+		return nil
 	}
-	return applyPodPatch(ar, shouldPatchPod, podsInitContainerPatch)
+	pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{
+		Name:  "webhook-template-added-init-container",
+		Image: "webhook-template-added-image",
+	})
+	return nil
 }
 
-func MutatePodsSidecar(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
-	if configs.GetConfig().SidecarImage == "" {
-		return &admissionv1.AdmissionResponse{
-			Allowed: false,
-			Result: &metav1.Status{
-				Status:  "Failure",
-				Message: "No image specified by the sidecar-image parameter",
-				Code:    500,
-			},
-		}
+// MutatePodsSidecar adds a sidecar container with the image specified by the sidecar-image parameter.
+var MutatePodsSidecar = (&admission.Handler[*corev1.Pod]{
+	Name:      "mutating-pods-sidecar",
+	Resources: []metav1.GroupVersionResource{podResource},
+	New:       newPod,
+	Mutate:    mutatePodSidecar,
+}).AdmitV1
+
+func mutatePodSidecar(_ context.Context, _ *admissionv1.AdmissionRequest, pod, _ *corev1.Pod) error {
+	image := configs.GetConfig().SidecarImage
+	if image == "" {
+		return fmt.Errorf("no image specified by the sidecar-image parameter")
 	}
-	shouldPatchPod := func(pod *corev1.Pod) bool {
-		return !hasContainer(pod.Spec.Containers, "webhook-template-added-sidecar")
+	klog.V(2).Info("mutating pods")
+	if hasContainer(pod.Spec.Containers, "webhook-template-added-sidecar") {
+		return nil
 	}
-	return applyPodPatch(ar, shouldPatchPod, fmt.Sprintf(podsSidecarPatch, configs.GetConfig().SidecarImage))
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+		Name:  "webhook-template-added-sidecar",
+		Image: image,
+	})
+	return nil
 }
 
 func hasContainer(containers []corev1.Container, containerName string) bool {
@@ -116,65 +117,26 @@ func hasContainer(containers []corev1.Container, containerName string) bool {
 	return false
 }
 
-func applyPodPatch(ar admissionv1.AdmissionReview, shouldPatchPod func(*corev1.Pod) bool, patch string) *admissionv1.AdmissionResponse {
-	klog.V(2).Info("mutating pods")
-	podResource := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
-	if ar.Request.Resource != podResource {
-		klog.Errorf("expect resource to be %s", podResource)
-		return nil
-	}
-
-	raw := ar.Request.Object.Raw
-	pod := corev1.Pod{}
-	deserializer := setting.Codecs.UniversalDeserializer()
-	if _, _, err := deserializer.Decode(raw, nil, &pod); err != nil {
-		klog.Error(err)
-		return setting.ToV1AdmissionResponse(err)
-	}
-	reviewResponse := admissionv1.AdmissionResponse{}
-	reviewResponse.Allowed = true
-	if shouldPatchPod(&pod) {
-		reviewResponse.Patch = []byte(patch)
-		pt := admissionv1.PatchTypeJSONPatch
-		reviewResponse.PatchType = &pt
-	}
-	return &reviewResponse
-}
-
 // DenySpecificAttachment denies `kubectl attach to-be-attached-pod -i -c=container1"
 // or equivalent client requests.
-func DenySpecificAttachment(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+var DenySpecificAttachment = (&admission.Handler[*corev1.PodAttachOptions]{
+	Name:      "pods-attach",
+	Resources: []metav1.GroupVersionResource{podResource},
+	New:       func() *corev1.PodAttachOptions { return &corev1.PodAttachOptions{} },
+	Validate:  denySpecificAttachment,
+}).AdmitV1
+
+func denySpecificAttachment(_ context.Context, req *admissionv1.AdmissionRequest, podAttachOptions, _ *corev1.PodAttachOptions) error {
 	klog.V(2).Info("handling attaching pods")
-	if ar.Request.Name != "to-be-attached-pod" {
-		return &admissionv1.AdmissionResponse{Allowed: true}
-	}
-	podResource := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
-	if e, a := podResource, ar.Request.Resource; e != a {
-		err := fmt.Errorf("expect resource to be %s, got %s", e, a)
-		klog.Error(err)
-		return setting.ToV1AdmissionResponse(err)
+	if req.Name != "to-be-attached-pod" {
+		return nil
 	}
-	if e, a := "attach", ar.Request.SubResource; e != a {
-		err := fmt.Errorf("expect subresource to be %s, got %s", e, a)
-		klog.Error(err)
-		return setting.ToV1AdmissionResponse(err)
-	}
-
-	raw := ar.Request.Object.Raw
-	podAttachOptions := corev1.PodAttachOptions{}
-	deserializer := setting.Codecs.UniversalDeserializer()
-	if _, _, err := deserializer.Decode(raw, nil, &podAttachOptions); err != nil {
-		klog.Error(err)
-		return setting.ToV1AdmissionResponse(err)
+	if e, a := "attach", req.SubResource; e != a {
+		return fmt.Errorf("expect subresource to be %s, got %s", e, a)
 	}
 	klog.V(2).Info(fmt.Sprintf("podAttachOptions=%#v\n", podAttachOptions))
 	if !podAttachOptions.Stdin || podAttachOptions.Container != "container1" {
-		return &admissionv1.AdmissionResponse{Allowed: true}
-	}
-	return &admissionv1.AdmissionResponse{
-		Allowed: false,
-		Result: &metav1.Status{
-			Message: "attaching to pod 'to-be-attached-pod' is not allowed",
-		},
+		return nil
 	}
+	return admission.Denied("attaching to pod 'to-be-attached-pod' is not allowed")
 }
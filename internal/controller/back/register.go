@@ -0,0 +1,121 @@
+package back
+
+import (
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/registry"
+	"github.com/aloys.zy/aloys-webhook-example/internal/setting"
+)
+
+// rule 生成单个 RuleWithOperations
+func rule(group, version string, resources []string, ops ...admissionregistrationv1.OperationType) []admissionregistrationv1.RuleWithOperations {
+	return []admissionregistrationv1.RuleWithOperations{{
+		Operations: ops,
+		Rule: admissionregistrationv1.Rule{
+			APIGroups:   []string{group},
+			APIVersions: []string{version},
+			Resources:   resources,
+		},
+	}}
+}
+
+// 这里的 webhook 来自 Kubernetes e2e 测试的示例，默认不提供服务
+func init() {
+	const (
+		create  = admissionregistrationv1.Create
+		update  = admissionregistrationv1.Update
+		del     = admissionregistrationv1.Delete
+		connect = admissionregistrationv1.Connect
+	)
+
+	descriptors := []registry.Descriptor{
+		{
+			Name:    "always-allow-delay-5s",
+			Path:    "/always-allow-delay-5s",
+			Type:    registry.Validating,
+			Rules:   rule("", "v1", []string{"nodes"}, create, update),
+			Handler: setting.NewDelegateToV1AdmitHandler(AlwaysAllowDelayFiveSeconds),
+		},
+		{
+			Name:    "always-deny",
+			Path:    "/always-deny",
+			Type:    registry.Validating,
+			Rules:   rule("", "v1", []string{"configmaps"}, create),
+			Handler: setting.NewDelegateToV1AdmitHandler(AlwaysDeny),
+		},
+		{
+			Name:    "add-label",
+			Path:    "/add-label",
+			Type:    registry.Mutating,
+			Rules:   rule("", "v1", []string{"configmaps"}, create, update),
+			Handler: setting.NewDelegateToV1AdmitHandler(AddLabel),
+		},
+		{
+			Name:    "pods",
+			Path:    "/pods",
+			Type:    registry.Validating,
+			Rules:   rule("", "v1", []string{"pods"}, create, update),
+			Handler: setting.NewDelegateToV1AdmitHandler(AdmitPods),
+		},
+		{
+			Name:    "pods-attach",
+			Path:    "/pods/attach",
+			Type:    registry.Validating,
+			Rules:   rule("", "v1", []string{"pods/attach"}, connect),
+			Handler: setting.NewDelegateToV1AdmitHandler(DenySpecificAttachment),
+		},
+		{
+			Name:    "mutating-pods",
+			Path:    "/mutating-pods",
+			Type:    registry.Mutating,
+			Rules:   rule("", "v1", []string{"pods"}, create),
+			Handler: setting.NewDelegateToV1AdmitHandler(MutatePods),
+		},
+		{
+			Name:    "mutating-pods-sidecar",
+			Path:    "/mutating-pods-sidecar",
+			Type:    registry.Mutating,
+			Rules:   rule("", "v1", []string{"pods"}, create),
+			Handler: setting.NewDelegateToV1AdmitHandler(MutatePodsSidecar),
+		},
+		{
+			Name:    "configmaps",
+			Path:    "/configmaps",
+			Type:    registry.Validating,
+			Rules:   rule("", "v1", []string{"configmaps"}, create, update, del),
+			Handler: setting.NewDelegateToV1AdmitHandler(AdmitConfigMaps),
+		},
+		{
+			Name:    "mutating-configmaps",
+			Path:    "/mutating-configmaps",
+			Type:    registry.Mutating,
+			Rules:   rule("", "v1", []string{"configmaps"}, create, update),
+			Handler: setting.NewDelegateToV1AdmitHandler(MutateConfigmaps),
+		},
+		{
+			Name:    "custom-resource",
+			Path:    "/custom-resource",
+			Type:    registry.Validating,
+			Rules:   rule("stable.example.com", "v1", []string{"*"}, create, update, del),
+			Handler: setting.NewDelegateToV1AdmitHandler(AdmitCustomResource),
+		},
+		{
+			Name:    "mutating-custom-resource",
+			Path:    "/mutating-custom-resource",
+			Type:    registry.Mutating,
+			Rules:   rule("stable.example.com", "v1", []string{"*"}, create, update),
+			Handler: setting.NewDelegateToV1AdmitHandler(MutateCustomResource),
+		},
+		{
+			Name:    "crd",
+			Path:    "/crd",
+			Type:    registry.Validating,
+			Rules:   rule("apiextensions.k8s.io", "*", []string{"customresourcedefinitions"}, create),
+			Handler: setting.NewDelegateToV1AdmitHandler(AdmitCRD),
+		},
+	}
+	for _, d := range descriptors {
+		d.DisabledByDefault = true
+		registry.Register(d)
+	}
+}
@@ -0,0 +1,8 @@
+// Package controller 导入所有 webhook 处理函数所在的包，这些包在 init 中把 webhook 注册到 registry
+package controller
+
+import (
+	_ "github.com/aloys.zy/aloys-webhook-example/internal/controller/back"
+	_ "github.com/aloys.zy/aloys-webhook-example/internal/controller/cpu_oversell"
+	_ "github.com/aloys.zy/aloys-webhook-example/internal/controller/pod_dns"
+)
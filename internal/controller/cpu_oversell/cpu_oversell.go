@@ -1,17 +1,18 @@
 package cpu_oversell
 
 import (
+	"context"
 	"fmt"
-	"math"
 	"strconv"
+	"strings"
 
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 
-	"github.com/aloys.zy/aloys-webhook-example/internal/setting"
 	"github.com/aloys.zy/aloys-webhook-example/internal/util"
 )
 
@@ -19,110 +20,218 @@ const (
 	CPUOversell = "cpu_oversell"
 )
 
-// MutateCPUOversell 处理节点的 AdmissionReview 请求，根据 cpu_oversell 标签调整 allocatable.cpu
-func MutateCPUOversell(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+// nodeResource 是节点资源，kubelet 上报状态时 SubResource 为 status
+var nodeResource = metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "nodes"}
+
+// oversellResult 是一种资源超卖的结果，用于事件和日志
+type oversellResult struct {
+	name     corev1.ResourceName
+	base     resource.Quantity
+	baseKind Base
+	value    resource.Quantity
+	ratio    float64
+	source   string
+	clamped  string
+	oversold bool
+}
+
+func (r oversellResult) String() string {
+	s := fmt.Sprintf("%s %s -> %s (ratio %v of %s from %s", r.name, r.base.String(), r.value.String(), r.ratio, strings.ToLower(string(r.baseKind)), r.source)
+	if r.clamped != "" {
+		s += ", clamped to " + r.clamped
+	}
+	return s + ")"
+}
+
+// MutateCPUOversell 根据节点标签或超卖策略调整节点的 allocatable，支持 cpu、memory、ephemeral-storage 和 pods。
+// 每种资源的注解（比如 cpu_oversell）记录是否超卖，修改的资源汇总在一个事件中。
+//
+// 超卖时在注解中记录 kubelet 上报的原始 allocatable 和使用的比例（比如 cpu_oversell_original_allocatable、
+// cpu_oversell_ratio），容量规划工具可以从注解读取节点的真实容量；关闭超卖后恢复原始的 allocatable。
+func MutateCPUOversell(ctx context.Context, req *admissionv1.AdmissionRequest, node, old *corev1.Node) error {
+	return nodeOversell{recorder: util.EventRecorder()}.mutate(ctx, req, node, old)
+}
+
+// nodeOversell 调整节点的 allocatable，修改和无效的标签通过 recorder 记录在节点的事件中
+type nodeOversell struct {
+	recorder record.EventRecorder
+}
+
+func (m nodeOversell) mutate(_ context.Context, req *admissionv1.AdmissionRequest, node, old *corev1.Node) error {
 	setupLog := ctrl.Log.WithName("MutateCPUOversell")
 
-	nodeResource := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "nodes"}
+	var changed []string
+	for _, name := range Resources {
+		key := LabelKey(name)
+
+		// 请求中的 allocatable 不是 kubelet 新上报的值时，先恢复为记录的原始值，避免在超卖后的值上重复超卖
+		original, recorded := originalAllocatable(node, old, name)
+		if recorded {
+			node.Status.Allocatable[name] = original
+		}
+
+		result, err := oversellResource(node, name)
+		if err != nil && shouldUpdateAnnotation(node, key, "false") {
+			// 如果标签无效或解析失败，设置 annotation 为 "false" 并允许请求通过；
+			// 只在注解变化时记录事件，kubelet 每次上报状态都会经过这里
+			m.updateInvalidLabel(node, key, "false", fmt.Sprintf("Invalid value for %s label on node %s: %v", key, node.Name, err))
+		}
+
+		// 如果不需要修改 allocatable，cpu 总是记录注解，其他资源只在之前超卖过时改为 "false"
+		if err != nil || !result.oversold {
+			_, annotated := node.GetAnnotations()[key]
+			if err == nil && (name == corev1.ResourceCPU || annotated) && shouldUpdateAnnotation(node, key, "false") {
+				m.updateInvalidLabel(node, key, "false", fmt.Sprintf("Added or updated annotation %s with value 'false'.", key))
+			}
+			if recorded {
+				changed = append(changed, fmt.Sprintf("%s restored to %s", name, original.String()))
+			}
+			forgetOriginal(node, req, name)
+			continue
+		}
+
+		if node.Status.Allocatable == nil {
+			node.Status.Allocatable = corev1.ResourceList{}
+		}
+		if !recorded {
+			original = node.Status.Allocatable[name]
+		}
+		node.Status.Allocatable[name] = result.value
+		util.UpdateAnnotationForInvalidLabel(node, key, "true")
+		util.UpdateAnnotationForInvalidLabel(node, OriginalAllocatableAnnotation(name), original.String())
+		util.UpdateAnnotationForInvalidLabel(node, RatioAnnotation(name), strconv.FormatFloat(result.ratio, 'f', -1, 64))
+		// kubelet 每次上报状态都会重新计算，超卖后的值和比例没有变化时不记录事件
+		if !alreadyOversold(old, result) {
+			changed = append(changed, result.String())
+		}
+	}
 
-	// 检查请求是否针对节点资源
-	if ar.Request.Resource != nodeResource {
-		setupLog.Error(nil, "InvalidResource",
-			"expected resource to be ", nodeResource,
-			"got", ar.Request.Resource)
-		return util.GeneratePatchAndResponse(nil, nil, false, "", fmt.Sprintf("expected resource to be %s", nodeResource))
+	if len(changed) == 0 {
+		setupLog.V(1).Info("No changes needed for allocatable", "node", node.Name)
+		return nil
 	}
+	message := "Allocatable updated: " + strings.Join(changed, ", ")
+	m.recorder.Eventf(node, corev1.EventTypeNormal, "Modified", message)
+	setupLog.Info(message, "node", node.Name)
+	return nil
+}
 
-	// 解码传入的节点对象
-	var node corev1.Node
-	deserializer := setting.Codecs.UniversalDeserializer()
-	if _, _, err := deserializer.Decode(ar.Request.Object.Raw, nil, &node); err != nil {
-		setupLog.Error(err, "Failed to decode node object")
-		return setting.ToV1AdmissionResponse(err)
+// alreadyOversold 判断旧对象是否已经按相同的比例超卖为相同的 allocatable
+func alreadyOversold(old *corev1.Node, result oversellResult) bool {
+	if old == nil {
+		return false
+	}
+	annotations := old.GetAnnotations()
+	if annotations[LabelKey(result.name)] != "true" ||
+		annotations[RatioAnnotation(result.name)] != strconv.FormatFloat(result.ratio, 'f', -1, 64) {
+		return false
 	}
+	previous, ok := old.Status.Allocatable[result.name]
+	return ok && previous.Cmp(result.value) == 0
+}
 
-	// 保存原始节点对象的副本，用于生成 Patch
-	originalNode := node.DeepCopy()
+// oversellResource 按节点标签和超卖策略计算一种资源超卖后的 allocatable
+func oversellResource(node *corev1.Node, name corev1.ResourceName) (oversellResult, error) {
+	setupLog := ctrl.Log.WithName("oversellResource")
 
-	// 检查是否需要修改 allocatable.cpu
-	shouldModify, newAllocatableCPU, err := shouldModifyAllocatableCPU(&node)
+	oversell, source, ok, err := resolveOversell(node, name)
 	if err != nil {
-		// 如果标签无效或解析失败，设置 annotation 为 "false" 并允许请求通过
-		updateInvalidLabel(&node, CPUOversell, "false", fmt.Sprintf("Invalid value for %s label on node %s: %v", CPUOversell, node.Name, err))
-		return util.GeneratePatchAndResponse(originalNode, &node, true, "", err.Error())
+		setupLog.Error(err, "Invalid value for oversell label", "node", node.Name, "label", LabelKey(name))
+		return oversellResult{}, err
+	}
+	// 没有标签、策略没有匹配或节点被排除时，不修改 allocatable
+	if !ok {
+		return oversellResult{name: name, source: source}, nil
 	}
 
-	// 如果不需要修改 allocatable.cpu
-	if !shouldModify {
-		if shouldUpdateAnnotation(&node, CPUOversell, "false") {
-			updateInvalidLabel(&node, CPUOversell, "false", "Added or updated annotation with value 'false'.")
-			return util.GeneratePatchAndResponse(originalNode, &node, true, "", "Added or updated annotation with value 'false'.")
-		}
-		setupLog.V(1).Info("No changes needed for allocatable CPU", "node", node.Name)
-		return util.GeneratePatchAndResponse(originalNode, &node, true, "", "No changes needed for allocatable CPU")
+	baseKind := oversell.Base
+	if baseKind == "" {
+		baseKind = BaseCapacity
+	}
+	base, found := baseQuantity(node, name, baseKind)
+	if !found {
+		setupLog.V(1).Info("Node does not report the base of resource", "node", node.Name, "resource", name, "base", baseKind)
+		return oversellResult{name: name, source: source}, nil
 	}
 
-	// 将 allocatable.cpu 字符串转换为 milliCPU
-	newCPUValue, err := parseCPUStringToMilliCPU(newAllocatableCPU)
+	result := oversellResult{name: name, base: base, baseKind: baseKind, ratio: oversell.Ratio, source: source, oversold: true}
+	value, err := scaleQuantity(name, base, oversell.Ratio, oversell.Step, oversell.Rounding)
 	if err != nil {
-		setupLog.Error(err, "Error parsing new allocatable CPU value", "node", node.Name)
-		return setting.ToV1AdmissionResponse(err)
+		// 超出合理范围时使用原始值
+		setupLog.V(1).Info("Calculated allocatable is out of reasonable range. Using the base value.", "resource", name, "error", err.Error())
+		value = base.DeepCopy()
 	}
-
-	// 更新 allocatable.cpu 和注解
-	node.Status.Allocatable[corev1.ResourceCPU] = *resource.NewMilliQuantity(newCPUValue*1000, resource.DecimalSI)
-	updateInvalidLabel(&node, CPUOversell, "true", fmt.Sprintf("Allocatable CPU updated to %d cores, Annotation %s updated to 'true'.", newCPUValue, CPUOversell))
-
-	// 生成 Patch 并返回，允许请求通过
-	return util.GeneratePatchAndResponse(originalNode, &node, true, "", "CPU oversell mutation applied")
+	if oversell.Min != nil && value.Cmp(*oversell.Min) < 0 {
+		value, result.clamped = oversell.Min.DeepCopy(), "min "+oversell.Min.String()
+	}
+	if oversell.Max != nil && value.Cmp(*oversell.Max) > 0 {
+		value, result.clamped = oversell.Max.DeepCopy(), "max "+oversell.Max.String()
+	}
+	result.value = value
+	return result, nil
 }
 
-// shouldModifyAllocatableCPU 检查节点是否有特定的标签，并决定是否修改 allocatable.cpu
-func shouldModifyAllocatableCPU(node *corev1.Node) (bool, string, error) {
-	setupLog := ctrl.Log.WithName("shouldModifyAllocatableCPU")
-
-	// 检查标签是否存在
-	if labels := node.GetLabels(); labels != nil {
-		if oversoldCPU, ok := labels[CPUOversell]; ok {
-			// 尝试解析标签值为浮点数
-			multiplier, err := strconv.ParseFloat(oversoldCPU, 64)
-			if err != nil || multiplier <= 0 {
-				setupLog.Error(err, "Invalid value for node-oversold-cpu label", "value", oversoldCPU)
-				return false, "", err
-			}
-
-			// 获取当前的 capacity.cpu 值
-			capacityCPU, err := parseCPUQuantity(node.Status.Capacity.Cpu())
-			if err != nil {
-				setupLog.Error(err, "Error parsing current CPU capacity", "node", node.Name)
-				return false, "", err
-			}
-
-			// 计算 allocatable.cpu 值
-			newAllocatableCPU := float64(capacityCPU.Value()) * multiplier
+// OriginalAllocatableAnnotation 返回记录 kubelet 上报的原始 allocatable 的注解，比如 cpu_oversell_original_allocatable
+func OriginalAllocatableAnnotation(name corev1.ResourceName) string {
+	return LabelKey(name) + "_original_allocatable"
+}
 
-			// 确保新的 allocatable 不超过合理范围
-			if math.IsNaN(newAllocatableCPU) || math.IsInf(newAllocatableCPU, 0) || newAllocatableCPU <= 0 {
-				setupLog.V(1).Info("Calculated allocatable CPU is out of reasonable range. Using original allocatable.", "calculated", newAllocatableCPU)
-				newAllocatableCPU = float64(capacityCPU.Value())
-			}
+// RatioAnnotation 返回记录超卖比例的注解，比如 cpu_oversell_ratio
+func RatioAnnotation(name corev1.ResourceName) string {
+	return LabelKey(name) + "_ratio"
+}
 
-			// 格式化为字符串
-			newCPUFormatted := formatCPUMilliValue(newAllocatableCPU)
+// originalAllocatable 返回旧对象中记录的原始 allocatable。
+// 请求中的 allocatable 与旧对象相同时，说明这次请求没有上报新的值（比如其他组件更新 status 或修改标签），
+// allocatable 仍然是超卖后的值，需要使用记录的原始值；不同时是 kubelet 新上报的值，直接使用请求中的值
+func originalAllocatable(node, old *corev1.Node, name corev1.ResourceName) (resource.Quantity, bool) {
+	if old == nil {
+		return resource.Quantity{}, false
+	}
+	value, ok := old.GetAnnotations()[OriginalAllocatableAnnotation(name)]
+	if !ok {
+		return resource.Quantity{}, false
+	}
+	current, ok := node.Status.Allocatable[name]
+	if !ok {
+		return resource.Quantity{}, false
+	}
+	if previous, ok := old.Status.Allocatable[name]; !ok || current.Cmp(previous) != 0 {
+		return resource.Quantity{}, false
+	}
+	original, err := resource.ParseQuantity(value)
+	if err != nil {
+		return resource.Quantity{}, false
+	}
+	return original, true
+}
 
-			return true, newCPUFormatted, nil
-		}
+// forgetOriginal 关闭超卖后删除记录的原始值和比例。
+// 更新节点主资源时 API server 会忽略 status 的修改，恢复的 allocatable 不会生效，
+// 这时保留记录，由 AllocatableRestorer 更新 status 恢复 allocatable 后再删除
+func forgetOriginal(node *corev1.Node, req *admissionv1.AdmissionRequest, name corev1.ResourceName) {
+	if req.Operation == admissionv1.Update && req.SubResource != "status" {
+		return
 	}
+	delete(node.Annotations, OriginalAllocatableAnnotation(name))
+	delete(node.Annotations, RatioAnnotation(name))
+}
 
-	// 如果标签不存在，返回 false 表示不修改 capacity.cpu
-	return false, "", nil
+// baseQuantity 返回超卖计算的基数，allocatable 是 kubelet 在请求中上报的值
+func baseQuantity(node *corev1.Node, name corev1.ResourceName, base Base) (resource.Quantity, bool) {
+	list := node.Status.Capacity
+	if base == BaseAllocatable {
+		list = node.Status.Allocatable
+	}
+	q, ok := list[name]
+	return q, ok
 }
 
 // updateInvalidLabel 更新节点的 annotation，并记录事件
-func updateInvalidLabel(node *corev1.Node, key, value string, message string) {
+func (m nodeOversell) updateInvalidLabel(node *corev1.Node, key, value string, message string) {
 	setupLog := ctrl.Log.WithName("updateInvalidLabel")
 	util.UpdateAnnotationForInvalidLabel(node, key, value)
-	util.EventRecorder().Eventf(node, corev1.EventTypeNormal, "Modified", message)
+	m.recorder.Eventf(node, corev1.EventTypeNormal, "Modified", message)
 	setupLog.Info(message, "node", node.Name)
 }
 
@@ -135,26 +244,3 @@ func shouldUpdateAnnotation(node *corev1.Node, key, value string) bool {
 	}
 	return false
 }
-
-// parseCPUQuantity 解析 resource.Quantity 并返回其值
-func parseCPUQuantity(cpuQty *resource.Quantity) (*resource.Quantity, error) {
-	cpuValue, err := resource.ParseQuantity(cpuQty.String())
-	if err != nil {
-		return nil, err
-	}
-	return &cpuValue, nil
-}
-
-// formatCPUMilliValue 格式化 float64 表示的 milliCPU 数量为字符串
-func formatCPUMilliValue(cpuMilli float64) string {
-	return fmt.Sprintf("%fm", cpuMilli)
-}
-
-// parseCPUStringToMilliCPU 解析 CPU 字符串并转换为 milliCPU (int64)
-func parseCPUStringToMilliCPU(cpuStr string) (int64, error) {
-	cpuValue, err := resource.ParseQuantity(cpuStr)
-	if err != nil {
-		return 0, err
-	}
-	return cpuValue.MilliValue(), nil
-}
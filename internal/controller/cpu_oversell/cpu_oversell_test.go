@@ -0,0 +1,270 @@
+package cpu_oversell
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiresource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+// TestMutateCPUOversellDisableAndRestore 检查通过修改标签关闭超卖后，AllocatableRestorer 更新 status 恢复 allocatable，
+// 这次 status 更新经过 webhook 时删除记录的原始值和比例
+func TestMutateCPUOversellDisableAndRestore(t *testing.T) {
+	if err := SetPolicy(nil); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	recorder := record.NewFakeRecorder(10)
+	m := nodeOversell{recorder: recorder}
+	cpu := func(node *corev1.Node) string {
+		q := node.Status.Allocatable[corev1.ResourceCPU]
+		return q.String()
+	}
+
+	// 创建节点时按标签超卖
+	created := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: map[string]string{CPUOversell: "2"}},
+		Status: corev1.NodeStatus{
+			Capacity:    corev1.ResourceList{corev1.ResourceCPU: apiresource.MustParse("4")},
+			Allocatable: corev1.ResourceList{corev1.ResourceCPU: apiresource.MustParse("4")},
+		},
+	}
+	if err := m.mutate(ctx, &admissionv1.AdmissionRequest{Operation: admissionv1.Create}, created, nil); err != nil {
+		t.Fatalf("mutate() create error = %v", err)
+	}
+	if got := cpu(created); got != "8" {
+		t.Fatalf("allocatable after create = %s, want 8", got)
+	}
+	if got := created.Annotations[OriginalAllocatableAnnotation(corev1.ResourceCPU)]; got != "4" {
+		t.Fatalf("original allocatable annotation = %q, want 4", got)
+	}
+
+	// 删除标签关闭超卖：更新主资源时 API server 忽略 status 的修改，保留记录的原始值
+	disabled := created.DeepCopy()
+	delete(disabled.Labels, CPUOversell)
+	if err := m.mutate(ctx, &admissionv1.AdmissionRequest{Operation: admissionv1.Update}, disabled, created); err != nil {
+		t.Fatalf("mutate() update error = %v", err)
+	}
+	if got := disabled.Annotations[CPUOversell]; got != "false" {
+		t.Errorf("%s annotation after disabling = %q, want false", CPUOversell, got)
+	}
+	if _, ok := disabled.Annotations[OriginalAllocatableAnnotation(corev1.ResourceCPU)]; !ok {
+		t.Fatalf("original allocatable annotation removed by a main resource update")
+	}
+	disabled.Status = created.Status
+	if got := cpu(disabled); got != "8" {
+		t.Fatalf("allocatable stored after disabling = %s, want 8", got)
+	}
+
+	// AllocatableRestorer 更新 nodes/status 恢复原始值
+	client := fake.NewSimpleClientset(disabled)
+	r, err := NewAllocatableRestorer(client, recorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.informer.GetIndexer().Add(disabled); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reconcile(ctx, disabled.Name); err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+	var patches []k8stesting.PatchAction
+	for _, action := range client.Actions() {
+		if patch, ok := action.(k8stesting.PatchAction); ok {
+			patches = append(patches, patch)
+		}
+	}
+	if len(patches) != 1 || patches[0].GetSubresource() != "status" {
+		t.Fatalf("reconcile() actions = %v, want one patch of nodes/status", client.Actions())
+	}
+	patched, err := client.CoreV1().Nodes().Get(ctx, disabled.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cpu(patched); got != "4" {
+		t.Fatalf("allocatable after restore = %s, want 4", got)
+	}
+
+	// status 更新经过 webhook，不再超卖，删除记录的原始值和比例
+	restored := patched.DeepCopy()
+	req := &admissionv1.AdmissionRequest{Operation: admissionv1.Update, SubResource: "status"}
+	if err := m.mutate(ctx, req, restored, disabled); err != nil {
+		t.Fatalf("mutate() status update error = %v", err)
+	}
+	if got := cpu(restored); got != "4" {
+		t.Errorf("allocatable after status update = %s, want 4", got)
+	}
+	for _, key := range []string{OriginalAllocatableAnnotation(corev1.ResourceCPU), RatioAnnotation(corev1.ResourceCPU)} {
+		if _, ok := restored.Annotations[key]; ok {
+			t.Errorf("annotation %s kept after restore", key)
+		}
+	}
+
+	// 已经恢复的节点不再更新
+	if err := r.informer.GetIndexer().Update(restored); err != nil {
+		t.Fatal(err)
+	}
+	client.ClearActions()
+	if err := r.reconcile(ctx, restored.Name); err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+	if len(client.Actions()) != 0 {
+		t.Errorf("reconcile() of a restored node actions = %v, want none", client.Actions())
+	}
+
+	close(recorder.Events)
+	var restoredEvent bool
+	for event := range recorder.Events {
+		restoredEvent = restoredEvent || strings.HasPrefix(event, "Normal Restored Allocatable updated: cpu restored to 4")
+	}
+	if !restoredEvent {
+		t.Errorf("no Restored event recorded")
+	}
+}
+
+func TestRestoredAllocatable(t *testing.T) {
+	if err := SetPolicy(nil); err != nil {
+		t.Fatal(err)
+	}
+	node := func(labels map[string]string, original, allocatable string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: labels, Annotations: map[string]string{
+				OriginalAllocatableAnnotation(corev1.ResourceCPU): original,
+			}},
+			Status: corev1.NodeStatus{
+				Capacity:    corev1.ResourceList{corev1.ResourceCPU: apiresource.MustParse("4")},
+				Allocatable: corev1.ResourceList{corev1.ResourceCPU: apiresource.MustParse(allocatable)},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name string
+		node *corev1.Node
+		want string
+	}{
+		{name: "label removed", node: node(nil, "4", "8"), want: "4"},
+		{name: "invalid label", node: node(map[string]string{CPUOversell: "abc"}, "4", "8"), want: "4"},
+		{name: "still oversold", node: node(map[string]string{CPUOversell: "2"}, "4", "8")},
+		{name: "already restored", node: node(nil, "4", "4")},
+		{name: "invalid recorded value", node: node(nil, "abc", "8")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := restoredAllocatable(tc.node)
+			q, ok := got[corev1.ResourceCPU]
+			if ok != (tc.want != "") {
+				t.Fatalf("restoredAllocatable() = %v, want cpu %q", got, tc.want)
+			}
+			if ok && q.Cmp(apiresource.MustParse(tc.want)) != 0 {
+				t.Errorf("restoredAllocatable() cpu = %s, want %s", q.String(), tc.want)
+			}
+		})
+	}
+}
+
+// TestMutateCPUOversellInvalidLabelEvent 检查无效标签只在第一次写入注解时记录事件，kubelet 之后上报状态不再重复记录
+func TestMutateCPUOversellInvalidLabelEvent(t *testing.T) {
+	if err := SetPolicy(nil); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	recorder := record.NewFakeRecorder(10)
+	m := nodeOversell{recorder: recorder}
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: map[string]string{CPUOversell: "abc"}},
+		Status: corev1.NodeStatus{
+			Capacity:    corev1.ResourceList{corev1.ResourceCPU: apiresource.MustParse("4")},
+			Allocatable: corev1.ResourceList{corev1.ResourceCPU: apiresource.MustParse("4")},
+		},
+	}
+	if err := m.mutate(ctx, &admissionv1.AdmissionRequest{Operation: admissionv1.Create}, node, nil); err != nil {
+		t.Fatalf("mutate() create error = %v", err)
+	}
+	if got := node.Annotations[CPUOversell]; got != "false" {
+		t.Fatalf("%s annotation = %q, want false", CPUOversell, got)
+	}
+	if got := len(recorder.Events); got != 1 {
+		t.Fatalf("events after create = %d, want 1", got)
+	}
+	<-recorder.Events
+
+	for i := 0; i < 3; i++ {
+		updated := node.DeepCopy()
+		updated.Status.Allocatable[corev1.ResourceCPU] = apiresource.MustParse("3900m")
+		req := &admissionv1.AdmissionRequest{Operation: admissionv1.Update, SubResource: "status"}
+		if err := m.mutate(ctx, req, updated, node); err != nil {
+			t.Fatalf("mutate() status update error = %v", err)
+		}
+	}
+	if got := len(recorder.Events); got != 0 {
+		t.Errorf("events after status updates = %d, want 0: %v", got, <-recorder.Events)
+	}
+}
+
+// TestMutateCPUOversellEventOnChange 检查超卖后的值或比例变化时才记录 Allocatable updated 事件
+func TestMutateCPUOversellEventOnChange(t *testing.T) {
+	if err := SetPolicy(nil); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	recorder := record.NewFakeRecorder(10)
+	m := nodeOversell{recorder: recorder}
+	statusUpdate := &admissionv1.AdmissionRequest{Operation: admissionv1.Update, SubResource: "status"}
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: map[string]string{CPUOversell: "2"}},
+		Status: corev1.NodeStatus{
+			Capacity:    corev1.ResourceList{corev1.ResourceCPU: apiresource.MustParse("4")},
+			Allocatable: corev1.ResourceList{corev1.ResourceCPU: apiresource.MustParse("4")},
+		},
+	}
+	if err := m.mutate(ctx, &admissionv1.AdmissionRequest{Operation: admissionv1.Create}, node, nil); err != nil {
+		t.Fatalf("mutate() create error = %v", err)
+	}
+	if got := len(recorder.Events); got != 1 {
+		t.Fatalf("events after create = %d, want 1", got)
+	}
+	<-recorder.Events
+
+	testCases := []struct {
+		name      string
+		update    func(node *corev1.Node)
+		wantEvent bool
+	}{
+		{name: "kubelet reports the same allocatable", update: func(node *corev1.Node) {
+			node.Status.Allocatable[corev1.ResourceCPU] = apiresource.MustParse("4")
+		}},
+		{name: "other status update", update: func(*corev1.Node) {}},
+		{name: "ratio changed", update: func(node *corev1.Node) {
+			node.Labels[CPUOversell] = "1.5"
+		}, wantEvent: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			updated := node.DeepCopy()
+			tc.update(updated)
+			if err := m.mutate(ctx, statusUpdate, updated, node); err != nil {
+				t.Fatalf("mutate() error = %v", err)
+			}
+			node = updated
+			var events []string
+			for len(recorder.Events) > 0 {
+				events = append(events, <-recorder.Events)
+			}
+			if got := len(events) > 0; got != tc.wantEvent {
+				t.Errorf("events = %q, want event %v", events, tc.wantEvent)
+			}
+		})
+	}
+}
@@ -0,0 +1,349 @@
+package cpu_oversell
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// PolicyKey 是 ConfigMap 中保存超卖策略的键
+const PolicyKey = "policy.yaml"
+
+// Policy 是集群级别的超卖策略，按节点标签选择每种资源的超卖比例，例如：
+//
+//	defaultRatio: 1.2
+//	base: Allocatable
+//	defaults:
+//	  memory:
+//	    ratio: 1.1
+//	rules:
+//	- name: batch
+//	  priority: 100
+//	  nodeSelector:
+//	    matchLabels:
+//	      node-pool: batch
+//	  ratio: 2
+//	  resources:
+//	    memory:
+//	      ratio: 1.5
+//	      max: 512Gi
+//	      step: 1Gi
+//	    pods:
+//	      ratio: 2
+//	      max: "250"
+//
+// 节点每种资源的超卖配置按以下顺序确定：
+//  1. 节点的 <资源>_oversell 标签（比如 cpu_oversell）覆盖超卖比例，上下限仍然使用策略中的配置
+//  2. 匹配 ExcludeNodeSelectors 的节点不超卖
+//  3. 匹配的规则中 Priority 最大的一条，这条规则没有配置的资源使用 Defaults
+//  4. Defaults，没有配置的资源不超卖
+type Policy struct {
+	// Base 和 Rounding 是没有单独配置的资源使用的基数和取整方式，默认为 Capacity 和 Down
+	Base     Base     `json:"base,omitempty"`
+	Rounding Rounding `json:"rounding,omitempty"`
+	// DefaultRatio 是 Defaults 中 cpu 比例的简写，为 0 时不设置
+	DefaultRatio float64 `json:"defaultRatio,omitempty"`
+	// Defaults 是没有规则匹配或规则没有配置的资源使用的超卖配置
+	Defaults ResourceOversells `json:"defaults,omitempty"`
+	// ExcludeNodeSelectors 匹配的节点不超卖，为 nil 时排除 control-plane 节点，设置为空列表时不排除任何节点
+	ExcludeNodeSelectors []metav1.LabelSelector `json:"excludeNodeSelectors,omitempty"`
+	// Rules 是按节点标签选择超卖比例的规则
+	Rules []PolicyRule `json:"rules,omitempty"`
+}
+
+// PolicyRule 是一条超卖规则
+type PolicyRule struct {
+	// Name 用于日志和注解，不能重复
+	Name string `json:"name"`
+	// Priority 越大越优先，不能重复，这样同一个节点匹配多条规则时结果是确定的
+	Priority int32 `json:"priority"`
+	// NodeSelector 匹配节点的标签，为空时匹配所有节点
+	NodeSelector metav1.LabelSelector `json:"nodeSelector"`
+	// Ratio 是 Resources 中 cpu 比例的简写，为 0 时不设置
+	Ratio float64 `json:"ratio,omitempty"`
+	// Resources 是每种资源的超卖配置，至少配置一种资源
+	Resources ResourceOversells `json:"resources,omitempty"`
+}
+
+// ResourceOversells 是资源名称到超卖配置的映射
+type ResourceOversells map[corev1.ResourceName]ResourceOversell
+
+// ResourceOversell 是一种资源的超卖配置
+type ResourceOversell struct {
+	// Ratio 是超卖比例，必须大于 0，并且在 --oversell-min-ratio 和 --oversell-max-ratio 的范围内
+	Ratio float64 `json:"ratio"`
+	// Min 和 Max 限制超卖后的 allocatable，为空时不限制
+	Min *resource.Quantity `json:"min,omitempty"`
+	Max *resource.Quantity `json:"max,omitempty"`
+	// Base 是计算的基数，为空时使用 Policy.Base
+	Base Base `json:"base,omitempty"`
+	// Rounding 是结果取整的方式，为空时使用 Policy.Rounding
+	Rounding Rounding `json:"rounding,omitempty"`
+	// Step 是取整的单位，比如 cpu 的 "1" 取整到整核、memory 的 1Gi，为空时 cpu 取整到 1m，其他资源取整到 1
+	Step *resource.Quantity `json:"step,omitempty"`
+}
+
+// Resources 是支持超卖的资源
+var Resources = []corev1.ResourceName{
+	corev1.ResourceCPU,
+	corev1.ResourceMemory,
+	corev1.ResourceEphemeralStorage,
+	corev1.ResourcePods,
+}
+
+// LabelKey 返回覆盖资源超卖比例的节点标签，也是记录是否超卖的注解，比如 cpu_oversell、ephemeral_storage_oversell
+func LabelKey(name corev1.ResourceName) string {
+	if name == corev1.ResourceCPU {
+		return CPUOversell
+	}
+	key := []byte(name)
+	for i, c := range key {
+		if c == '-' {
+			key[i] = '_'
+		}
+	}
+	return string(key) + "_oversell"
+}
+
+// defaultExcludeNodeSelectors 默认排除的 control-plane 节点
+var defaultExcludeNodeSelectors = []metav1.LabelSelector{
+	{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "node-role.kubernetes.io/control-plane", Operator: metav1.LabelSelectorOpExists}}},
+	{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "node-role.kubernetes.io/master", Operator: metav1.LabelSelectorOpExists}}},
+}
+
+// compiledPolicy 是检查过的策略，规则按 Priority 从大到小排序，cpu 的简写已经合并到 resources 中
+type compiledPolicy struct {
+	defaults ResourceOversells
+	exclude  []labels.Selector
+	rules    []compiledRule
+}
+
+type compiledRule struct {
+	name      string
+	selector  labels.Selector
+	resources ResourceOversells
+}
+
+// currentPolicy 是当前生效的策略，为 nil 时只使用节点标签
+var currentPolicy atomic.Pointer[compiledPolicy]
+
+// ratioRange 是允许的超卖比例范围
+type ratioRange struct {
+	min float64
+	max float64
+}
+
+// ratioBounds 是策略中允许的超卖比例范围，与节点标签的范围相同，为 nil 时只要求大于 0
+var ratioBounds atomic.Pointer[ratioRange]
+
+// SetRatioBounds 设置策略中允许的超卖比例范围（--oversell-min-ratio 和 --oversell-max-ratio），需要在加载策略之前调用
+func SetRatioBounds(minRatio, maxRatio float64) error {
+	if minRatio < 0 || maxRatio <= 0 || minRatio > maxRatio {
+		return fmt.Errorf("oversell ratio range [%v, %v] is invalid", minRatio, maxRatio)
+	}
+	ratioBounds.Store(&ratioRange{min: minRatio, max: maxRatio})
+	return nil
+}
+
+// ParsePolicy 解析并检查 ConfigMap 中的策略
+func ParsePolicy(data []byte) (*Policy, error) {
+	var policy Policy
+	if err := yaml.UnmarshalStrict(data, &policy); err != nil {
+		return nil, err
+	}
+	if _, err := compilePolicy(&policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// SetPolicy 设置当前生效的策略，为 nil 时只使用节点标签
+func SetPolicy(policy *Policy) error {
+	if policy == nil {
+		currentPolicy.Store(nil)
+		return nil
+	}
+	compiled, err := compilePolicy(policy)
+	if err != nil {
+		return err
+	}
+	currentPolicy.Store(compiled)
+	return nil
+}
+
+func compilePolicy(policy *Policy) (*compiledPolicy, error) {
+	if policy.DefaultRatio < 0 {
+		return nil, fmt.Errorf("defaultRatio must not be negative, got %v", policy.DefaultRatio)
+	}
+	switch policy.Base {
+	case "", BaseCapacity, BaseAllocatable:
+	default:
+		return nil, fmt.Errorf("base must be %s or %s, got %q", BaseCapacity, BaseAllocatable, policy.Base)
+	}
+	switch policy.Rounding {
+	case "", RoundDown, RoundUp, RoundNearest:
+	default:
+		return nil, fmt.Errorf("rounding must be %s, %s or %s, got %q", RoundDown, RoundUp, RoundNearest, policy.Rounding)
+	}
+	defaults, err := compileResources(policy, policy.DefaultRatio, policy.Defaults)
+	if err != nil {
+		return nil, fmt.Errorf("defaults: %w", err)
+	}
+	compiled := &compiledPolicy{defaults: defaults}
+
+	exclude := policy.ExcludeNodeSelectors
+	if exclude == nil {
+		exclude = defaultExcludeNodeSelectors
+	}
+	for i := range exclude {
+		s, err := metav1.LabelSelectorAsSelector(&exclude[i])
+		if err != nil {
+			return nil, fmt.Errorf("excludeNodeSelectors[%d]: %w", i, err)
+		}
+		compiled.exclude = append(compiled.exclude, s)
+	}
+
+	rules := append([]PolicyRule(nil), policy.Rules...)
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Priority > rules[j].Priority })
+	names := make(map[string]bool)
+	for i, r := range rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("rule name is required")
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("duplicate rule name %q", r.Name)
+		}
+		names[r.Name] = true
+		if i > 0 && rules[i-1].Priority == r.Priority {
+			return nil, fmt.Errorf("rules %q and %q have the same priority %d", rules[i-1].Name, r.Name, r.Priority)
+		}
+		if r.Ratio < 0 {
+			return nil, fmt.Errorf("rule %q: ratio must be greater than 0, got %v", r.Name, r.Ratio)
+		}
+		resources, err := compileResources(policy, r.Ratio, r.Resources)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+		if len(resources) == 0 {
+			return nil, fmt.Errorf("rule %q: ratio or resources is required", r.Name)
+		}
+		s, err := metav1.LabelSelectorAsSelector(&r.NodeSelector)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+		compiled.rules = append(compiled.rules, compiledRule{name: r.Name, selector: s, resources: resources})
+	}
+	return compiled, nil
+}
+
+// compileResources 检查每种资源的超卖配置和比例范围，把 cpu 比例的简写合并进去，并填充策略的基数和取整方式
+func compileResources(policy *Policy, cpuRatio float64, resources ResourceOversells) (ResourceOversells, error) {
+	compiled := make(ResourceOversells, len(resources)+1)
+	if cpuRatio > 0 {
+		if _, ok := resources[corev1.ResourceCPU]; ok {
+			return nil, fmt.Errorf("cpu ratio is set both by the shorthand and in resources")
+		}
+		resources = maps.Clone(resources)
+		if resources == nil {
+			resources = ResourceOversells{}
+		}
+		resources[corev1.ResourceCPU] = ResourceOversell{Ratio: cpuRatio}
+	}
+	for name, o := range resources {
+		if !isSupported(name) {
+			return nil, fmt.Errorf("resource %s does not support oversell, must be one of %v", name, Resources)
+		}
+		if o.Ratio <= 0 {
+			return nil, fmt.Errorf("resource %s: ratio must be greater than 0, got %v", name, o.Ratio)
+		}
+		if b := ratioBounds.Load(); b != nil && (o.Ratio < b.min || o.Ratio > b.max) {
+			return nil, fmt.Errorf("resource %s: ratio must be within [%v, %v], got %v", name, b.min, b.max, o.Ratio)
+		}
+		if o.Min != nil && o.Max != nil && o.Min.Cmp(*o.Max) > 0 {
+			return nil, fmt.Errorf("resource %s: min %s is greater than max %s", name, o.Min, o.Max)
+		}
+		if o.Base == "" {
+			o.Base = policy.Base
+		}
+		if o.Base != "" && o.Base != BaseCapacity && o.Base != BaseAllocatable {
+			return nil, fmt.Errorf("resource %s: base must be %s or %s, got %q", name, BaseCapacity, BaseAllocatable, o.Base)
+		}
+		if o.Rounding == "" {
+			o.Rounding = policy.Rounding
+		}
+		if o.Rounding != "" && o.Rounding != RoundDown && o.Rounding != RoundUp && o.Rounding != RoundNearest {
+			return nil, fmt.Errorf("resource %s: rounding must be %s, %s or %s, got %q", name, RoundDown, RoundUp, RoundNearest, o.Rounding)
+		}
+		if o.Step != nil && (o.Step.Sign() <= 0 || (name == corev1.ResourceCPU && o.Step.MilliValue() <= 0)) {
+			return nil, fmt.Errorf("resource %s: step must be greater than 0, got %s", name, o.Step)
+		}
+		compiled[name] = o
+	}
+	return compiled, nil
+}
+
+func isSupported(name corev1.ResourceName) bool {
+	for _, r := range Resources {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveOversell 返回节点一种资源的超卖配置和来源，ok 为 false 表示不超卖；<资源>_oversell 标签无效时返回错误
+func resolveOversell(node *corev1.Node, name corev1.ResourceName) (oversell ResourceOversell, source string, ok bool, err error) {
+	nodeLabels := labels.Set(node.GetLabels())
+
+	var excluded bool
+	if policy := currentPolicy.Load(); policy != nil {
+		oversell, source, ok, excluded = policy.resolve(nodeLabels, name)
+	}
+
+	if value, found := nodeLabels[LabelKey(name)]; found {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return ResourceOversell{}, "", false, err
+		}
+		if ratio <= 0 {
+			return ResourceOversell{}, "", false, fmt.Errorf("ratio must be greater than 0, got %v", ratio)
+		}
+		// 标签只覆盖比例，节点被排除时策略不提供上下限
+		oversell.Ratio = ratio
+		return oversell, "label", true, nil
+	}
+	if excluded {
+		return ResourceOversell{}, "excluded", false, nil
+	}
+	return oversell, source, ok, nil
+}
+
+// resolve 按排除规则、超卖规则和默认值的顺序确定一种资源的超卖配置
+func (p *compiledPolicy) resolve(nodeLabels labels.Set, name corev1.ResourceName) (oversell ResourceOversell, source string, ok, excluded bool) {
+	for _, s := range p.exclude {
+		if s.Matches(nodeLabels) {
+			return ResourceOversell{}, "", false, true
+		}
+	}
+	for _, r := range p.rules {
+		if !r.selector.Matches(nodeLabels) {
+			continue
+		}
+		if o, ok := r.resources[name]; ok {
+			return o, "rule/" + r.name, true, false
+		}
+		break
+	}
+	if o, ok := p.defaults[name]; ok {
+		return o, "default", true, false
+	}
+	return ResourceOversell{}, "", false, false
+}
@@ -0,0 +1,366 @@
+package cpu_oversell
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apiresource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestResolveOversell(t *testing.T) {
+	policy, err := ParsePolicy([]byte(`
+defaultRatio: 1.2
+rules:
+- name: online
+  priority: 50
+  nodeSelector:
+    matchLabels:
+      node-pool: online
+  ratio: 1.5
+- name: batch
+  priority: 100
+  nodeSelector:
+    matchExpressions:
+    - key: node-pool
+      operator: In
+      values: [batch, online]
+  ratio: 2
+  resources:
+    pods:
+      ratio: 1.5
+defaults:
+  memory:
+    ratio: 1.1
+`))
+	if err != nil {
+		t.Fatalf("ParsePolicy() error = %v", err)
+	}
+
+	testCases := []struct {
+		name       string
+		policy     *Policy
+		resource   corev1.ResourceName
+		labels     map[string]string
+		wantRatio  float64
+		wantSource string
+		wantOK     bool
+		wantErr    bool
+	}{
+		{
+			name:       "label overrides the policy",
+			policy:     policy,
+			labels:     map[string]string{CPUOversell: "3", "node-pool": "batch"},
+			wantRatio:  3,
+			wantSource: "label",
+			wantOK:     true,
+		},
+		{
+			name:    "invalid label",
+			policy:  policy,
+			labels:  map[string]string{CPUOversell: "abc"},
+			wantErr: true,
+		},
+		{
+			name:    "non-positive label",
+			policy:  policy,
+			labels:  map[string]string{CPUOversell: "0"},
+			wantErr: true,
+		},
+		{
+			name:       "highest priority rule wins",
+			policy:     policy,
+			labels:     map[string]string{"node-pool": "online"},
+			wantRatio:  2,
+			wantSource: "rule/batch",
+			wantOK:     true,
+		},
+		{
+			name:       "default ratio",
+			policy:     policy,
+			labels:     map[string]string{"node-pool": "other"},
+			wantRatio:  1.2,
+			wantSource: "default",
+			wantOK:     true,
+		},
+		{
+			name:       "control-plane is excluded by default",
+			policy:     policy,
+			labels:     map[string]string{"node-role.kubernetes.io/control-plane": "", "node-pool": "batch"},
+			wantSource: "excluded",
+		},
+		{
+			name: "empty exclusions keep control-plane",
+			policy: &Policy{
+				DefaultRatio:         1.5,
+				ExcludeNodeSelectors: []metav1.LabelSelector{},
+			},
+			labels:     map[string]string{"node-role.kubernetes.io/control-plane": ""},
+			wantRatio:  1.5,
+			wantSource: "default",
+			wantOK:     true,
+		},
+		{
+			name:       "resource configured by the matched rule",
+			policy:     policy,
+			resource:   corev1.ResourcePods,
+			labels:     map[string]string{"node-pool": "batch"},
+			wantRatio:  1.5,
+			wantSource: "rule/batch",
+			wantOK:     true,
+		},
+		{
+			name:       "resource missing from the matched rule falls back to defaults",
+			policy:     policy,
+			resource:   corev1.ResourceMemory,
+			labels:     map[string]string{"node-pool": "batch"},
+			wantRatio:  1.1,
+			wantSource: "default",
+			wantOK:     true,
+		},
+		{
+			name:     "resource without rule or default is not oversold",
+			policy:   policy,
+			resource: corev1.ResourceEphemeralStorage,
+			labels:   map[string]string{"node-pool": "batch"},
+		},
+		{
+			name:       "resource label overrides the policy",
+			policy:     policy,
+			resource:   corev1.ResourceEphemeralStorage,
+			labels:     map[string]string{"ephemeral_storage_oversell": "1.3"},
+			wantRatio:  1.3,
+			wantSource: "label",
+			wantOK:     true,
+		},
+		{
+			name:   "no policy and no label",
+			labels: map[string]string{"node-pool": "batch"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := SetPolicy(tc.policy); err != nil {
+				t.Fatalf("SetPolicy() error = %v", err)
+			}
+			defer SetPolicy(nil)
+
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: tc.labels}}
+			resource := tc.resource
+			if resource == "" {
+				resource = corev1.ResourceCPU
+			}
+			oversell, source, ok, err := resolveOversell(node, resource)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("resolveOversell() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if oversell.Ratio != tc.wantRatio || source != tc.wantSource || ok != tc.wantOK {
+				t.Errorf("resolveOversell() = %v, %q, %v, want %v, %q, %v",
+					oversell.Ratio, source, ok, tc.wantRatio, tc.wantSource, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestParsePolicyInvalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		data string
+	}{
+		{name: "unknown field", data: "ratio: 2"},
+		{name: "negative default", data: "defaultRatio: -1"},
+		{name: "duplicate priority", data: `
+rules:
+- {name: a, priority: 1, ratio: 2}
+- {name: b, priority: 1, ratio: 3}`},
+		{name: "duplicate name", data: `
+rules:
+- {name: a, priority: 1, ratio: 2}
+- {name: a, priority: 2, ratio: 3}`},
+		{name: "rule without resources", data: `
+rules:
+- {name: a, priority: 1}`},
+		{name: "zero resource ratio", data: `
+rules:
+- {name: a, priority: 1, resources: {memory: {ratio: 0}}}`},
+		{name: "unsupported resource", data: `
+rules:
+- {name: a, priority: 1, resources: {nvidia.com/gpu: {ratio: 2}}}`},
+		{name: "min greater than max", data: `
+defaults:
+  memory: {ratio: 2, min: 2Gi, max: 1Gi}`},
+		{name: "cpu set twice", data: `
+rules:
+- {name: a, priority: 1, ratio: 2, resources: {cpu: {ratio: 3}}}`},
+		{name: "invalid selector", data: `
+rules:
+- name: a
+  priority: 1
+  ratio: 2
+  nodeSelector:
+    matchExpressions:
+    - {key: pool, operator: Bad}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := ParsePolicy([]byte(tc.data)); err == nil {
+				t.Errorf("ParsePolicy() expected error")
+			}
+		})
+	}
+}
+
+func TestParsePolicyRatioBounds(t *testing.T) {
+	if err := SetRatioBounds(0.5, 3); err != nil {
+		t.Fatal(err)
+	}
+	defer ratioBounds.Store(nil)
+
+	testCases := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "within bounds", data: "defaultRatio: 3\ndefaults: {memory: {ratio: 0.5}}"},
+		{name: "default ratio above the maximum", data: "defaultRatio: 4", wantErr: true},
+		{name: "resource ratio below the minimum", data: "defaults: {memory: {ratio: 0.2}}", wantErr: true},
+		{name: "rule ratio above the maximum", data: `
+rules:
+- {name: a, priority: 1, ratio: 10}`, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := ParsePolicy([]byte(tc.data)); (err != nil) != tc.wantErr {
+				t.Errorf("ParsePolicy() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetRatioBoundsInvalid(t *testing.T) {
+	defer ratioBounds.Store(nil)
+	for _, bounds := range [][2]float64{{-1, 3}, {0, 0}, {3, 0.5}} {
+		if err := SetRatioBounds(bounds[0], bounds[1]); err == nil {
+			t.Errorf("SetRatioBounds(%v, %v) expected error", bounds[0], bounds[1])
+		}
+	}
+}
+
+func TestOversellResource(t *testing.T) {
+	policy, err := ParsePolicy([]byte(`
+excludeNodeSelectors: []
+defaults:
+  memory: {ratio: 2, max: 24Gi}
+  pods: {ratio: 0.5, min: "100"}
+  ephemeral-storage: {ratio: 1.5, base: Allocatable}
+`))
+	if err != nil {
+		t.Fatalf("ParsePolicy() error = %v", err)
+	}
+	if err := SetPolicy(policy); err != nil {
+		t.Fatalf("SetPolicy() error = %v", err)
+	}
+	defer SetPolicy(nil)
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: map[string]string{CPUOversell: "1.5"}},
+		Status: corev1.NodeStatus{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceCPU:              apiresource.MustParse("3500m"),
+				corev1.ResourceMemory:           apiresource.MustParse("16Gi"),
+				corev1.ResourcePods:             apiresource.MustParse("110"),
+				corev1.ResourceEphemeralStorage: apiresource.MustParse("100Gi"),
+			},
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceEphemeralStorage: apiresource.MustParse("90Gi"),
+			},
+		},
+	}
+
+	testCases := []struct {
+		resource    corev1.ResourceName
+		want        string
+		wantClamped string
+	}{
+		{resource: corev1.ResourceCPU, want: "5250m"},
+		{resource: corev1.ResourceMemory, want: "24Gi", wantClamped: "max 24Gi"},
+		{resource: corev1.ResourcePods, want: "100", wantClamped: "min 100"},
+		{resource: corev1.ResourceEphemeralStorage, want: "135Gi"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.resource), func(t *testing.T) {
+			result, err := oversellResource(node, tc.resource)
+			if err != nil {
+				t.Fatalf("oversellResource() error = %v", err)
+			}
+			want := apiresource.MustParse(tc.want)
+			if !result.oversold || result.value.Cmp(want) != 0 || result.clamped != tc.wantClamped {
+				t.Errorf("oversellResource() = %v, %s, %q, want %s, %q",
+					result.oversold, result.value.String(), result.clamped, tc.want, tc.wantClamped)
+			}
+		})
+	}
+}
+
+func TestOriginalAllocatable(t *testing.T) {
+	node := func(allocatable string, annotations map[string]string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node", Annotations: annotations},
+			Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU: apiresource.MustParse(allocatable),
+			}},
+		}
+	}
+	recorded := map[string]string{OriginalAllocatableAnnotation(corev1.ResourceCPU): "3920m"}
+
+	testCases := []struct {
+		name         string
+		node         *corev1.Node
+		old          *corev1.Node
+		want         string
+		wantRecorded bool
+	}{
+		{
+			name: "create uses the reported value",
+			node: node("3920m", nil),
+		},
+		{
+			name:         "unchanged allocatable uses the recorded original",
+			node:         node("5880m", recorded),
+			old:          node("5880m", recorded),
+			want:         "3920m",
+			wantRecorded: true,
+		},
+		{
+			name: "kubelet reported a new value",
+			node: node("3800m", recorded),
+			old:  node("5880m", recorded),
+		},
+		{
+			name: "nothing recorded",
+			node: node("3920m", nil),
+			old:  node("3920m", nil),
+		},
+		{
+			name: "invalid recorded value",
+			node: node("5880m", nil),
+			old:  node("5880m", map[string]string{OriginalAllocatableAnnotation(corev1.ResourceCPU): "abc"}),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := originalAllocatable(tc.node, tc.old, corev1.ResourceCPU)
+			if ok != tc.wantRecorded {
+				t.Fatalf("originalAllocatable() recorded = %v, want %v", ok, tc.wantRecorded)
+			}
+			if ok && got.Cmp(apiresource.MustParse(tc.want)) != 0 {
+				t.Errorf("originalAllocatable() = %s, want %s", got.String(), tc.want)
+			}
+		})
+	}
+}
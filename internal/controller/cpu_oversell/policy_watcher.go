@@ -0,0 +1,99 @@
+package cpu_oversell
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// PolicyWatcher 通过 informer 监听保存超卖策略的 ConfigMap，变化后立即更新当前策略，无需重启。
+// ConfigMap 不存在或被删除时只使用节点标签；内容无效时保留上一次有效的策略，并在 ConfigMap 上记录事件。
+type PolicyWatcher struct {
+	namespace string
+	name      string
+	recorder  record.EventRecorder
+	factory   informers.SharedInformerFactory
+	informer  cache.SharedIndexInformer
+}
+
+// NewPolicyWatcher 创建 PolicyWatcher，只 list/watch 指定名称的 ConfigMap，无效的策略通过 recorder 记录事件
+func NewPolicyWatcher(client kubernetes.Interface, recorder record.EventRecorder, namespace, name string) (*PolicyWatcher, error) {
+	w := &PolicyWatcher{namespace: namespace, name: name, recorder: recorder}
+	w.factory = informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}),
+	)
+	w.informer = w.factory.Core().V1().ConfigMaps().Informer()
+	_, err := w.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { w.update(obj) },
+		UpdateFunc: func(_, obj interface{}) { w.update(obj) },
+		DeleteFunc: func(_ interface{}) { w.clear() },
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add event handler: %w", err)
+	}
+	return w, nil
+}
+
+// Start 启动 informer，直到 ctx 结束
+func (w *PolicyWatcher) Start(ctx context.Context) error {
+	setupLog := ctrl.Log.WithName("cpu-oversell-policy")
+	setupLog.Info("Starting cpu oversell policy watcher", "namespace", w.namespace, "name", w.name)
+
+	w.factory.Start(ctx.Done())
+	<-ctx.Done()
+	w.factory.Shutdown()
+	setupLog.Info("Stopped cpu oversell policy watcher")
+	return nil
+}
+
+// HasSynced 返回 informer 是否已经完成第一次 list，用于就绪检查，避免启动时按没有策略处理节点
+func (w *PolicyWatcher) HasSynced() bool {
+	return w.informer.HasSynced()
+}
+
+func (w *PolicyWatcher) update(obj interface{}) {
+	setupLog := ctrl.Log.WithName("cpu-oversell-policy").WithValues("namespace", w.namespace, "name", w.name)
+
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		return
+	}
+	policy, err := loadPolicy(cm)
+	if err != nil {
+		setupLog.Error(err, "Invalid cpu oversell policy, keep the current policy")
+		w.recorder.Eventf(cm, corev1.EventTypeWarning, "InvalidPolicy", "Invalid cpu oversell policy: %v", err)
+		return
+	}
+	setupLog.Info("Loaded cpu oversell policy", "defaultRatio", policy.DefaultRatio, "rules", len(policy.Rules))
+}
+
+// loadPolicy 解析 ConfigMap 中的策略并设置为当前策略
+func loadPolicy(cm *corev1.ConfigMap) (*Policy, error) {
+	data, ok := cm.Data[PolicyKey]
+	if !ok {
+		return nil, fmt.Errorf("configmap has no %s key", PolicyKey)
+	}
+	policy, err := ParsePolicy([]byte(data))
+	if err != nil {
+		return nil, err
+	}
+	return policy, SetPolicy(policy)
+}
+
+func (w *PolicyWatcher) clear() {
+	setupLog := ctrl.Log.WithName("cpu-oversell-policy")
+	_ = SetPolicy(nil)
+	setupLog.Info("Cpu oversell policy configmap deleted, resolving the ratio only from node labels",
+		"namespace", w.namespace, "name", w.name)
+}
@@ -0,0 +1,95 @@
+package cpu_oversell
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Base 是超卖计算的基数
+type Base string
+
+const (
+	// BaseCapacity 从节点的 capacity 计算，不保留 kube-reserved 和 system-reserved
+	BaseCapacity Base = "Capacity"
+	// BaseAllocatable 从 kubelet 上报的 allocatable 计算，保留 kube-reserved、system-reserved 和驱逐阈值
+	BaseAllocatable Base = "Allocatable"
+)
+
+// Rounding 是超卖结果按 Step 取整的方式
+type Rounding string
+
+const (
+	RoundDown    Rounding = "Down"
+	RoundUp      Rounding = "Up"
+	RoundNearest Rounding = "Nearest"
+)
+
+// scaleQuantity 按比例放大资源数量，cpu 按 milli 计算，其他资源按整数计算，结果按 step 和 rounding 取整。
+// step 为 nil 时 cpu 取整到 1m，其他资源取整到 1
+func scaleQuantity(name corev1.ResourceName, base resource.Quantity, ratio float64, step *resource.Quantity, rounding Rounding) (resource.Quantity, error) {
+	value, stepValue := base.Value(), int64(1)
+	if name == corev1.ResourceCPU {
+		value = base.MilliValue()
+	}
+	if step != nil {
+		stepValue = step.Value()
+		if name == corev1.ResourceCPU {
+			stepValue = step.MilliValue()
+		}
+	}
+	scaled, err := scale(value, ratio, stepValue, rounding)
+	if err != nil {
+		return resource.Quantity{}, err
+	}
+	if name == corev1.ResourceCPU {
+		return *resource.NewMilliQuantity(scaled, base.Format), nil
+	}
+	return *resource.NewQuantity(scaled, base.Format), nil
+}
+
+// scale 计算 value * ratio 并取整到 step 的整数倍。ratio 按最短的十进制表示转换为分数，
+// 比如 1.1 按 11/10 计算，中间结果使用 big.Int，不会因为浮点误差或溢出得到错误的结果
+func scale(value int64, ratio float64, step int64, rounding Rounding) (int64, error) {
+	if value <= 0 {
+		return 0, fmt.Errorf("base value must be greater than 0, got %d", value)
+	}
+	if step <= 0 {
+		return 0, fmt.Errorf("step must be greater than 0, got %d", step)
+	}
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(ratio, 'f', -1, 64))
+	if !ok || r.Sign() <= 0 {
+		return 0, fmt.Errorf("ratio must be greater than 0, got %v", ratio)
+	}
+
+	// value * num / (den * step) 取整后再乘以 step
+	num := new(big.Int).Mul(big.NewInt(value), r.Num())
+	den := new(big.Int).Mul(r.Denom(), big.NewInt(step))
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	switch rounding {
+	case RoundUp:
+		if rem.Sign() > 0 {
+			quo.Add(quo, big.NewInt(1))
+		}
+	case RoundNearest:
+		// 余数不小于一半时进位
+		if rem.Lsh(rem, 1).Cmp(den) >= 0 {
+			quo.Add(quo, big.NewInt(1))
+		}
+	case RoundDown, "":
+	default:
+		return 0, fmt.Errorf("invalid rounding %q", rounding)
+	}
+
+	result := quo.Mul(quo, big.NewInt(step))
+	if !result.IsInt64() {
+		return 0, fmt.Errorf("%d * %v overflows int64", value, ratio)
+	}
+	if result.Sign() <= 0 {
+		return 0, fmt.Errorf("%d * %v rounds down to 0 with step %d", value, ratio, step)
+	}
+	return result.Int64(), nil
+}
@@ -0,0 +1,99 @@
+package cpu_oversell
+
+import (
+	"math"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestScale(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    int64
+		ratio    float64
+		step     int64
+		rounding Rounding
+		want     int64
+		wantErr  bool
+	}{
+		{name: "half ratio", value: 4000, ratio: 0.5, step: 1, rounding: RoundDown, want: 2000},
+		{name: "half ratio of odd millis rounds down", value: 3, ratio: 0.5, step: 1, rounding: RoundDown, want: 1},
+		{name: "half ratio of odd millis rounds up", value: 3, ratio: 0.5, step: 1, rounding: RoundUp, want: 2},
+		{name: "half ratio of odd millis rounds to nearest", value: 3, ratio: 0.5, step: 1, rounding: RoundNearest, want: 2},
+		{name: "fractional cores are kept", value: 3500, ratio: 1.5, step: 1, rounding: RoundDown, want: 5250},
+		// 3000 * 1.1 按浮点计算是 3300.0000000000005，向上取整会得到 3301
+		{name: "decimal ratio is exact when rounding up", value: 3000, ratio: 1.1, step: 1, rounding: RoundUp, want: 3300},
+		// 10 * 0.7 按浮点计算是 7.000000000000001
+		{name: "decimal ratio is exact for small values", value: 10, ratio: 0.7, step: 1, rounding: RoundUp, want: 7},
+		{name: "round down to whole cores", value: 3500, ratio: 1.5, step: 1000, rounding: RoundDown, want: 5000},
+		{name: "round up to whole cores", value: 3500, ratio: 1.5, step: 1000, rounding: RoundUp, want: 6000},
+		{name: "round to nearest whole core", value: 3300, ratio: 1.5, step: 1000, rounding: RoundNearest, want: 5000},
+		{name: "exact multiple of step is unchanged", value: 4000, ratio: 1.5, step: 1000, rounding: RoundUp, want: 6000},
+		{name: "default rounding is down", value: 7, ratio: 1.5, step: 1, want: 10},
+		// 512 核按 milli 计算
+		{name: "large cpu node", value: 512000, ratio: 2.5, step: 1, rounding: RoundDown, want: 1280000},
+		// 12TiB 内存按字节计算
+		{name: "large memory node", value: 12 << 40, ratio: 1.3, step: 1, rounding: RoundDown, want: 17152381393305},
+		// 超过 float64 能精确表示的整数范围
+		{name: "beyond float64 precision", value: 1<<53 + 1, ratio: 1.5, step: 1, rounding: RoundDown, want: 13510798882111489},
+		{name: "result near int64 max", value: math.MaxInt64 / 2, ratio: 2, step: 1, rounding: RoundDown, want: math.MaxInt64 - 1},
+		{name: "overflow", value: math.MaxInt64 / 2, ratio: 2.5, step: 1, rounding: RoundDown, wantErr: true},
+		{name: "rounds down to zero", value: 1, ratio: 0.5, step: 1, rounding: RoundDown, wantErr: true},
+		{name: "zero value", value: 0, ratio: 2, step: 1, rounding: RoundDown, wantErr: true},
+		{name: "zero ratio", value: 1000, ratio: 0, step: 1, rounding: RoundDown, wantErr: true},
+		{name: "invalid ratio", value: 1000, ratio: math.NaN(), step: 1, rounding: RoundDown, wantErr: true},
+		{name: "invalid step", value: 1000, ratio: 2, step: 0, rounding: RoundDown, wantErr: true},
+		{name: "invalid rounding", value: 1000, ratio: 2, step: 1, rounding: "Half", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := scale(tc.value, tc.ratio, tc.step, tc.rounding)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("scale() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("scale() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestScaleQuantity(t *testing.T) {
+	step := func(s string) *resource.Quantity {
+		q := resource.MustParse(s)
+		return &q
+	}
+
+	testCases := []struct {
+		name     string
+		resource corev1.ResourceName
+		base     string
+		ratio    float64
+		step     *resource.Quantity
+		rounding Rounding
+		want     string
+	}{
+		{name: "cpu in millis", resource: corev1.ResourceCPU, base: "3920m", ratio: 1.5, want: "5880m"},
+		{name: "cpu rounded to whole cores", resource: corev1.ResourceCPU, base: "3920m", ratio: 1.5, step: step("1"), want: "5"},
+		{name: "cpu step in millis", resource: corev1.ResourceCPU, base: "3920m", ratio: 1.5, step: step("500m"), rounding: RoundNearest, want: "6"},
+		{name: "memory in bytes", resource: corev1.ResourceMemory, base: "16Gi", ratio: 0.5, want: "8Gi"},
+		{name: "memory rounded to Gi", resource: corev1.ResourceMemory, base: "15800Mi", ratio: 1.5, step: step("1Gi"), want: "23Gi"},
+		{name: "pods", resource: corev1.ResourcePods, base: "110", ratio: 1.5, want: "165"},
+		{name: "ephemeral-storage", resource: corev1.ResourceEphemeralStorage, base: "100Gi", ratio: 1.2, rounding: RoundUp, want: "128849018880"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := scaleQuantity(tc.resource, resource.MustParse(tc.base), tc.ratio, tc.step, tc.rounding)
+			if err != nil {
+				t.Fatalf("scaleQuantity() error = %v", err)
+			}
+			if want := resource.MustParse(tc.want); got.Cmp(want) != 0 {
+				t.Errorf("scaleQuantity() = %s, want %s", got.String(), tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package cpu_oversell
+
+import (
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/admission"
+	"github.com/aloys.zy/aloys-webhook-example/internal/registry"
+)
+
+func init() {
+	// 节点创建和 kubelet 上报状态时按超卖比例调整 allocatable 的 cpu、memory、ephemeral-storage 和 pods
+	registry.Register(registry.Descriptor{
+		Name: mutatingWebhookName,
+		Path: "/mutating-cpu-oversell",
+		Type: registry.Mutating,
+		Rules: []admissionregistrationv1.RuleWithOperations{{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{""},
+				APIVersions: []string{"v1"},
+				Resources:   []string{"nodes", "nodes/status"},
+			},
+		}},
+		FailurePolicy: admissionregistrationv1.Fail,
+		Handler: (&admission.Handler[*corev1.Node]{
+			Name:      mutatingWebhookName,
+			Resources: []metav1.GroupVersionResource{nodeResource},
+			New:       func() *corev1.Node { return &corev1.Node{} },
+			Mutate:    MutateCPUOversell,
+		}).AdmitHandler(),
+	})
+	// 拒绝超出范围的超卖标签，以及不在白名单中的用户或组修改超卖标签和注解。
+	// 服务不可用时拒绝修改，避免跳过修改者检查；kubelet 上报自己节点的状态不经过这个 webhook，服务不可用时不影响节点心跳
+	registry.Register(registry.Descriptor{
+		Name: "validating-node-oversell",
+		Path: "/validating-node-oversell",
+		Type: registry.Validating,
+		Rules: []admissionregistrationv1.RuleWithOperations{{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{""},
+				APIVersions: []string{"v1"},
+				Resources:   []string{"nodes", "nodes/status"},
+			},
+		}},
+		FailurePolicy: admissionregistrationv1.Fail,
+		MatchConditions: []admissionregistrationv1.MatchCondition{{
+			Name:       "exclude-kubelet-status-updates",
+			Expression: `!(request.subResource == "status" && request.userInfo.username == "system:node:" + request.name)`,
+		}},
+		Handler: (&admission.Handler[*corev1.Node]{
+			Name:      "validating-node-oversell",
+			Resources: []metav1.GroupVersionResource{nodeResource},
+			New:       func() *corev1.Node { return &corev1.Node{} },
+			Validate:  ValidateNodeOversell,
+		}).AdmitHandler(),
+	})
+}
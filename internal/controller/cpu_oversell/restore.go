@@ -0,0 +1,191 @@
+package cpu_oversell
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/registry"
+)
+
+const (
+	// mutatingWebhookName 是调整 allocatable 的 webhook，禁用时不恢复节点
+	mutatingWebhookName = "mutating-cpu-oversell"
+	// restoreResyncPeriod 是重新检查所有节点的周期，超卖策略变化不会触发节点事件，由定期检查恢复
+	restoreResyncPeriod = 5 * time.Minute
+	// restorePatchTimeout 是一次更新节点 status 的超时时间
+	restorePatchTimeout = 10 * time.Second
+)
+
+// AllocatableRestorer 通过 informer 监听节点，关闭超卖后把 allocatable 恢复为注解中记录的原始值。
+// 关闭超卖的请求通常是修改节点标签，更新节点主资源时 API server 忽略 status 的修改，MutateCPUOversell 无法恢复 allocatable，
+// 不主动恢复时要等到 kubelet 下一次上报状态；恢复时更新 nodes/status，同样经过 mutating-cpu-oversell，由它删除记录的注解。
+type AllocatableRestorer struct {
+	client   kubernetes.Interface
+	recorder record.EventRecorder
+	factory  informers.SharedInformerFactory
+	informer cache.SharedIndexInformer
+	lister   corev1listers.NodeLister
+	queue    workqueue.TypedRateLimitingInterface[string]
+}
+
+// NewAllocatableRestorer 创建 AllocatableRestorer，恢复 allocatable 时通过 recorder 在节点上记录事件
+func NewAllocatableRestorer(client kubernetes.Interface, recorder record.EventRecorder) (*AllocatableRestorer, error) {
+	r := &AllocatableRestorer{client: client, recorder: recorder}
+	r.factory = informers.NewSharedInformerFactory(client, restoreResyncPeriod)
+	nodes := r.factory.Core().V1().Nodes()
+	r.informer = nodes.Informer()
+	r.lister = nodes.Lister()
+	r.queue = workqueue.NewTypedRateLimitingQueueWithConfig(workqueue.DefaultTypedControllerRateLimiter[string](),
+		workqueue.TypedRateLimitingQueueConfig[string]{Name: "cpu-oversell-restore"})
+	_, err := r.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { r.enqueue(obj) },
+		UpdateFunc: func(_, obj interface{}) { r.enqueue(obj) },
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add event handler: %w", err)
+	}
+	return r, nil
+}
+
+// Start 启动 informer 和处理队列，直到 ctx 结束
+func (r *AllocatableRestorer) Start(ctx context.Context) error {
+	setupLog := ctrl.Log.WithName("cpu-oversell-restore")
+	setupLog.Info("Starting cpu oversell allocatable restorer")
+
+	r.factory.Start(ctx.Done())
+	go func() {
+		<-ctx.Done()
+		r.queue.ShutDown()
+	}()
+	if cache.WaitForCacheSync(ctx.Done(), r.informer.HasSynced) {
+		for r.processNext(ctx) {
+		}
+	}
+	r.factory.Shutdown()
+	setupLog.Info("Stopped cpu oversell allocatable restorer")
+	return nil
+}
+
+// HasSynced 返回 informer 是否已经完成第一次 list
+func (r *AllocatableRestorer) HasSynced() bool {
+	return r.informer.HasSynced()
+}
+
+// enqueue 只把记录了原始 allocatable 的节点加入队列
+func (r *AllocatableRestorer) enqueue(obj interface{}) {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	for _, name := range Resources {
+		if _, found := node.GetAnnotations()[OriginalAllocatableAnnotation(name)]; found {
+			r.queue.Add(node.Name)
+			return
+		}
+	}
+}
+
+func (r *AllocatableRestorer) processNext(ctx context.Context) bool {
+	setupLog := ctrl.Log.WithName("cpu-oversell-restore")
+
+	name, shutdown := r.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer r.queue.Done(name)
+
+	if err := r.reconcile(ctx, name); err != nil {
+		setupLog.Error(err, "Failed to restore allocatable, retrying", "node", name)
+		r.queue.AddRateLimited(name)
+		return true
+	}
+	r.queue.Forget(name)
+	return true
+}
+
+// reconcile 恢复一个节点上已经关闭超卖的资源的 allocatable
+func (r *AllocatableRestorer) reconcile(ctx context.Context, name string) error {
+	setupLog := ctrl.Log.WithName("cpu-oversell-restore")
+
+	if !registry.IsEnabled(mutatingWebhookName) {
+		return nil
+	}
+	node, err := r.lister.Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	restore := restoredAllocatable(node)
+	if len(restore) == 0 {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{"allocatable": restore},
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, restorePatchTimeout)
+	defer cancel()
+	_, err = r.client.CoreV1().Nodes().Patch(ctx, name, types.StrategicMergePatchType, patch, metav1.PatchOptions{}, "status")
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to patch node status: %w", err)
+	}
+
+	var restored []string
+	for _, name := range Resources {
+		if q, ok := restore[name]; ok {
+			restored = append(restored, fmt.Sprintf("%s restored to %s", name, q.String()))
+		}
+	}
+	message := "Allocatable updated: " + strings.Join(restored, ", ")
+	r.recorder.Eventf(node, corev1.EventTypeNormal, "Restored", message)
+	setupLog.Info(message, "node", node.Name)
+	return nil
+}
+
+// restoredAllocatable 返回需要恢复的资源和记录的原始值：关闭了超卖（没有标签、策略不再匹配或标签无效），
+// 但 allocatable 仍然不是记录的原始值
+func restoredAllocatable(node *corev1.Node) corev1.ResourceList {
+	restore := corev1.ResourceList{}
+	for _, name := range Resources {
+		value, found := node.GetAnnotations()[OriginalAllocatableAnnotation(name)]
+		if !found {
+			continue
+		}
+		original, err := resource.ParseQuantity(value)
+		if err != nil {
+			continue
+		}
+		if result, err := oversellResource(node, name); err == nil && result.oversold {
+			continue
+		}
+		if current, ok := node.Status.Allocatable[name]; ok && current.Cmp(original) == 0 {
+			continue
+		}
+		restore[name] = original
+	}
+	return restore
+}
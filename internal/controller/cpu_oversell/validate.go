@@ -0,0 +1,169 @@
+package cpu_oversell
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"sync/atomic"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/admission"
+)
+
+// allowList 是允许修改超卖标签和注解的用户和组，allowAll 为 true 时不检查修改者
+type allowList struct {
+	users    []string
+	groups   []string
+	allowAll bool
+}
+
+// currentAllowList 由 SetAllowList 在启动时设置，为 nil 或为空时只有节点自己的 kubelet 可以修改超卖标签和注解
+var currentAllowList atomic.Pointer[allowList]
+
+// SetAllowList 设置允许修改超卖标签和注解的用户和组（--oversell-allowed-users 和 --oversell-allowed-groups），
+// allowAll 为 true 时不检查修改者（--oversell-allow-all-users），需要在 webhook 服务启动之前调用
+func SetAllowList(users, groups []string, allowAll bool) {
+	currentAllowList.Store(&allowList{users: users, groups: groups, allowAll: allowAll})
+}
+
+// oversellValidation 是超卖标签允许的比例范围和允许修改超卖标签、注解的用户和组
+type oversellValidation struct {
+	bounds ratioRange
+	allowList
+}
+
+// ValidateNodeOversell 拒绝超出配置范围的 <资源>_oversell 标签，以及不在白名单中的用户或组修改超卖标签和注解。
+// 比例范围和白名单分别由 SetRatioBounds 和 SetAllowList 设置。
+// 节点自己的 kubelet（system:node:<节点名>）不检查修改者，kubelet 上报状态不会被拒绝
+func ValidateNodeOversell(ctx context.Context, req *admissionv1.AdmissionRequest, node, old *corev1.Node) error {
+	v := oversellValidation{bounds: ratioRange{max: math.Inf(1)}}
+	if b := ratioBounds.Load(); b != nil {
+		v.bounds = *b
+	}
+	if a := currentAllowList.Load(); a != nil {
+		v.allowList = *a
+	}
+	return v.validate(ctx, req, node, old)
+}
+
+func (v oversellValidation) validate(_ context.Context, req *admissionv1.AdmissionRequest, node, old *corev1.Node) error {
+	setupLog := ctrl.Log.WithName("ValidateNodeOversell")
+
+	var oldLabels, oldAnnotations map[string]string
+	if old != nil {
+		oldLabels, oldAnnotations = old.GetLabels(), old.GetAnnotations()
+	}
+	allowed := v.allowed(req.UserInfo, node)
+
+	// 只检查这次请求修改的标签，已有的无效标签由 mutating webhook 处理，不影响节点的其他更新
+	for _, name := range Resources {
+		key := LabelKey(name)
+		value, found := node.GetLabels()[key]
+		if !changed(value, found, oldLabels, key) {
+			continue
+		}
+		if !allowed {
+			setupLog.Info("Denied oversell label change", "node", node.Name, "label", key, "user", req.UserInfo.Username)
+			return admission.Deniedf("user %q is not allowed to change the %s label of node %s", req.UserInfo.Username, key, node.Name)
+		}
+		if !found {
+			continue
+		}
+		if err := v.checkRatio(value); err != nil {
+			return admission.Deniedf("invalid value %q for %s label on node %s: %v", value, key, node.Name, err)
+		}
+	}
+	if allowed {
+		return nil
+	}
+
+	// mutating webhook 在 validating webhook 之前调用，请求中的注解可能是 mutating webhook 重新计算后写入的，
+	// 只有与 mutating webhook 写入的值不同时才算用户修改
+	for _, name := range Resources {
+		for _, key := range []string{LabelKey(name), OriginalAllocatableAnnotation(name), RatioAnnotation(name)} {
+			value, found := node.GetAnnotations()[key]
+			if !changed(value, found, oldAnnotations, key) || writtenByMutator(req, node, old, name, key) {
+				continue
+			}
+			setupLog.Info("Denied oversell annotation change", "node", node.Name, "annotation", key, "user", req.UserInfo.Username)
+			return admission.Deniedf("user %q is not allowed to change the %s annotation of node %s", req.UserInfo.Username, key, node.Name)
+		}
+	}
+	return nil
+}
+
+// allowed 判断请求者是否可以修改超卖标签和注解：不检查修改者、节点自己的 kubelet，或者用户及其所属的任一用户组在白名单中。
+// 白名单为空时只有节点自己的 kubelet 可以修改
+func (v oversellValidation) allowed(user authenticationv1.UserInfo, node *corev1.Node) bool {
+	if v.allowAll {
+		return true
+	}
+	if user.Username == "system:node:"+node.Name || slices.Contains(v.users, user.Username) {
+		return true
+	}
+	for _, g := range user.Groups {
+		if slices.Contains(v.groups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRatio 检查标签的比例是否是 [bounds.min, bounds.max] 范围内的正数
+func (v oversellValidation) checkRatio(value string) error {
+	ratio, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("ratio must be a number")
+	}
+	if math.IsNaN(ratio) || ratio <= 0 || ratio < v.bounds.min || ratio > v.bounds.max {
+		return fmt.Errorf("ratio must be greater than 0 and within [%v, %v]", v.bounds.min, v.bounds.max)
+	}
+	return nil
+}
+
+// changed 判断标签或注解是否新增、修改或删除
+func changed(value string, found bool, old map[string]string, key string) bool {
+	oldValue, oldFound := old[key]
+	return found != oldFound || value != oldValue
+}
+
+// writtenByMutator 判断超卖注解的当前值是否是 mutating-cpu-oversell 处理这次请求时写入的值，
+// 规则与 MutateCPUOversell 一致：超卖时写入 "true"、比例和原始 allocatable，不超卖时写入 "false"，
+// 并在 CREATE 和 status 更新时删除比例和原始 allocatable
+func writtenByMutator(req *admissionv1.AdmissionRequest, node, old *corev1.Node, name corev1.ResourceName, key string) bool {
+	value, found := node.GetAnnotations()[key]
+	result, err := oversellResource(node, name)
+	oversold := err == nil && result.oversold
+	forgotten := req.Operation == admissionv1.Create || req.SubResource == "status"
+
+	switch key {
+	case LabelKey(name):
+		return found && value == strconv.FormatBool(oversold)
+	case RatioAnnotation(name):
+		if !oversold {
+			return !found && forgotten
+		}
+		return found && value == strconv.FormatFloat(result.ratio, 'f', -1, 64)
+	case OriginalAllocatableAnnotation(name):
+		if !oversold {
+			return !found && forgotten
+		}
+		if !found {
+			return false
+		}
+		// 创建节点时原始值是请求中上报的 allocatable，无法从超卖后的值得到
+		if old == nil {
+			return true
+		}
+		// 之前没有记录时，原始值是超卖前的 allocatable
+		previous, ok := old.Status.Allocatable[name]
+		return ok && value == previous.String()
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package cpu_oversell
+
+import (
+	"context"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiresource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateNodeOversell(t *testing.T) {
+	node := func(labels, annotations map[string]string, allocatable string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: labels, Annotations: annotations},
+			Status: corev1.NodeStatus{
+				Capacity:    corev1.ResourceList{corev1.ResourceCPU: apiresource.MustParse("4")},
+				Allocatable: corev1.ResourceList{corev1.ResourceCPU: apiresource.MustParse(allocatable)},
+			},
+		}
+	}
+	kubelet := authenticationv1.UserInfo{Username: "system:node:node", Groups: []string{"system:nodes"}}
+	admin := authenticationv1.UserInfo{Username: "alice", Groups: []string{"system:masters"}}
+	other := authenticationv1.UserInfo{Username: "bob", Groups: []string{"system:authenticated"}}
+	oversold := map[string]string{
+		CPUOversell: "true",
+		OriginalAllocatableAnnotation(corev1.ResourceCPU): "4",
+		RatioAnnotation(corev1.ResourceCPU):               "1.5",
+	}
+
+	testCases := []struct {
+		name        string
+		user        authenticationv1.UserInfo
+		operation   admissionv1.Operation
+		subResource string
+		node        *corev1.Node
+		old         *corev1.Node
+		wantErr     bool
+	}{
+		{
+			name:      "valid label from an allowed group",
+			user:      admin,
+			operation: admissionv1.Update,
+			node:      node(map[string]string{CPUOversell: "1.5"}, nil, "4"),
+			old:       node(nil, nil, "4"),
+		},
+		{
+			name:      "non-numeric label",
+			user:      admin,
+			operation: admissionv1.Update,
+			node:      node(map[string]string{CPUOversell: "abc"}, nil, "4"),
+			old:       node(nil, nil, "4"),
+			wantErr:   true,
+		},
+		{
+			name:      "label above the maximum ratio",
+			user:      admin,
+			operation: admissionv1.Update,
+			node:      node(map[string]string{CPUOversell: "50"}, nil, "4"),
+			old:       node(nil, nil, "4"),
+			wantErr:   true,
+		},
+		{
+			name:      "memory label below the minimum ratio",
+			user:      admin,
+			operation: admissionv1.Update,
+			node:      node(map[string]string{"memory_oversell": "0.05"}, nil, "4"),
+			old:       node(nil, nil, "4"),
+			wantErr:   true,
+		},
+		{
+			name:      "invalid label on create",
+			user:      kubelet,
+			operation: admissionv1.Create,
+			node:      node(map[string]string{CPUOversell: "NaN"}, nil, "4"),
+			wantErr:   true,
+		},
+		{
+			name:      "label change from a user not on the allow-list",
+			user:      other,
+			operation: admissionv1.Update,
+			node:      node(map[string]string{CPUOversell: "2"}, nil, "4"),
+			old:       node(map[string]string{CPUOversell: "1.5"}, nil, "4"),
+			wantErr:   true,
+		},
+		{
+			name:      "label removal from a user not on the allow-list",
+			user:      other,
+			operation: admissionv1.Update,
+			node:      node(nil, nil, "4"),
+			old:       node(map[string]string{CPUOversell: "1.5"}, nil, "4"),
+			wantErr:   true,
+		},
+		{
+			name:      "other changes from a user not on the allow-list",
+			user:      other,
+			operation: admissionv1.Update,
+			node:      node(map[string]string{CPUOversell: "1.5", "pool": "batch"}, oversold, "6"),
+			old:       node(map[string]string{CPUOversell: "1.5"}, oversold, "6"),
+		},
+		{
+			name:        "kubelet status update keeps an existing invalid label",
+			user:        kubelet,
+			operation:   admissionv1.Update,
+			subResource: "status",
+			node:        node(map[string]string{CPUOversell: "abc"}, map[string]string{CPUOversell: "false"}, "3900m"),
+			old:         node(map[string]string{CPUOversell: "abc"}, map[string]string{CPUOversell: "false"}, "4"),
+		},
+		{
+			name:      "label change from the kubelet of another node",
+			user:      authenticationv1.UserInfo{Username: "system:node:other", Groups: []string{"system:nodes"}},
+			operation: admissionv1.Update,
+			node:      node(map[string]string{CPUOversell: "2"}, nil, "4"),
+			old:       node(nil, nil, "4"),
+			wantErr:   true,
+		},
+		{
+			name:        "annotations written by the mutating webhook",
+			user:        other,
+			operation:   admissionv1.Update,
+			subResource: "status",
+			node:        node(map[string]string{CPUOversell: "1.5"}, oversold, "6"),
+			old:         node(map[string]string{CPUOversell: "1.5"}, nil, "4"),
+		},
+		{
+			name:      "annotation change from a user not on the allow-list",
+			user:      other,
+			operation: admissionv1.Update,
+			node: node(map[string]string{CPUOversell: "1.5"}, map[string]string{
+				CPUOversell: "true",
+				OriginalAllocatableAnnotation(corev1.ResourceCPU): "8",
+				RatioAnnotation(corev1.ResourceCPU):               "1.5",
+			}, "6"),
+			old:     node(map[string]string{CPUOversell: "1.5"}, oversold, "6"),
+			wantErr: true,
+		},
+	}
+
+	v := oversellValidation{bounds: ratioRange{min: 0.1, max: 10}, allowList: allowList{groups: []string{"system:masters"}}}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &admissionv1.AdmissionRequest{Operation: tc.operation, SubResource: tc.subResource, UserInfo: tc.user}
+			err := v.validate(context.Background(), req, tc.node, tc.old)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestOversellValidationAllowed(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"}}
+	bob := authenticationv1.UserInfo{Username: "bob", Groups: []string{"dev"}}
+	kubelet := authenticationv1.UserInfo{Username: "system:node:node", Groups: []string{"system:nodes"}}
+
+	testCases := []struct {
+		name string
+		v    oversellValidation
+		user authenticationv1.UserInfo
+		want bool
+	}{
+		{name: "empty allow-lists deny other users", user: bob},
+		{name: "empty allow-lists allow the kubelet of the node", user: kubelet, want: true},
+		{name: "allow all users", v: oversellValidation{allowList: allowList{allowAll: true}}, user: bob, want: true},
+		{name: "allowed user", v: oversellValidation{allowList: allowList{users: []string{"bob"}}}, user: bob, want: true},
+		{name: "allowed group", v: oversellValidation{allowList: allowList{groups: []string{"dev"}}}, user: bob, want: true},
+		{name: "not allowed", v: oversellValidation{allowList: allowList{users: []string{"alice"}, groups: []string{"system:masters"}}}, user: bob},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.v.allowed(tc.user, node); got != tc.want {
+				t.Errorf("allowed() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -1,11 +1,11 @@
 package pod_dns
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"reflect"
 
-	"github.com/aloys.zy/aloys-webhook-example/internal/setting"
 	"github.com/aloys.zy/aloys-webhook-example/internal/util"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -14,45 +14,19 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
+// podResource 是 pod 资源
+var podResource = metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
+
 // MutatePodDNSConfig 这是获取集群信息进行注入的方式
-func MutatePodDNSConfig(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+func MutatePodDNSConfig(ctx context.Context, req *admissionv1.AdmissionRequest, pod, oldPod *corev1.Pod) error {
 	setupLog := ctrl.Log.WithName("MutatePodDNSConfig")
 
-	podResource := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
-
-	// 检查请求是否针对 pod 资源
-	if ar.Request.Resource != podResource {
-		setupLog.Error(nil, "InvalidResource",
-			"expected resource to be ", podResource,
-			"got", ar.Request.Resource)
-		return util.GeneratePatchAndResponse(nil, nil, false, "", fmt.Sprintf("expected resource to be %s", podResource))
-	}
-
-	var pod, oldPod corev1.Pod
-	deserializer := setting.Codecs.UniversalDeserializer()
-
-	// 解码新对象
-	if _, _, err := deserializer.Decode(ar.Request.Object.Raw, nil, &pod); err != nil {
-		setupLog.Error(err, "Failed to decode new pod object")
-		return setting.ToV1AdmissionResponse(err)
-	}
-
-	// 如果是 UPDATE 操作，解码旧对象
-	if ar.Request.Operation == admissionv1.Update {
-		if _, _, err := deserializer.Decode(ar.Request.OldObject.Raw, nil, &oldPod); err != nil {
-			setupLog.Error(err, "Failed to decode old pod object")
-			return setting.ToV1AdmissionResponse(err)
-		}
-
-		// 比较 spec 是否相同，如果是 status 更新则忽略
-		if reflect.DeepEqual(oldPod.Spec, pod.Spec) {
-			util.EventRecorder().Eventf(&pod, corev1.EventTypeNormal, "DeepEqual", "Ignoring status update pod Namespace:%s,pod Name:%s", pod.Namespace, pod.Name)
-			setupLog.Info("Ignoring status update for pod", "pod Namespace", pod.Namespace, "pod Name", pod.Name)
-			return util.GeneratePatchAndResponse(&pod, nil, true, "", "")
-		}
+	// 如果是 UPDATE 操作，比较 spec 是否相同，如果是 status 更新则忽略
+	if req.Operation == admissionv1.Update && reflect.DeepEqual(oldPod.Spec, pod.Spec) {
+		util.EventRecorder().Eventf(pod, corev1.EventTypeNormal, "DeepEqual", "Ignoring status update pod Namespace:%s,pod Name:%s", pod.Namespace, pod.Name)
+		setupLog.Info("Ignoring status update for pod", "pod Namespace", pod.Namespace, "pod Name", pod.Name)
+		return nil
 	}
-	// pod 就是本次请求的pod，
-	originalPod := pod.DeepCopy()
 
 	// 修改 DNS 配置
 	if pod.Spec.DNSConfig == nil {
@@ -71,9 +45,9 @@ func MutatePodDNSConfig(ar admissionv1.AdmissionReview) *admissionv1.AdmissionRe
 	}
 	pod.Spec.DNSConfig.Searches = search
 
-	localDnsBindAddress, coreDNSBindAddress, err := util.GetDNSIP()
+	localDnsBindAddress, coreDNSBindAddress, err := util.GetDNSIP(ctx)
 	if err != nil {
-		util.EventRecorder().Eventf(&pod, corev1.EventTypeWarning, "GetDNSIP", "Failed to get DNSIP addresses %v", err)
+		util.EventRecorder().Eventf(pod, corev1.EventTypeWarning, "GetDNSIP", "Failed to get DNSIP addresses %v", err)
 		setupLog.Error(err, "Failed to get DNSIP addresses")
 		// return setting.ToV1AdmissionResponse(err)
 	}
@@ -97,10 +71,10 @@ func MutatePodDNSConfig(ar admissionv1.AdmissionReview) *admissionv1.AdmissionRe
 		"pod GenerateName", pod.GenerateName) // 如果 pod.Name 为空，则可以参考 GenerateName
 
 	// 	根据pod找到对应控制器添加事件信息
-	if err = util.GetControllerName(&pod, "Mutated DNS", "Mutated DNS configuration for pod"); err != nil {
+	if err = util.GetControllerName(ctx, pod, "Mutated DNS", "Mutated DNS configuration for pod"); err != nil {
 		setupLog.Error(err, "Failed to get controller name for pod")
 	}
-	return util.GeneratePatchAndResponse(originalPod, &pod, true, "", "")
+	return nil
 }
 
 func stringPtr(s string) *string {
@@ -0,0 +1,45 @@
+package pod_dns
+
+import (
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/admission"
+	"github.com/aloys.zy/aloys-webhook-example/internal/registry"
+)
+
+func init() {
+	// pod 创建时追加 DNS 配置
+	registry.Register(registry.Descriptor{
+		Name: "mutating-pod-dns",
+		Path: "/mutating-pod-dns",
+		Type: registry.Mutating,
+		Rules: []admissionregistrationv1.RuleWithOperations{{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{""},
+				APIVersions: []string{"v1"},
+				Resources:   []string{"pods"},
+			},
+		}},
+		FailurePolicy: admissionregistrationv1.Fail,
+		// 带 exclude-webhook-podDns 标签的 namespace 不注入
+		NamespaceSelector: &metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{
+				Key:      "exclude-webhook-podDns",
+				Operator: metav1.LabelSelectorOpDoesNotExist,
+			}},
+		},
+		Handler: (&admission.Handler[*corev1.Pod]{
+			Name:      "mutating-pod-dns",
+			Resources: []metav1.GroupVersionResource{podResource},
+			New:       func() *corev1.Pod { return &corev1.Pod{} },
+			// 注入 DNS 配置之后的 pod 修改（比如标签、sidecar）作为新的 mutator 追加到流水线中，
+			// 共用这一个 webhook，只返回一个合并后的 Patch
+			Mutate: admission.Pipeline("mutating-pod-dns",
+				admission.Mutator[*corev1.Pod]{Name: "dns-config", Mutate: MutatePodDNSConfig},
+			),
+		}).AdmitHandler(),
+	})
+}
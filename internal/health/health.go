@@ -0,0 +1,205 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// Checker 是一个健康检查，返回错误表示检查失败
+type Checker func(req *http.Request) error
+
+// Ping 总是成功，用于存活检查
+func Ping(_ *http.Request) error {
+	return nil
+}
+
+// SyncedChecker 把 informer 的 HasSynced 适配为 Checker
+func SyncedChecker(hasSynced func() bool) Checker {
+	return func(_ *http.Request) error {
+		if !hasSynced() {
+			return fmt.Errorf("cache is not synced yet")
+		}
+		return nil
+	}
+}
+
+// draining 为 true 表示进程已收到退出信号，正在等待流量被摘除
+var draining atomic.Bool
+
+// StartDraining 标记进程进入关闭前的排空阶段，之后 ShutdownChecker 一直失败
+func StartDraining() {
+	draining.Store(true)
+}
+
+// ShutdownChecker 在排空阶段返回错误，让 Kubernetes 把本副本从 Service 的 Endpoints 中摘除。
+// 只能注册为就绪检查，注册为存活检查会导致容器在排空阶段被重启
+func ShutdownChecker(_ *http.Request) error {
+	if draining.Load() {
+		return fmt.Errorf("process is shutting down")
+	}
+	return nil
+}
+
+// Ready 执行所有就绪检查，有检查失败时返回错误。用于进程内部等待就绪，比如服务就绪后再注册 webhook 配置，
+// 检查通过 ctx 控制超时
+func Ready(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/readyz", nil)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, c := range readyz.list() {
+		if err := c.check(req); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", c.name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("readyz checks failed: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+type namedCheck struct {
+	name  string
+	check Checker
+}
+
+// Registry 保存各个子系统注册的检查项
+type Registry struct {
+	mu     sync.RWMutex
+	checks []namedCheck
+}
+
+var (
+	readyz = &Registry{}
+	livez  = &Registry{}
+)
+
+// AddReadyzCheck 注册就绪检查，失败时 Kubernetes 不再把流量路由到该副本
+func AddReadyzCheck(name string, check Checker) {
+	readyz.Add(name, check)
+}
+
+// AddLivezCheck 注册存活检查，失败时 Kubernetes 会重启容器，只应该注册进程自身无法恢复的检查
+func AddLivezCheck(name string, check Checker) {
+	livez.Add(name, check)
+}
+
+// ReadyzHandler 返回 /readyz 的处理函数
+func ReadyzHandler() http.Handler {
+	return &handler{name: "readyz", registry: readyz}
+}
+
+// LivezHandler 返回 /livez 的处理函数
+func LivezHandler() http.Handler {
+	return &handler{name: "livez", registry: livez}
+}
+
+// Add 注册检查项，同名检查项会被替换
+func (r *Registry) Add(name string, check Checker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.checks {
+		if r.checks[i].name == name {
+			r.checks[i].check = check
+			return
+		}
+	}
+	r.checks = append(r.checks, namedCheck{name: name, check: check})
+}
+
+func (r *Registry) list() []namedCheck {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]namedCheck(nil), r.checks...)
+}
+
+// handler 执行所有检查项，输出格式与 kube-apiserver 的 /readyz 一致：
+// 全部通过时返回 "ok"，带 ?verbose 或有检查失败时逐项列出结果；
+// ?exclude=<name> 跳过指定检查项，/readyz/<name> 只执行单个检查项
+type handler struct {
+	name     string
+	registry *Registry
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	setupLog := ctrl.Log.WithName("health")
+	startTime := time.Now()
+
+	checks := h.registry.list()
+
+	// /readyz/<name> 只执行单个检查项
+	if sub, ok := strings.CutPrefix(req.URL.Path, "/"+h.name+"/"); ok && sub != "" {
+		var found []namedCheck
+		for _, c := range checks {
+			if c.name == sub {
+				found = append(found, c)
+			}
+		}
+		if len(found) == 0 {
+			http.Error(w, fmt.Sprintf("%s check %q not found", h.name, sub), http.StatusNotFound)
+			return
+		}
+		checks = found
+	}
+
+	excluded := make(map[string]struct{})
+	for _, name := range req.URL.Query()["exclude"] {
+		excluded[name] = struct{}{}
+	}
+
+	var out bytes.Buffer
+	var failed []string
+	for _, c := range checks {
+		if _, ok := excluded[c.name]; ok {
+			fmt.Fprintf(&out, "[+]%s excluded: ok\n", c.name)
+			continue
+		}
+		if err := c.check(req); err != nil {
+			failed = append(failed, c.name)
+			fmt.Fprintf(&out, "[-]%s failed: %v\n", c.name, err)
+			continue
+		}
+		fmt.Fprintf(&out, "[+]%s ok\n", c.name)
+	}
+
+	_, verbose := req.URL.Query()["verbose"]
+	status := http.StatusOK
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if len(failed) > 0 {
+		status = http.StatusInternalServerError
+		fmt.Fprintf(&out, "%s check failed\n", h.name)
+		w.WriteHeader(status)
+		_, _ = w.Write(out.Bytes())
+	} else if verbose {
+		fmt.Fprintf(&out, "%s check passed\n", h.name)
+		_, _ = w.Write(out.Bytes())
+	} else {
+		_, _ = w.Write([]byte("ok"))
+	}
+
+	// 检查失败时记录 Info 日志，成功时只在调试级别记录，避免探针刷屏
+	logger := setupLog.V(1)
+	if len(failed) > 0 {
+		logger = setupLog
+	}
+	logger.Info(
+		"Request completed",
+		"method", req.Method,
+		"url", req.URL.String(),
+		"remoteAddr", req.RemoteAddr,
+		"userAgent", req.UserAgent(),
+		"path", req.URL.Path,
+		"status", status,
+		"failedChecks", failed,
+		"elapsed_time", time.Since(startTime))
+}
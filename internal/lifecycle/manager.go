@@ -0,0 +1,186 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// Runnable 是由 Manager 管理的组件，Start 阻塞运行直到 ctx 结束，返回错误表示组件异常退出
+type Runnable interface {
+	Start(ctx context.Context) error
+}
+
+// RunnableFunc 把函数适配为 Runnable
+type RunnableFunc func(ctx context.Context) error
+
+func (f RunnableFunc) Start(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Drainer 是支持排空的组件，Manager 在排空阶段开始时调用 Drain，Drain 不能阻塞
+type Drainer interface {
+	Drain()
+}
+
+type runnable struct {
+	name        string
+	r           Runnable
+	stopTimeout time.Duration
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+type result struct {
+	name string
+	err  error
+}
+
+// Manager 同时启动所有组件，不保证组件之间的启动顺序，依赖其他组件的组件需要自己等待（比如等 informer 同步、服务就绪）。
+// 任一组件异常退出或 ctx 结束后，按添加的逆序逐个关闭组件。
+// 先添加后台组件（证书、informer 等），再添加监听端口的服务，这样关闭时先停止接收请求。
+type Manager struct {
+	runnables          []*runnable
+	defaultStopTimeout time.Duration
+
+	drainDuration time.Duration
+	drainHooks    []func()
+}
+
+// NewManager 创建 Manager，defaultStopTimeout 是每个组件默认的关闭超时时间
+func NewManager(defaultStopTimeout time.Duration) *Manager {
+	return &Manager{defaultStopTimeout: defaultStopTimeout}
+}
+
+// Add 添加组件，使用默认的关闭超时时间
+func (m *Manager) Add(name string, r Runnable) {
+	m.AddWithTimeout(name, r, m.defaultStopTimeout)
+}
+
+// AddWithTimeout 添加组件并指定关闭超时时间
+func (m *Manager) AddWithTimeout(name string, r Runnable, stopTimeout time.Duration) {
+	m.runnables = append(m.runnables, &runnable{name: name, r: r, stopTimeout: stopTimeout})
+}
+
+// SetDrainDuration 设置收到退出信号后、关闭组件前的排空时间，0 表示不排空
+func (m *Manager) SetDrainDuration(d time.Duration) {
+	m.drainDuration = d
+}
+
+// OnDrain 添加排空阶段开始时执行的函数，比如让就绪检查失败
+func (m *Manager) OnDrain(hook func()) {
+	m.drainHooks = append(m.drainHooks, hook)
+}
+
+// Run 在各自的 goroutine 中同时启动所有组件并阻塞，直到 ctx 结束或第一个组件返回错误，然后关闭所有组件。
+// ctx 结束时先进入排空阶段，组件异常退出时直接关闭。
+// 返回第一个导致退出的组件错误，正常关闭时返回 nil。
+func (m *Manager) Run(ctx context.Context) error {
+	setupLog := ctrl.Log.WithName("lifecycle")
+
+	results := make(chan result, len(m.runnables))
+	for _, r := range m.runnables {
+		// 每个组件使用独立的 ctx，以便按顺序关闭
+		runCtx, cancel := context.WithCancel(context.Background())
+		r.cancel = cancel
+		r.done = make(chan struct{})
+
+		setupLog.Info("Starting runnable", "name", r.name)
+		go func(r *runnable) {
+			defer close(r.done)
+			r.err = r.r.Start(runCtx)
+			results <- result{name: r.name, err: r.err}
+		}(r)
+	}
+
+	var runErr error
+	signaled := false
+	for running := len(m.runnables); running > 0 && runErr == nil && !signaled; {
+		select {
+		case <-ctx.Done():
+			setupLog.Info("Received shutdown signal")
+			signaled = true
+		case res := <-results:
+			running--
+			runErr = m.handleResult(res)
+		}
+	}
+
+	if signaled && runErr == nil {
+		runErr = m.drain(results)
+	}
+
+	m.stop()
+	return runErr
+}
+
+// handleResult 记录组件退出的结果，异常退出时返回带组件名的错误
+func (m *Manager) handleResult(res result) error {
+	setupLog := ctrl.Log.WithName("lifecycle")
+
+	if res.err != nil {
+		setupLog.Error(res.err, "Runnable exited with error, stopping the others", "name", res.name)
+		return fmt.Errorf("%s: %w", res.name, res.err)
+	}
+	setupLog.Info("Runnable exited", "name", res.name)
+	return nil
+}
+
+// drain 通知各组件进入排空阶段，然后等待 drainDuration，期间组件仍然正常处理请求。
+// 排空期间有组件异常退出时提前结束并返回该错误。
+func (m *Manager) drain(results <-chan result) error {
+	setupLog := ctrl.Log.WithName("lifecycle")
+
+	if m.drainDuration <= 0 {
+		return nil
+	}
+
+	setupLog.Info("Draining before stopping runnables", "duration", m.drainDuration)
+	for _, hook := range m.drainHooks {
+		hook()
+	}
+	for _, r := range m.runnables {
+		if d, ok := r.r.(Drainer); ok {
+			d.Drain()
+		}
+	}
+
+	timer := time.NewTimer(m.drainDuration)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			setupLog.Info("Drain finished, stopping runnables")
+			return nil
+		case res := <-results:
+			if err := m.handleResult(res); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// stop 按添加的逆序关闭组件，每个组件最多等待其关闭超时时间
+func (m *Manager) stop() {
+	setupLog := ctrl.Log.WithName("lifecycle")
+
+	for i := len(m.runnables) - 1; i >= 0; i-- {
+		r := m.runnables[i]
+		r.cancel()
+
+		select {
+		case <-r.done:
+			if r.err != nil {
+				setupLog.Error(r.err, "Runnable stopped with error", "name", r.name)
+			} else {
+				setupLog.Info("Runnable stopped", "name", r.name)
+			}
+		case <-time.After(r.stopTimeout):
+			setupLog.Error(nil, "Timed out waiting for runnable to stop", "name", r.name, "timeout", r.stopTimeout)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// Server 把 http.Server 适配为 Runnable
+type Server struct {
+	name            string
+	server          *http.Server
+	tls             bool
+	shutdownTimeout time.Duration
+	// serving 在开始监听后为 true，关闭后为 false
+	serving atomic.Bool
+}
+
+// NewServer 创建 Server，tls 为 true 时使用 server.TLSConfig 监听 HTTPS
+func NewServer(name string, server *http.Server, tls bool, shutdownTimeout time.Duration) *Server {
+	return &Server{name: name, server: server, tls: tls, shutdownTimeout: shutdownTimeout}
+}
+
+// Drain 关闭 keep-alive：空闲连接被关闭，之后的响应带上 "Connection: close"，
+// 让 kube-apiserver 重新建立连接，从而被 Service 转发到其他副本。排空期间仍然正常处理请求
+func (s *Server) Drain() {
+	ctrl.Log.WithName("lifecycle").Info("Disabling keep-alives", "server", s.name)
+	s.server.SetKeepAlivesEnabled(false)
+}
+
+// Serving 返回服务器是否已经开始监听，依赖服务器的组件（比如注册 webhook 配置）通过它等待服务器启动
+func (s *Server) Serving() bool {
+	return s.serving.Load()
+}
+
+// Start 开始监听，监听失败时返回错误；ctx 结束后关闭服务器并等待正在处理的请求完成
+func (s *Server) Start(ctx context.Context) error {
+	setupLog := ctrl.Log.WithName("lifecycle").WithValues("server", s.name, "addr", s.server.Addr)
+
+	addr := s.server.Addr
+	if addr == "" {
+		addr = ":http"
+		if s.tls {
+			addr = ":https"
+		}
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	s.serving.Store(true)
+	defer s.serving.Store(false)
+
+	errCh := make(chan error, 1)
+	go func() {
+		setupLog.Info("Server listening")
+		var err error
+		if s.tls {
+			err = s.server.ServeTLS(ln, "", "")
+		} else {
+			err = s.server.Serve(ln)
+		}
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return fmt.Errorf("failed to serve: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	if err := s.server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down server: %w", err)
+	}
+	setupLog.Info("Server closed gracefully")
+	return nil
+}
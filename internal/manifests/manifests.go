@@ -0,0 +1,304 @@
+package manifests
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/configs"
+	_ "github.com/aloys.zy/aloys-webhook-example/internal/controller" // 注册所有 webhook
+	"github.com/aloys.zy/aloys-webhook-example/internal/registry"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/yaml"
+)
+
+// header 写在生成文件的开头
+const header = "# Code generated by \"manager gen-manifests\". DO NOT EDIT.\n"
+
+// Options 是生成 webhook 配置的参数
+type Options struct {
+	// All 为 true 时包含所有已注册的 webhook，否则只包含启用的 webhook
+	All bool
+	// ServiceName、ServiceNamespace 和 Port 是 clientConfig 中引用的 Service
+	ServiceName      string
+	ServiceNamespace string
+	Port             int32
+	// CABundle 为空时不设置，由 cert-manager 或自签证书注入
+	CABundle []byte
+	// NamePrefix 加在 webhook 配置名称前面，与 kustomize 的 namePrefix 对应
+	NamePrefix string
+	// Labels 设置到 webhook 配置上
+	Labels map[string]string
+	// Dispatch 为 true 时把 webhook 合并为 mutate 和 validate 两个配置，每个分发组是其中的一个 webhook，
+	// 指向 /mutate 和 /validate 下组的路径；带 MatchConditions 的 webhook 不能分发，仍然单独生成
+	Dispatch bool
+}
+
+// WebhookName 返回 webhook 配置中 webhooks[].name，必须是全限定名
+func WebhookName(d registry.Descriptor) string {
+	return d.Name + ".kb.io"
+}
+
+// ConfigurationName 返回 webhook 配置的名称
+func ConfigurationName(d registry.Descriptor, opts Options) string {
+	return opts.NamePrefix + d.Name
+}
+
+// Build 为每个 webhook 生成一个 MutatingWebhookConfiguration 或 ValidatingWebhookConfiguration，
+// 名称为 NamePrefix 加上 webhook 的名称，按路径排序
+func Build(opts Options) []runtime.Object {
+	var descriptors []registry.Descriptor
+	for _, d := range registry.List() {
+		if opts.All || registry.IsEnabled(d.Name) {
+			descriptors = append(descriptors, d)
+		}
+	}
+	if opts.Dispatch {
+		descriptors = dispatchDescriptors(descriptors)
+	}
+
+	// 分发组合并到每种类型的一个配置中
+	var mutate *admissionregistrationv1.MutatingWebhookConfiguration
+	var validate *admissionregistrationv1.ValidatingWebhookConfiguration
+	var objs []runtime.Object
+	for _, d := range descriptors {
+		grouped := opts.Dispatch && strings.HasPrefix(d.Path, registry.DispatchPath(d.Type)+"/")
+		switch d.Type {
+		case registry.Mutating:
+			c := MutatingWebhookConfiguration(d, opts)
+			if grouped && mutate != nil {
+				mutate.Webhooks = append(mutate.Webhooks, c.Webhooks...)
+				continue
+			}
+			if grouped {
+				c.Name = opts.NamePrefix + strings.TrimPrefix(registry.MutatePath, "/")
+				mutate = c
+			}
+			objs = append(objs, c)
+		case registry.Validating:
+			c := ValidatingWebhookConfiguration(d, opts)
+			if grouped && validate != nil {
+				validate.Webhooks = append(validate.Webhooks, c.Webhooks...)
+				continue
+			}
+			if grouped {
+				c.Name = opts.NamePrefix + strings.TrimPrefix(registry.ValidatePath, "/")
+				validate = c
+			}
+			objs = append(objs, c)
+		}
+	}
+	return objs
+}
+
+// dispatchDescriptors 把可以分发的 webhook 按 registry.DispatchGroups 分组，每个组生成一个指向组路径的描述，规则取并集。
+// 组内的失败策略和 selector 相同，由 API server 按组处理，超时和副作用取最严格的值
+func dispatchDescriptors(descriptors []registry.Descriptor) []registry.Descriptor {
+	included := map[string]registry.Descriptor{}
+	var result []registry.Descriptor
+	for _, d := range descriptors {
+		if !registry.Dispatchable(d) {
+			result = append(result, d)
+			continue
+		}
+		included[d.Name] = d
+	}
+
+	for _, g := range registry.DispatchGroups() {
+		var m *registry.Descriptor
+		for _, name := range g.Webhooks {
+			d, ok := included[name]
+			if !ok {
+				continue
+			}
+			if m == nil {
+				m = &registry.Descriptor{
+					Name:              g.Name,
+					Path:              g.Path,
+					Type:              g.Type,
+					FailurePolicy:     g.FailurePolicy,
+					NamespaceSelector: g.NamespaceSelector,
+					ObjectSelector:    g.ObjectSelector,
+					TimeoutSeconds:    d.TimeoutSeconds,
+					SideEffects:       admissionregistrationv1.SideEffectClassNone,
+				}
+				if g.Type == registry.Mutating {
+					m.ReinvocationPolicy = admissionregistrationv1.NeverReinvocationPolicy
+				}
+			}
+			m.Rules = append(m.Rules, d.Rules...)
+			if d.TimeoutSeconds > m.TimeoutSeconds {
+				m.TimeoutSeconds = d.TimeoutSeconds
+			}
+			if d.SideEffects != admissionregistrationv1.SideEffectClassNone {
+				m.SideEffects = d.SideEffects
+			}
+			if d.ReinvocationPolicy == admissionregistrationv1.IfNeededReinvocationPolicy {
+				m.ReinvocationPolicy = d.ReinvocationPolicy
+			}
+		}
+		if m != nil {
+			result = append(result, *m)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
+// MutatingWebhookConfiguration 根据 webhook 的描述生成 MutatingWebhookConfiguration
+func MutatingWebhookConfiguration(d registry.Descriptor, opts Options) *admissionregistrationv1.MutatingWebhookConfiguration {
+	failurePolicy := d.FailurePolicy
+	sideEffects := d.SideEffects
+	timeoutSeconds := d.TimeoutSeconds
+	reinvocationPolicy := d.ReinvocationPolicy
+
+	config := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: ConfigurationName(d, opts), Labels: opts.Labels},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{{
+			Name:                    WebhookName(d),
+			ClientConfig:            clientConfig(d, opts),
+			Rules:                   d.Rules,
+			FailurePolicy:           &failurePolicy,
+			NamespaceSelector:       d.NamespaceSelector,
+			ObjectSelector:          d.ObjectSelector,
+			SideEffects:             &sideEffects,
+			TimeoutSeconds:          &timeoutSeconds,
+			AdmissionReviewVersions: []string{"v1", "v1beta1"},
+			ReinvocationPolicy:      &reinvocationPolicy,
+			MatchConditions:         d.MatchConditions,
+		}},
+	}
+	config.SetGroupVersionKind(admissionregistrationv1.SchemeGroupVersion.WithKind("MutatingWebhookConfiguration"))
+	return config
+}
+
+// ValidatingWebhookConfiguration 根据 webhook 的描述生成 ValidatingWebhookConfiguration
+func ValidatingWebhookConfiguration(d registry.Descriptor, opts Options) *admissionregistrationv1.ValidatingWebhookConfiguration {
+	failurePolicy := d.FailurePolicy
+	sideEffects := d.SideEffects
+	timeoutSeconds := d.TimeoutSeconds
+
+	config := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: ConfigurationName(d, opts), Labels: opts.Labels},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{{
+			Name:                    WebhookName(d),
+			ClientConfig:            clientConfig(d, opts),
+			Rules:                   d.Rules,
+			FailurePolicy:           &failurePolicy,
+			NamespaceSelector:       d.NamespaceSelector,
+			ObjectSelector:          d.ObjectSelector,
+			SideEffects:             &sideEffects,
+			TimeoutSeconds:          &timeoutSeconds,
+			AdmissionReviewVersions: []string{"v1", "v1beta1"},
+			MatchConditions:         d.MatchConditions,
+		}},
+	}
+	config.SetGroupVersionKind(admissionregistrationv1.SchemeGroupVersion.WithKind("ValidatingWebhookConfiguration"))
+	return config
+}
+
+func clientConfig(d registry.Descriptor, opts Options) admissionregistrationv1.WebhookClientConfig {
+	path := d.Path
+	port := opts.Port
+	return admissionregistrationv1.WebhookClientConfig{
+		Service: &admissionregistrationv1.ServiceReference{
+			Name:      opts.ServiceName,
+			Namespace: opts.ServiceNamespace,
+			Path:      &path,
+			Port:      &port,
+		},
+		CABundle: opts.CABundle,
+	}
+}
+
+// Write 把对象写成多文档 YAML
+func Write(w io.Writer, objs []runtime.Object) error {
+	var buf bytes.Buffer
+	buf.WriteString(header)
+	for _, obj := range objs {
+		data, err := toYAML(obj)
+		if err != nil {
+			return err
+		}
+		buf.WriteString("---\n")
+		buf.Write(data)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// toYAML 序列化对象
+func toYAML(obj runtime.Object) ([]byte, error) {
+	m, err := toMap(obj)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(m)
+}
+
+// toMap 把对象转换为 map，去掉没有意义的 metadata.creationTimestamp: null
+func toMap(obj runtime.Object) (map[string]interface{}, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if metadata, ok := m["metadata"].(map[string]interface{}); ok {
+		delete(metadata, "creationTimestamp")
+	}
+	return m, nil
+}
+
+// Run 执行 gen-manifests 子命令，args 不包含子命令名称
+func Run(args []string) error {
+	ctrl.SetLogger(zap.New(zap.WriteTo(os.Stderr)))
+
+	fs := flag.NewFlagSet("gen-manifests", flag.ContinueOnError)
+	var (
+		opts    Options
+		enabled configs.StringSlice
+		output  string
+		port    int
+	)
+	fs.BoolVar(&opts.All, "all", false, "Include every registered webhook, not only the enabled ones.")
+	fs.Var(&enabled, "enabled-webhooks", "Comma-separated list of webhook names to include, same as the manager flag. Empty includes the webhooks enabled by default.")
+	fs.StringVar(&opts.ServiceName, "service-name", "webhook-service", "Name of the Service referenced by clientConfig.")
+	fs.StringVar(&opts.ServiceNamespace, "service-namespace", "system", "Namespace of the Service referenced by clientConfig.")
+	fs.IntVar(&port, "port", 9443, "Port of the Service referenced by clientConfig.")
+	fs.BoolVar(&opts.Dispatch, "dispatch", false, "Merge the webhooks into one mutating and one validating configuration dispatched under /mutate and /validate, with one webhook per group of webhooks sharing failure policy and selectors.")
+	fs.StringVar(&output, "output", "", "File to write the manifests to. Empty writes to stdout.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	opts.Port = int32(port)
+
+	if err := registry.SetEnabled(enabled); err != nil {
+		return err
+	}
+
+	w := io.Writer(os.Stdout)
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := Write(w, Build(opts)); err != nil {
+		return fmt.Errorf("failed to write manifests: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package manifests
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/registry"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+)
+
+// TestManifestsUpToDate 检查 config/webhook/manifests.yaml 与注册的 webhook 一致，不一致时执行 make webhook-manifests
+func TestManifestsUpToDate(t *testing.T) {
+	if err := registry.SetEnabled(nil); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, Build(Options{ServiceName: "webhook-service", ServiceNamespace: "system", Port: 9443})); err != nil {
+		t.Fatal(err)
+	}
+
+	checkedIn, err := os.ReadFile("../../config/webhook/manifests.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), checkedIn) {
+		t.Errorf("config/webhook/manifests.yaml is out of date, run make webhook-manifests")
+	}
+}
+
+// TestBuildDispatch 检查分发模式下 selector 和失败策略不同的 webhook 生成不同的 webhook 项
+func TestBuildDispatch(t *testing.T) {
+	if err := registry.SetEnabled(nil); err != nil {
+		t.Fatal(err)
+	}
+	objs := Build(Options{ServiceName: "webhook-service", ServiceNamespace: "system", Port: 9443, Dispatch: true})
+
+	var mutate *admissionregistrationv1.MutatingWebhookConfiguration
+	var validate *admissionregistrationv1.ValidatingWebhookConfiguration
+	for _, obj := range objs {
+		switch c := obj.(type) {
+		case *admissionregistrationv1.MutatingWebhookConfiguration:
+			if c.Name != "mutate" {
+				t.Errorf("unexpected MutatingWebhookConfiguration %s", c.Name)
+			}
+			mutate = c
+		case *admissionregistrationv1.ValidatingWebhookConfiguration:
+			if c.Name != "validating-node-oversell" {
+				t.Errorf("unexpected ValidatingWebhookConfiguration %s", c.Name)
+			}
+			validate = c
+		}
+	}
+	if mutate == nil || validate == nil {
+		t.Fatalf("expected mutate and validating-node-oversell configurations, got %d objects", len(objs))
+	}
+
+	// mutating-pod-dns 排除了带 exclude-webhook-podDns 标签的 namespace，不能与 mutating-cpu-oversell 合并
+	if len(mutate.Webhooks) != 2 {
+		t.Fatalf("expected 2 mutating webhooks, got %d", len(mutate.Webhooks))
+	}
+	var withSelector int
+	for _, w := range mutate.Webhooks {
+		if *w.FailurePolicy != admissionregistrationv1.Fail {
+			t.Errorf("webhook %s failurePolicy = %s, want Fail", w.Name, *w.FailurePolicy)
+		}
+		if !strings.HasPrefix(*w.ClientConfig.Service.Path, registry.MutatePath+"/") {
+			t.Errorf("webhook %s path = %s, want a group path under %s", w.Name, *w.ClientConfig.Service.Path, registry.MutatePath)
+		}
+		if w.NamespaceSelector != nil {
+			withSelector++
+			if w.NamespaceSelector.MatchExpressions[0].Key != "exclude-webhook-podDns" {
+				t.Errorf("webhook %s namespaceSelector = %v", w.Name, w.NamespaceSelector)
+			}
+		}
+	}
+	if withSelector != 1 {
+		t.Errorf("expected one mutating webhook with a namespaceSelector, got %d", withSelector)
+	}
+
+	// validating-node-oversell 带 MatchConditions，不能分发，仍然使用自己的路径
+	if len(validate.Webhooks) != 1 || *validate.Webhooks[0].ClientConfig.Service.Path != "/validating-node-oversell" {
+		t.Errorf("expected one validating webhook with its own path, got %+v", validate.Webhooks)
+	}
+}
@@ -0,0 +1,288 @@
+package manifests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/configs"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// FieldManager 是 server-side apply 使用的字段管理者，只接管 webhook 配置中本服务生成的字段，
+	// 与 GitOps 工具或 cert-manager 管理的其他字段（比如 caBundle、额外的标签）互不覆盖。
+	// 默认不强制接管冲突的字段，冲突时注册失败并记录冲突的字段管理者
+	FieldManager = "aloys-webhook-manager"
+
+	// managedByLabel 标记由本服务自动注册的 webhook 配置，清理时只删除带这个标签的对象
+	managedByLabel = "app.kubernetes.io/managed-by"
+
+	// readyPollInterval 是注册前检查 webhook 服务是否就绪的间隔
+	readyPollInterval = time.Second
+	// readyCheckTimeout 是一次就绪检查的超时时间
+	readyCheckTimeout = 10 * time.Second
+)
+
+// Registrar 把启用的 webhook 通过 server-side apply 注册到集群，并删除已禁用的 webhook 配置。
+// 定期重新同步，使 webhook 启用状态和 CA 证书的变化同步到集群。
+type Registrar struct {
+	client   kubernetes.Interface
+	opts     Options
+	caBundle func() ([]byte, error)
+	interval time.Duration
+	// unregisterOnStop 为 true 时退出前删除所有注册的 webhook 配置
+	unregisterOnStop bool
+	// force 为 true 时 server-side apply 接管其他字段管理者（比如 Argo CD、Flux）设置的冲突字段
+	force bool
+	// ready 返回 webhook 服务是否已经可以处理请求，Start 等它返回 nil 后才注册
+	ready func(ctx context.Context) error
+}
+
+// NewRegistrar 根据配置创建 Registrar，caBundle 返回当前的 CA 证书，返回空时不设置 caBundle 字段
+func NewRegistrar(client kubernetes.Interface, cfg *configs.Config, caBundle func() ([]byte, error)) *Registrar {
+	return &Registrar{
+		client: client,
+		opts: Options{
+			ServiceName:      cfg.WebhookServiceName,
+			ServiceNamespace: cfg.WebhookServiceNamespace,
+			Port:             int32(cfg.WebhookServicePort),
+			NamePrefix:       cfg.WebhookConfigNamePrefix,
+			Labels:           map[string]string{managedByLabel: FieldManager},
+			Dispatch:         cfg.WebhookDispatch,
+		},
+		caBundle:         caBundle,
+		interval:         cfg.WebhookRegisterInterval,
+		unregisterOnStop: cfg.WebhookUnregisterOnStop,
+		force:            cfg.WebhookRegisterForce,
+	}
+}
+
+// WaitFor 设置 Start 注册前等待的就绪检查。failurePolicy 为 Fail 的 webhook 在服务就绪前注册会拒绝所有匹配的请求，
+// 所以注册要等 webhook 服务开始监听并且就绪检查（证书、缓存同步等）通过
+func (r *Registrar) WaitFor(ready func(ctx context.Context) error) {
+	r.ready = ready
+}
+
+// CABundleFromFile 返回从文件读取 CA 证书的函数，file 为空时不设置 caBundle
+func CABundleFromFile(file string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		if file == "" {
+			return nil, nil
+		}
+		return os.ReadFile(file)
+	}
+}
+
+// Register 注册所有启用的 webhook，并删除之前注册过、现在已禁用的 webhook 配置
+func (r *Registrar) Register(ctx context.Context) error {
+	setupLog := ctrl.Log.WithName("webhook-registrar")
+
+	opts := r.opts
+	caBundle, err := r.caBundle()
+	if err != nil {
+		return fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	opts.CABundle = caBundle
+
+	desired := sets.New[string]()
+	for _, obj := range Build(opts) {
+		name, err := r.apply(ctx, obj)
+		if err != nil {
+			return err
+		}
+		desired.Insert(name)
+	}
+
+	removed, err := r.deleteManaged(ctx, func(name string) bool { return !desired.Has(name) })
+	if err != nil {
+		return err
+	}
+
+	setupLog.Info("Webhook configurations registered",
+		"registered", sets.List(desired), "removed", removed, "caBundle", len(caBundle) > 0)
+	return nil
+}
+
+// Unregister 删除所有由本服务注册的 webhook 配置
+func (r *Registrar) Unregister(ctx context.Context) error {
+	removed, err := r.deleteManaged(ctx, func(string) bool { return true })
+	if err != nil {
+		return err
+	}
+	ctrl.Log.WithName("webhook-registrar").Info("Webhook configurations unregistered", "removed", removed)
+	return nil
+}
+
+// Start 等 webhook 服务就绪后注册，之后按 interval 重新注册，直到 ctx 结束；
+// 开启 unregisterOnStop 时退出前删除注册的 webhook 配置
+func (r *Registrar) Start(ctx context.Context) error {
+	setupLog := ctrl.Log.WithName("webhook-registrar")
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	if r.waitReady(ctx) {
+		if err := r.Register(ctx); err != nil {
+			setupLog.Error(err, "Failed to register webhook configurations, will retry")
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if !r.unregisterOnStop {
+				return nil
+			}
+			// ctx 已经结束，使用新的 ctx 完成清理
+			stopCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			return r.Unregister(stopCtx)
+		case <-ticker.C:
+			if err := r.Register(ctx); err != nil {
+				setupLog.Error(err, "Failed to register webhook configurations, will retry")
+			}
+		}
+	}
+}
+
+// waitReady 等待 ready 返回 nil，ctx 结束时返回 false
+func (r *Registrar) waitReady(ctx context.Context) bool {
+	setupLog := ctrl.Log.WithName("webhook-registrar")
+	if r.ready == nil {
+		return true
+	}
+
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+	logged := false
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, readyCheckTimeout)
+		err := r.ready(checkCtx)
+		cancel()
+		if err == nil {
+			return true
+		}
+		if !logged {
+			setupLog.Info("Waiting for the webhook server to become ready before registering", "reason", err.Error())
+			logged = true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// apply 通过 server-side apply 创建或更新 webhook 配置，返回配置名称
+func (r *Registrar) apply(ctx context.Context, obj runtime.Object) (string, error) {
+	m, err := toMap(obj)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return "", err
+	}
+	name := accessor.GetName()
+
+	patchOptions := metav1.PatchOptions{FieldManager: FieldManager}
+	if r.force {
+		patchOptions.Force = &r.force
+	}
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	switch kind {
+	case "MutatingWebhookConfiguration":
+		_, err = r.client.AdmissionregistrationV1().MutatingWebhookConfigurations().
+			Patch(ctx, name, types.ApplyPatchType, data, patchOptions)
+	case "ValidatingWebhookConfiguration":
+		_, err = r.client.AdmissionregistrationV1().ValidatingWebhookConfigurations().
+			Patch(ctx, name, types.ApplyPatchType, data, patchOptions)
+	default:
+		err = fmt.Errorf("unexpected kind %q", kind)
+	}
+	if apierrors.IsConflict(err) {
+		return "", fmt.Errorf("failed to apply %s %s, fields are managed by %v, "+
+			"remove them from the other manager or set --webhook-register-force to take them over: %w",
+			kind, name, conflictingManagers(err), err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to apply %s %s: %w", kind, name, err)
+	}
+	return name, nil
+}
+
+// conflictPattern 匹配 server-side apply 冲突原因中的字段管理者，比如 conflict with "argocd-controller" using admissionregistration.k8s.io/v1
+var conflictPattern = regexp.MustCompile(`conflict with "([^"]+)"`)
+
+// conflictingManagers 返回 server-side apply 冲突错误中的字段管理者
+func conflictingManagers(err error) []string {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) || status.Status().Details == nil {
+		return nil
+	}
+	managers := sets.New[string]()
+	for _, c := range status.Status().Details.Causes {
+		if m := conflictPattern.FindStringSubmatch(c.Message); m != nil {
+			managers.Insert(m[1])
+		}
+	}
+	return sets.List(managers)
+}
+
+// deleteManaged 删除带本服务标签且 shouldDelete 返回 true 的 webhook 配置，返回删除的名称
+func (r *Registrar) deleteManaged(ctx context.Context, shouldDelete func(name string) bool) ([]string, error) {
+	listOptions := metav1.ListOptions{LabelSelector: managedByLabel + "=" + FieldManager}
+	var removed []string
+	var errs []error
+
+	mutating, err := r.client.AdmissionregistrationV1().MutatingWebhookConfigurations().List(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mutating webhook configurations: %w", err)
+	}
+	for _, c := range mutating.Items {
+		if !shouldDelete(c.Name) {
+			continue
+		}
+		err := r.client.AdmissionregistrationV1().MutatingWebhookConfigurations().Delete(ctx, c.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to delete MutatingWebhookConfiguration %s: %w", c.Name, err))
+			continue
+		}
+		removed = append(removed, c.Name)
+	}
+
+	validating, err := r.client.AdmissionregistrationV1().ValidatingWebhookConfigurations().List(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list validating webhook configurations: %w", err)
+	}
+	for _, c := range validating.Items {
+		if !shouldDelete(c.Name) {
+			continue
+		}
+		err := r.client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Delete(ctx, c.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to delete ValidatingWebhookConfiguration %s: %w", c.Name, err))
+			continue
+		}
+		removed = append(removed, c.Name)
+	}
+
+	return removed, errors.Join(errs...)
+}
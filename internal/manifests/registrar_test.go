@@ -0,0 +1,135 @@
+package manifests
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/configs"
+	"github.com/aloys.zy/aloys-webhook-example/internal/registry"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeApplyClient 返回记录 apply 请求的 fake clientset，conflict 不为空时 apply 返回字段冲突
+func fakeApplyClient(conflict []string, applied *[]metav1.PatchOptions) *fake.Clientset {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		opts := action.(k8stesting.PatchActionImpl).PatchOptions
+		*applied = append(*applied, opts)
+		if len(conflict) == 0 || (opts.Force != nil && *opts.Force) {
+			return true, nil, nil
+		}
+		var causes []metav1.StatusCause
+		for _, manager := range conflict {
+			causes = append(causes, metav1.StatusCause{
+				Type:    metav1.CauseTypeFieldManagerConflict,
+				Message: `conflict with "` + manager + `" using admissionregistration.k8s.io/v1`,
+				Field:   ".webhooks",
+			})
+		}
+		return true, nil, apierrors.NewApplyConflict(causes, "Apply failed with conflicts")
+	})
+	return client
+}
+
+func TestRegistrarRegister(t *testing.T) {
+	if err := registry.SetEnabled(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name         string
+		force        bool
+		conflict     []string
+		wantErr      bool
+		wantManagers []string
+	}{
+		{name: "no conflict"},
+		{name: "conflict", conflict: []string{"kubectl-client-side-apply", "argocd-controller"}, wantErr: true,
+			wantManagers: []string{"argocd-controller", "kubectl-client-side-apply"}},
+		{name: "conflict with force", force: true, conflict: []string{"argocd-controller"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var applied []metav1.PatchOptions
+			client := fakeApplyClient(tc.conflict, &applied)
+			r := NewRegistrar(client, &configs.Config{WebhookRegisterForce: tc.force}, CABundleFromFile(""))
+
+			err := r.Register(context.Background())
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Register() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if err != nil {
+				if got := conflictingManagers(errors.Unwrap(err)); strings.Join(got, ",") != strings.Join(tc.wantManagers, ",") {
+					t.Errorf("conflicting managers = %v, want %v", got, tc.wantManagers)
+				}
+				for _, manager := range tc.wantManagers {
+					if !strings.Contains(err.Error(), manager) {
+						t.Errorf("Register() error = %v, want it to name %s", err, manager)
+					}
+				}
+			}
+			if len(applied) == 0 {
+				t.Fatal("no webhook configuration applied")
+			}
+			for _, opts := range applied {
+				if opts.FieldManager != FieldManager {
+					t.Errorf("FieldManager = %q, want %q", opts.FieldManager, FieldManager)
+				}
+				if force := opts.Force != nil && *opts.Force; force != tc.force {
+					t.Errorf("Force = %v, want %v", force, tc.force)
+				}
+			}
+		})
+	}
+}
+
+// TestRegistrarStartWaitsForReady 检查 webhook 服务就绪之前不注册
+func TestRegistrarStartWaitsForReady(t *testing.T) {
+	if err := registry.SetEnabled(nil); err != nil {
+		t.Fatal(err)
+	}
+	var applied []metav1.PatchOptions
+	client := fakeApplyClient(nil, &applied)
+	r := NewRegistrar(client, &configs.Config{WebhookRegisterInterval: time.Hour}, CABundleFromFile(""))
+
+	checked := make(chan struct{}, 10)
+	ready := make(chan struct{})
+	r.WaitFor(func(context.Context) error {
+		checked <- struct{}{}
+		select {
+		case <-ready:
+			return nil
+		default:
+			return errors.New("webhook server is not serving yet")
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Start(ctx) }()
+
+	<-checked
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Fatalf("actions before ready = %v, want none", actions)
+	}
+	close(ready)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(client.Actions()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("webhook configurations not registered after ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Start() error = %v", err)
+	}
+}
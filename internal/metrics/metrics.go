@@ -28,8 +28,151 @@ var (
 		},
 		[]string{"path"},
 	)
+	inflightGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "webhook_inflight_requests",
+			Help: "Number of webhook requests currently being served.",
+		},
+		[]string{"path"},
+	)
+	queuedGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "webhook_queued_requests",
+			Help: "Number of webhook requests waiting for a free concurrency slot.",
+		},
+		[]string{"path"},
+	)
+	shedCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_requests_shed_total",
+			Help: "Total number of webhook requests answered without admission because the endpoint was overloaded.",
+		},
+		[]string{"path", "reason", "policy"},
+	)
+	disabledCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_disabled_requests_total",
+			Help: "Total number of requests to registered webhooks that are currently disabled.",
+		},
+		[]string{"path"},
+	)
+	enabledGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "webhook_enabled",
+			Help: "Whether a registered webhook is currently served (1) or disabled (0).",
+		},
+		[]string{"name"},
+	)
+	certReloadCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_certificate_reloads_total",
+			Help: "Total number of serving certificate reloads, partitioned by result.",
+		},
+		[]string{"result"},
+	)
+	certExpiryGauge = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "webhook_certificate_expiry_timestamp_seconds",
+			Help: "NotAfter of the serving certificate currently in use, as a Unix timestamp.",
+		},
+	)
+	handlerTimeoutCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_handler_timeouts_total",
+			Help: "Total number of admission handlers that ran out of their time budget and were answered with the fallback response.",
+		},
+		[]string{"webhook", "fallback"},
+	)
+	handlerErrorCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_handler_errors_total",
+			Help: "Total number of admission handlers that failed with an internal error or panic and were answered according to their error policy.",
+		},
+		[]string{"webhook", "reason", "policy"},
+	)
+	mutatorCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_mutator_runs_total",
+			Help: "Total number of mutator runs in a mutating pipeline, partitioned by result (changed, unchanged, skipped, failed, denied).",
+		},
+		[]string{"webhook", "mutator", "result"},
+	)
+	mutatorDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "webhook_mutator_duration_seconds",
+			Help:    "Duration of mutator runs in a mutating pipeline in seconds.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 2, 12), // 从0.1ms开始，以2为基数，共12个桶
+		},
+		[]string{"webhook", "mutator"},
+	)
+	authRejectionCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_auth_rejections_total",
+			Help: "Total number of webhook requests rejected because the caller could not be authenticated or is not allowed.",
+		},
+		[]string{"path", "reason"},
+	)
 )
 
+// RecordCertReload 记录一次证书加载的结果
+func RecordCertReload(success bool) {
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	certReloadCounter.WithLabelValues(result).Inc()
+}
+
+// SetCertExpiry 记录当前证书的过期时间
+func SetCertExpiry(notAfter time.Time) {
+	certExpiryGauge.Set(float64(notAfter.Unix()))
+}
+
+// RecordAuthRejection 记录一次调用方身份校验失败
+func RecordAuthRejection(path, reason string) {
+	authRejectionCounter.WithLabelValues(path, reason).Inc()
+}
+
+// AddQueued 调整等待并发槽位的请求数
+func AddQueued(path string, delta float64) {
+	queuedGauge.WithLabelValues(path).Add(delta)
+}
+
+// RecordShed 记录一次因过载被快速返回的请求，policy 表示按哪种失败策略返回
+func RecordShed(path, reason, policy string) {
+	shedCounter.WithLabelValues(path, reason, policy).Inc()
+}
+
+// RecordDisabledRequest 记录一次对已禁用 webhook 的请求
+func RecordDisabledRequest(path string) {
+	disabledCounter.WithLabelValues(path).Inc()
+}
+
+// SetWebhookEnabled 记录 webhook 当前是否提供服务
+func SetWebhookEnabled(name string, enabled bool) {
+	value := 0.0
+	if enabled {
+		value = 1
+	}
+	enabledGauge.WithLabelValues(name).Set(value)
+}
+
+// RecordHandlerTimeout 记录一次处理函数超时，fallback 是返回的响应
+func RecordHandlerTimeout(webhook, fallback string) {
+	handlerTimeoutCounter.WithLabelValues(webhook, fallback).Inc()
+}
+
+// RecordHandlerError 记录一次处理函数的内部错误或 panic，reason 是 error、panic 或 panic_after_timeout（返回 fallback 响应之后的 panic），policy 是返回的响应
+func RecordHandlerError(webhook, reason, policy string) {
+	handlerErrorCounter.WithLabelValues(webhook, reason, policy).Inc()
+}
+
+// RecordMutator 记录流水线中一个 mutator 的执行结果和耗时
+func RecordMutator(webhook, mutator, result string, duration time.Duration) {
+	mutatorCounter.WithLabelValues(webhook, mutator, result).Inc()
+	mutatorDuration.WithLabelValues(webhook, mutator).Observe(duration.Seconds())
+}
+
 // 自定义ResponseWriter以捕获状态码
 type responseCaptureWriter struct {
 	http.ResponseWriter
@@ -50,6 +193,11 @@ func WithMetrics(next http.HandlerFunc) http.HandlerFunc {
 		start := time.Now()
 		rcw := &responseCaptureWriter{ResponseWriter: w, statusCode: http.StatusOK}
 
+		// 正在处理的请求数，关闭时会等待这些请求完成
+		inflight := inflightGauge.WithLabelValues(path)
+		inflight.Inc()
+		defer inflight.Dec()
+
 		// 记录请求的基本信息
 		setupLog.V(1).Info("Received incoming request",
 			"method", req.Method,
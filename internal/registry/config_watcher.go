@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// FileConfig 是 webhook 配置文件的内容，一般来自挂载的 ConfigMap，例如：
+//
+//	enabledWebhooks:
+//	- mutating-cpu-oversell
+//	- mutating-pod-dns
+type FileConfig struct {
+	// EnabledWebhooks 是提供服务的 webhook 名称，"*" 表示全部，为空时使用注册时的默认值
+	EnabledWebhooks []string `json:"enabledWebhooks"`
+}
+
+// ConfigWatcher 轮询 webhook 配置文件，文件变化后重新设置启用的 webhook，无需重启。
+// 文件不存在或内容无效时保留当前设置。
+type ConfigWatcher struct {
+	file     string
+	interval time.Duration
+	modTime  time.Time
+}
+
+// NewConfigWatcher 创建 ConfigWatcher
+func NewConfigWatcher(file string, interval time.Duration) *ConfigWatcher {
+	return &ConfigWatcher{file: file, interval: interval}
+}
+
+// Load 读取配置文件并更新启用的 webhook
+func (w *ConfigWatcher) Load() error {
+	info, err := os.Stat(w.file)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(w.file)
+	if err != nil {
+		return err
+	}
+	var config FileConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", w.file, err)
+	}
+	if err := SetEnabled(config.EnabledWebhooks); err != nil {
+		return fmt.Errorf("invalid %s: %w", w.file, err)
+	}
+	w.modTime = info.ModTime()
+	return nil
+}
+
+// Start 按 interval 轮询文件的修改时间，直到 ctx 结束
+func (w *ConfigWatcher) Start(ctx context.Context) error {
+	setupLog := ctrl.Log.WithName("webhook-config-watcher")
+	setupLog.Info("Starting webhook config watcher", "file", w.file, "interval", w.interval)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			setupLog.Info("Stopping webhook config watcher")
+			return nil
+		case <-ticker.C:
+			info, err := os.Stat(w.file)
+			if err != nil || info.ModTime().Equal(w.modTime) {
+				continue
+			}
+			if err := w.Load(); err != nil {
+				setupLog.Error(err, "Failed to reload webhook config, keep the current enabled webhooks", "file", w.file)
+				// 记录修改时间，避免同一个无效文件每个周期都报错
+				w.modTime = info.ModTime()
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/metrics"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// AllWebhooks 出现在启用列表中时表示启用所有已注册的 webhook
+const AllWebhooks = "*"
+
+// enabled 保存当前提供服务的 webhook 名称，为 nil 时使用注册时的默认值
+var enabled atomic.Pointer[map[string]struct{}]
+
+// SetEnabled 设置提供服务的 webhook。names 为空时使用注册时的默认值（DisabledByDefault 为 false 的 webhook），
+// 包含 "*" 时启用全部。包含未注册的名称时返回错误，不修改当前设置。
+func SetEnabled(names []string) error {
+	setupLog := ctrl.Log.WithName("registry")
+
+	set := make(map[string]struct{})
+	for _, name := range names {
+		if name == AllWebhooks {
+			for _, d := range List() {
+				set[d.Name] = struct{}{}
+			}
+			continue
+		}
+		if _, ok := Get(name); !ok {
+			return fmt.Errorf("webhook %q is not registered", name)
+		}
+		set[name] = struct{}{}
+	}
+	if len(names) == 0 {
+		for _, d := range List() {
+			if !d.DisabledByDefault {
+				set[d.Name] = struct{}{}
+			}
+		}
+	}
+
+	enabled.Store(&set)
+
+	var active []string
+	for _, d := range List() {
+		_, ok := set[d.Name]
+		metrics.SetWebhookEnabled(d.Name, ok)
+		if ok {
+			active = append(active, d.Name)
+		}
+	}
+	sort.Strings(active)
+	setupLog.Info("Updated enabled webhooks", "enabled", active)
+	return nil
+}
+
+// IsEnabled 判断 webhook 当前是否提供服务
+func IsEnabled(name string) bool {
+	set := enabled.Load()
+	if set == nil {
+		d, ok := Get(name)
+		return ok && !d.DisabledByDefault
+	}
+	_, ok := (*set)[name]
+	return ok
+}
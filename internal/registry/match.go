@@ -0,0 +1,283 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// 通用的分发路径，API server 把请求发到这里后按资源、子资源和操作分发给匹配的 webhook
+const (
+	MutatePath   = "/mutate"
+	ValidatePath = "/validate"
+)
+
+// DispatchPath 返回这种类型的 webhook 使用的分发路径
+func DispatchPath(typ Type) string {
+	if typ == Mutating {
+		return MutatePath
+	}
+	return ValidatePath
+}
+
+// DispatchGroup 是合并为一个 webhook 配置项的 webhook，它们的类型、失败策略、NamespaceSelector 和 ObjectSelector 相同。
+// API server 按组的 selector 和失败策略把请求发到组的路径，分发时只调用组内匹配的 webhook，
+// 这样排除了某些 namespace 的 webhook 不会因为合并而收到这些 namespace 的请求，失败策略也不会被其他 webhook 改变。
+type DispatchGroup struct {
+	// Name 是组的名称，也是生成的 webhooks[].name 的前缀，比如 mutate-fail
+	Name string
+	// Path 是分发路径下组的路径，比如 /mutate/fail，带 selector 的组加上 selector 的 hash，比如 /mutate/fail-1a2b3c4d
+	Path              string
+	Type              Type
+	FailurePolicy     admissionregistrationv1.FailurePolicyType
+	NamespaceSelector *metav1.LabelSelector
+	ObjectSelector    *metav1.LabelSelector
+	// Webhooks 是组内 webhook 的名称，按路径排序
+	Webhooks []string
+}
+
+// DispatchGroups 把所有已注册、可以分发的 webhook 按类型、失败策略和 selector 分组，按路径排序。
+// 分组包含禁用的 webhook，启用和禁用 webhook 不会改变组的路径
+func DispatchGroups() []DispatchGroup {
+	groups := map[string]*DispatchGroup{}
+	for _, d := range List() {
+		if !Dispatchable(d) {
+			continue
+		}
+		path := dispatchGroupPath(d)
+		g, ok := groups[path]
+		if !ok {
+			g = &DispatchGroup{
+				Name:              strings.ReplaceAll(strings.TrimPrefix(path, "/"), "/", "-"),
+				Path:              path,
+				Type:              d.Type,
+				FailurePolicy:     d.FailurePolicy,
+				NamespaceSelector: d.NamespaceSelector,
+				ObjectSelector:    d.ObjectSelector,
+			}
+			groups[path] = g
+		}
+		g.Webhooks = append(g.Webhooks, d.Name)
+	}
+
+	result := make([]DispatchGroup, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, *g)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
+// dispatchGroupPath 返回 webhook 所在组的路径，selector 相同的 webhook 得到相同的 hash
+func dispatchGroupPath(d Descriptor) string {
+	path := DispatchPath(d.Type) + "/" + strings.ToLower(string(d.FailurePolicy))
+	if isEmptySelector(d.NamespaceSelector) && isEmptySelector(d.ObjectSelector) {
+		return path
+	}
+	h := fnv.New32a()
+	for _, selector := range []*metav1.LabelSelector{d.NamespaceSelector, d.ObjectSelector} {
+		if isEmptySelector(selector) {
+			selector = nil
+		}
+		// LabelSelector 的 JSON 中 map 按 key 排序，相同的 selector 得到相同的结果
+		data, _ := json.Marshal(selector)
+		h.Write(data)
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%s-%08x", path, h.Sum32())
+}
+
+// NamespaceLabelsFunc 返回 namespace 的标签，用于匹配 NamespaceSelector
+type NamespaceLabelsFunc func(ctx context.Context, namespace string) (map[string]string, error)
+
+// Dispatchable 判断 webhook 能否通过分发路径提供服务。
+// MatchConditions 是 CEL 表达式，只能由 API server 计算，这样的 webhook 只能使用自己的路径。
+func Dispatchable(d Descriptor) bool {
+	return len(d.MatchConditions) == 0
+}
+
+// Match 返回应该处理这个请求的已启用 webhook，按路径排序。
+// 匹配规则与 API server 相同：rules 匹配资源、子资源、操作和 scope，ObjectSelector 匹配对象或旧对象的标签，
+// NamespaceSelector 匹配请求所在 namespace 的标签，集群级别的资源总是匹配。
+func Match(ctx context.Context, typ Type, req *admissionv1.AdmissionRequest, namespaceLabels NamespaceLabelsFunc) ([]Descriptor, error) {
+	return match(ctx, typ, func(Descriptor) bool { return true }, req, namespaceLabels)
+}
+
+// MatchGroup 与 Match 相同，只返回组内的 webhook
+func MatchGroup(ctx context.Context, g DispatchGroup, req *admissionv1.AdmissionRequest, namespaceLabels NamespaceLabelsFunc) ([]Descriptor, error) {
+	return match(ctx, g.Type, func(d Descriptor) bool { return slices.Contains(g.Webhooks, d.Name) }, req, namespaceLabels)
+}
+
+func match(ctx context.Context, typ Type, in func(Descriptor) bool, req *admissionv1.AdmissionRequest, namespaceLabels NamespaceLabelsFunc) ([]Descriptor, error) {
+	var matched []Descriptor
+	// 同一个请求的 namespace 标签只查询一次
+	var nsLabels labels.Set
+	for _, d := range List() {
+		if d.Type != typ || !Dispatchable(d) || !in(d) || !IsEnabled(d.Name) || !matchRules(d.Rules, req) {
+			continue
+		}
+		ok, err := matchObjectSelector(d.ObjectSelector, req)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: %w", d.Name, err)
+		}
+		if !ok {
+			continue
+		}
+		if req.Namespace != "" && !isEmptySelector(d.NamespaceSelector) && nsLabels == nil {
+			if namespaceLabels == nil {
+				return nil, fmt.Errorf("webhook %s: namespaceSelector requires namespace labels", d.Name)
+			}
+			l, err := namespaceLabels(ctx, req.Namespace)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get labels of namespace %s: %w", req.Namespace, err)
+			}
+			nsLabels = labels.Set{}
+			for k, v := range l {
+				nsLabels[k] = v
+			}
+		}
+		ok, err = matchNamespaceSelector(d.NamespaceSelector, req, nsLabels)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: %w", d.Name, err)
+		}
+		if ok {
+			matched = append(matched, d)
+		}
+	}
+	return matched, nil
+}
+
+// matchRules 判断请求是否匹配任意一条规则
+func matchRules(rules []admissionregistrationv1.RuleWithOperations, req *admissionv1.AdmissionRequest) bool {
+	for _, r := range rules {
+		if matchOperation(r.Operations, req.Operation) &&
+			matchString(r.APIGroups, req.Resource.Group) &&
+			matchString(r.APIVersions, req.Resource.Version) &&
+			matchResource(r.Resources, req.Resource.Resource, req.SubResource) &&
+			matchScope(r.Scope, req) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchScope 与 API server 一致：namespace 对象本身是集群级别的资源，它的请求中 Namespace 是自己的名称
+func matchScope(scope *admissionregistrationv1.ScopeType, req *admissionv1.AdmissionRequest) bool {
+	if scope == nil {
+		return true
+	}
+	isNamespace := req.Resource.Group == "" && req.Resource.Resource == "namespaces"
+	switch *scope {
+	case admissionregistrationv1.NamespacedScope:
+		return !isNamespace && req.Namespace != ""
+	case admissionregistrationv1.ClusterScope:
+		return isNamespace || req.Namespace == ""
+	default:
+		return true
+	}
+}
+
+func matchOperation(ops []admissionregistrationv1.OperationType, op admissionv1.Operation) bool {
+	for _, o := range ops {
+		if o == admissionregistrationv1.OperationAll || string(o) == string(op) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchString(values []string, value string) bool {
+	for _, v := range values {
+		if v == "*" || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matchResource 匹配资源和子资源，与 API server 一致："pods" 只匹配主资源，"pods/status" 匹配子资源，
+// "*" 匹配所有主资源，"pods/*" 匹配 pods 和它的所有子资源，"*/*" 匹配所有资源和子资源
+func matchResource(resources []string, resource, subResource string) bool {
+	for _, r := range resources {
+		res, sub, _ := strings.Cut(r, "/")
+		if (res == "*" || res == resource) && (sub == "*" || sub == subResource) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchObjectSelector 对象或旧对象的标签匹配即可，与 API server 一致
+func matchObjectSelector(selector *metav1.LabelSelector, req *admissionv1.AdmissionRequest) (bool, error) {
+	if isEmptySelector(selector) {
+		return true, nil
+	}
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false, err
+	}
+	for _, raw := range [][]byte{req.Object.Raw, req.OldObject.Raw} {
+		l, err := objectLabels(raw)
+		if err != nil {
+			return false, err
+		}
+		if l != nil && s.Matches(l) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// matchNamespaceSelector 集群级别的资源总是匹配，namespace 对象本身使用自己的标签
+func matchNamespaceSelector(selector *metav1.LabelSelector, req *admissionv1.AdmissionRequest, nsLabels labels.Set) (bool, error) {
+	if isEmptySelector(selector) {
+		return true, nil
+	}
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false, err
+	}
+	if req.Namespace != "" {
+		return s.Matches(nsLabels), nil
+	}
+	if req.Resource.Group != "" || req.Resource.Resource != "namespaces" {
+		return true, nil
+	}
+	raw := req.Object.Raw
+	if req.Operation == admissionv1.Delete {
+		raw = req.OldObject.Raw
+	}
+	l, err := objectLabels(raw)
+	if err != nil {
+		return false, err
+	}
+	return s.Matches(l), nil
+}
+
+func isEmptySelector(selector *metav1.LabelSelector) bool {
+	return selector == nil || (len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0)
+}
+
+// objectLabels 从原始 JSON 中读取标签，raw 为空时返回 nil
+func objectLabels(raw []byte) (labels.Set, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var obj metav1.PartialObjectMetadata
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, fmt.Errorf("failed to decode object metadata: %w", err)
+	}
+	if obj.Labels == nil {
+		return labels.Set{}, nil
+	}
+	return labels.Set(obj.Labels), nil
+}
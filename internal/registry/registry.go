@@ -0,0 +1,200 @@
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/setting"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// 生成 webhook 配置时使用的默认值
+const (
+	DefaultTimeoutSeconds int32 = 10
+	maxTimeoutSeconds     int32 = 30
+)
+
+// Type 是 webhook 的类型
+type Type string
+
+const (
+	Mutating   Type = "Mutating"
+	Validating Type = "Validating"
+)
+
+// Fallback 是处理函数超时、返回内部错误或 panic 时返回的响应
+type Fallback string
+
+const (
+	// FallbackAllow 放行请求，不修改对象
+	FallbackAllow Fallback = "Allow"
+	// FallbackDeny 拒绝请求，并说明原因
+	FallbackDeny Fallback = "Deny"
+)
+
+// Descriptor 描述一个 webhook：服务的路径、类型、匹配的资源和操作以及处理函数。
+// webhook 服务器的路由和 webhook 配置都从 Descriptor 生成，新增 webhook 只需要在处理函数所在的包里注册。
+type Descriptor struct {
+	// Name 是 webhook 的唯一名称，也是 webhook 配置的名称
+	Name string
+	// Path 是 webhook 服务器上的路径，必须以 / 开头
+	Path string
+	Type Type
+	// Rules 是触发 webhook 的资源（GVR）和操作
+	Rules []admissionregistrationv1.RuleWithOperations
+	// FailurePolicy 是 webhook 配置默认的失败策略，为空时使用 Fail
+	FailurePolicy admissionregistrationv1.FailurePolicyType
+	// Handler 处理 AdmissionReview
+	Handler setting.AdmitHandler
+	// DisabledByDefault 为 true 时默认不提供服务，比如示例和测试用的 webhook
+	DisabledByDefault bool
+	// HandlerTimeout 是处理函数的时间预算，为 0 时只受 API server 超时的限制
+	HandlerTimeout time.Duration
+	// TimeoutFallback 是处理函数超时后返回的响应，为空时 FailurePolicy 为 Ignore 的放行，否则拒绝
+	TimeoutFallback Fallback
+	// ErrorPolicy 是处理函数返回内部错误或 panic 时返回的响应，为空时 FailurePolicy 为 Ignore 的放行，否则拒绝。
+	// 由服务自己决定，不依赖集群中 webhook 配置的 failurePolicy
+	ErrorPolicy Fallback
+
+	// 以下字段只用于生成 webhook 配置
+	NamespaceSelector *metav1.LabelSelector
+	ObjectSelector    *metav1.LabelSelector
+	MatchConditions   []admissionregistrationv1.MatchCondition
+	// TimeoutSeconds 为 0 时使用 DefaultTimeoutSeconds
+	TimeoutSeconds int32
+	// SideEffects 为空时使用 None
+	SideEffects admissionregistrationv1.SideEffectClass
+	// ReinvocationPolicy 只对 Mutating 有效，为空时使用 Never
+	ReinvocationPolicy admissionregistrationv1.ReinvocationPolicyType
+}
+
+var (
+	mu          sync.RWMutex
+	descriptors = make(map[string]Descriptor)
+)
+
+// Register 注册 webhook，一般在处理函数所在包的 init 中调用。
+// 描述不完整、处理函数为空或者名称、路径重复都是编程错误，直接 panic，保证服务启动后不会出现无法处理的路径。
+func Register(d Descriptor) {
+	if err := validate(&d); err != nil {
+		panic(fmt.Sprintf("registry: invalid webhook %q: %v", d.Name, err))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := descriptors[d.Name]; ok {
+		panic(fmt.Sprintf("registry: webhook %q is already registered", d.Name))
+	}
+	for _, existing := range descriptors {
+		if existing.Path == d.Path {
+			panic(fmt.Sprintf("registry: path %s of webhook %q is already registered by %q", d.Path, d.Name, existing.Name))
+		}
+	}
+	descriptors[d.Name] = d
+}
+
+// List 返回所有已注册的 webhook，按路径排序
+func List() []Descriptor {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := make([]Descriptor, 0, len(descriptors))
+	for _, d := range descriptors {
+		list = append(list, d)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
+
+// Get 按名称查找 webhook
+func Get(name string) (Descriptor, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d, ok := descriptors[name]
+	return d, ok
+}
+
+// validate 检查描述是否完整，并填充默认值
+func validate(d *Descriptor) error {
+	if d.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if !strings.HasPrefix(d.Path, "/") {
+		return fmt.Errorf("path %q must start with /", d.Path)
+	}
+	for _, reserved := range []string{MutatePath, ValidatePath} {
+		if d.Path == reserved || strings.HasPrefix(d.Path, reserved+"/") {
+			return fmt.Errorf("path %s is reserved for dispatching", d.Path)
+		}
+	}
+	if d.Type != Mutating && d.Type != Validating {
+		return fmt.Errorf("type must be %s or %s, got %q", Mutating, Validating, d.Type)
+	}
+	if len(d.Rules) == 0 {
+		return fmt.Errorf("at least one rule is required")
+	}
+	if d.Handler.V1 == nil || d.Handler.V1beta1 == nil {
+		return fmt.Errorf("handler is required")
+	}
+	switch d.FailurePolicy {
+	case "":
+		d.FailurePolicy = admissionregistrationv1.Fail
+	case admissionregistrationv1.Fail, admissionregistrationv1.Ignore:
+	default:
+		return fmt.Errorf("invalid failure policy %q", d.FailurePolicy)
+	}
+	var err error
+	if d.TimeoutFallback, err = defaultFallback(d.TimeoutFallback, d.FailurePolicy); err != nil {
+		return fmt.Errorf("invalid timeout fallback: %w", err)
+	}
+	if d.ErrorPolicy, err = defaultFallback(d.ErrorPolicy, d.FailurePolicy); err != nil {
+		return fmt.Errorf("invalid error policy: %w", err)
+	}
+	if d.HandlerTimeout < 0 {
+		return fmt.Errorf("handlerTimeout must not be negative, got %s", d.HandlerTimeout)
+	}
+
+	if d.TimeoutSeconds == 0 {
+		d.TimeoutSeconds = DefaultTimeoutSeconds
+	}
+	if d.TimeoutSeconds < 1 || d.TimeoutSeconds > maxTimeoutSeconds {
+		return fmt.Errorf("timeoutSeconds must be between 1 and %d, got %d", maxTimeoutSeconds, d.TimeoutSeconds)
+	}
+
+	switch d.SideEffects {
+	case "":
+		d.SideEffects = admissionregistrationv1.SideEffectClassNone
+	case admissionregistrationv1.SideEffectClassNone, admissionregistrationv1.SideEffectClassNoneOnDryRun:
+	default:
+		return fmt.Errorf("sideEffects must be None or NoneOnDryRun, got %q", d.SideEffects)
+	}
+
+	switch {
+	case d.Type == Validating && d.ReinvocationPolicy != "":
+		return fmt.Errorf("reinvocationPolicy is only supported by mutating webhooks")
+	case d.Type == Mutating && d.ReinvocationPolicy == "":
+		d.ReinvocationPolicy = admissionregistrationv1.NeverReinvocationPolicy
+	case d.Type == Mutating && d.ReinvocationPolicy != admissionregistrationv1.NeverReinvocationPolicy &&
+		d.ReinvocationPolicy != admissionregistrationv1.IfNeededReinvocationPolicy:
+		return fmt.Errorf("invalid reinvocationPolicy %q", d.ReinvocationPolicy)
+	}
+	return nil
+}
+
+// defaultFallback 检查 fallback，为空时 failurePolicy 为 Ignore 的放行，否则拒绝
+func defaultFallback(fallback Fallback, failurePolicy admissionregistrationv1.FailurePolicyType) (Fallback, error) {
+	switch fallback {
+	case "":
+		if failurePolicy == admissionregistrationv1.Ignore {
+			return FallbackAllow, nil
+		}
+		return FallbackDeny, nil
+	case FallbackAllow, FallbackDeny:
+		return fallback, nil
+	default:
+		return fallback, fmt.Errorf("must be %s or %s, got %q", FallbackAllow, FallbackDeny, fallback)
+	}
+}
@@ -0,0 +1,286 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/setting"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func allow(context.Context, admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{Allowed: true}
+}
+
+func descriptor(name, path string) Descriptor {
+	return Descriptor{
+		Name: name,
+		Path: path,
+		Type: Validating,
+		Rules: []admissionregistrationv1.RuleWithOperations{{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+			Rule:       admissionregistrationv1.Rule{APIGroups: []string{""}, APIVersions: []string{"v1"}, Resources: []string{"pods"}},
+		}},
+		Handler: setting.NewDelegateToV1AdmitHandler(allow),
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	testCases := []struct {
+		name   string
+		modify func(d *Descriptor)
+	}{
+		{name: "duplicate name", modify: func(d *Descriptor) { d.Path = "/other" }},
+		{name: "duplicate path", modify: func(d *Descriptor) { d.Name = "other" }},
+		{name: "nil handler", modify: func(d *Descriptor) { d.Name, d.Path, d.Handler = "other", "/other", setting.AdmitHandler{} }},
+		{name: "relative path", modify: func(d *Descriptor) { d.Name, d.Path = "other", "other" }},
+		{name: "no rules", modify: func(d *Descriptor) { d.Name, d.Path, d.Rules = "other", "/other", nil }},
+		{name: "invalid failure policy", modify: func(d *Descriptor) { d.Name, d.Path, d.FailurePolicy = "other", "/other", "Retry" }},
+		{name: "reserved path", modify: func(d *Descriptor) { d.Name, d.Path = "other", MutatePath }},
+		{name: "reserved group path", modify: func(d *Descriptor) { d.Name, d.Path = "other", ValidatePath+"/fail" }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			descriptors = make(map[string]Descriptor)
+			Register(descriptor("test", "/test"))
+
+			d := descriptor("test", "/test")
+			tc.modify(&d)
+			defer func() {
+				if recover() == nil {
+					t.Error("expected Register to panic")
+				}
+			}()
+			Register(d)
+		})
+	}
+}
+
+func TestRegisterDefaults(t *testing.T) {
+	descriptors = make(map[string]Descriptor)
+	Register(descriptor("b", "/b"))
+	Register(descriptor("a", "/a"))
+
+	list := List()
+	if len(list) != 2 || list[0].Path != "/a" || list[1].Path != "/b" {
+		t.Fatalf("expected descriptors sorted by path, got %+v", list)
+	}
+	if d, _ := Get("a"); d.FailurePolicy != admissionregistrationv1.Fail {
+		t.Errorf("expected default failure policy Fail, got %q", d.FailurePolicy)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	descriptors = make(map[string]Descriptor)
+	enabled.Store(nil)
+
+	withRule := func(name string, typ Type, ops []admissionregistrationv1.OperationType, group string, resources ...string) Descriptor {
+		d := descriptor(name, "/"+name)
+		d.Type = typ
+		d.Rules = []admissionregistrationv1.RuleWithOperations{{
+			Operations: ops,
+			Rule:       admissionregistrationv1.Rule{APIGroups: []string{group}, APIVersions: []string{"*"}, Resources: resources},
+		}}
+		return d
+	}
+	create := []admissionregistrationv1.OperationType{admissionregistrationv1.Create}
+	all := []admissionregistrationv1.OperationType{admissionregistrationv1.OperationAll}
+
+	Register(withRule("pods", Mutating, create, "", "pods"))
+	Register(withRule("pods-status", Mutating, all, "", "pods/status"))
+	Register(withRule("any-status", Mutating, all, "", "*/status"))
+	Register(withRule("everything", Mutating, all, "*", "*/*"))
+	Register(withRule("validate-pods", Validating, create, "", "pods"))
+
+	labeled := withRule("labeled", Mutating, create, "", "pods")
+	labeled.ObjectSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"inject": "true"}}
+	Register(labeled)
+	namespaced := withRule("namespaced", Mutating, create, "", "pods")
+	namespaced.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+	Register(namespaced)
+	conditions := withRule("conditions", Mutating, create, "", "pods")
+	conditions.MatchConditions = []admissionregistrationv1.MatchCondition{{Name: "test", Expression: "true"}}
+	Register(conditions)
+	disabled := withRule("disabled", Mutating, create, "", "pods")
+	disabled.DisabledByDefault = true
+	Register(disabled)
+	namespacedScope := admissionregistrationv1.NamespacedScope
+	namespacedOnly := withRule("namespaced-scope", Mutating, create, "", "pods")
+	namespacedOnly.Rules[0].Scope = &namespacedScope
+	Register(namespacedOnly)
+	clusterScope := admissionregistrationv1.ClusterScope
+	clusterOnly := withRule("cluster-scope", Mutating, all, "", "*")
+	clusterOnly.Rules[0].Scope = &clusterScope
+	Register(clusterOnly)
+
+	namespaceLabels := func(_ context.Context, namespace string) (map[string]string, error) {
+		switch namespace {
+		case "team-a":
+			return map[string]string{"team": "a"}, nil
+		case "broken":
+			return nil, errors.New("not found")
+		}
+		return nil, nil
+	}
+	pods := metav1.GroupVersionResource{Version: "v1", Resource: "pods"}
+	namespaces := metav1.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+	testCases := []struct {
+		name    string
+		typ     Type
+		request admissionv1.AdmissionRequest
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "main resource",
+			typ:     Mutating,
+			request: admissionv1.AdmissionRequest{Resource: pods, Operation: admissionv1.Create, Namespace: "default"},
+			want:    []string{"everything", "namespaced-scope", "pods"},
+		},
+		{
+			name:    "cluster scope",
+			typ:     Mutating,
+			request: admissionv1.AdmissionRequest{Resource: pods, Operation: admissionv1.Create},
+			want:    []string{"cluster-scope", "everything", "namespaced", "pods"},
+		},
+		{
+			// namespace 对象的请求中 Namespace 是自己的名称，但它是集群级别的资源
+			name:    "namespace object is cluster scoped",
+			typ:     Mutating,
+			request: admissionv1.AdmissionRequest{Resource: namespaces, Operation: admissionv1.Update, Namespace: "default", Name: "default"},
+			want:    []string{"cluster-scope", "everything"},
+		},
+		{
+			name:    "subresource",
+			typ:     Mutating,
+			request: admissionv1.AdmissionRequest{Resource: pods, SubResource: "status", Operation: admissionv1.Update},
+			want:    []string{"any-status", "everything", "pods-status"},
+		},
+		{
+			name:    "operation",
+			typ:     Mutating,
+			request: admissionv1.AdmissionRequest{Resource: pods, Operation: admissionv1.Update, Namespace: "default"},
+			want:    []string{"everything"},
+		},
+		{
+			name: "object and namespace selector",
+			typ:  Mutating,
+			request: admissionv1.AdmissionRequest{Resource: pods, Operation: admissionv1.Create, Namespace: "team-a",
+				Object: runtime.RawExtension{Raw: []byte(`{"metadata":{"labels":{"inject":"true"}}}`)}},
+			want: []string{"everything", "labeled", "namespaced", "namespaced-scope", "pods"},
+		},
+		{
+			name:    "namespace lookup fails",
+			typ:     Mutating,
+			request: admissionv1.AdmissionRequest{Resource: pods, Operation: admissionv1.Create, Namespace: "broken"},
+			wantErr: true,
+		},
+		{
+			name:    "validating",
+			typ:     Validating,
+			request: admissionv1.AdmissionRequest{Resource: pods, Operation: admissionv1.Create, Namespace: "default"},
+			want:    []string{"validate-pods"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			matched, err := Match(context.Background(), tc.typ, &tc.request, namespaceLabels)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			var names []string
+			for _, d := range matched {
+				names = append(names, d.Name)
+			}
+			if !reflect.DeepEqual(names, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, names)
+			}
+		})
+	}
+}
+
+func TestDispatchGroups(t *testing.T) {
+	descriptors = make(map[string]Descriptor)
+	enabled.Store(nil)
+
+	teamA := &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+	register := func(name string, typ Type, failurePolicy admissionregistrationv1.FailurePolicyType, namespaceSelector *metav1.LabelSelector) {
+		d := descriptor(name, "/"+name)
+		d.Type, d.FailurePolicy, d.NamespaceSelector = typ, failurePolicy, namespaceSelector
+		Register(d)
+	}
+	register("a", Mutating, admissionregistrationv1.Fail, nil)
+	register("b", Mutating, admissionregistrationv1.Fail, nil)
+	register("c", Mutating, admissionregistrationv1.Ignore, nil)
+	register("d", Mutating, admissionregistrationv1.Fail, teamA)
+	register("e", Mutating, admissionregistrationv1.Fail, &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}})
+	register("f", Mutating, admissionregistrationv1.Fail, &metav1.LabelSelector{MatchLabels: map[string]string{"team": "b"}})
+	register("g", Validating, admissionregistrationv1.Fail, nil)
+	conditions := descriptor("h", "/h")
+	conditions.Type = Mutating
+	conditions.MatchConditions = []admissionregistrationv1.MatchCondition{{Name: "test", Expression: "true"}}
+	Register(conditions)
+
+	groups := DispatchGroups()
+	got := map[string][]string{}
+	for _, g := range groups {
+		got[g.Path] = g.Webhooks
+		if !strings.HasPrefix(g.Path, DispatchPath(g.Type)+"/") || g.Name != strings.ReplaceAll(strings.TrimPrefix(g.Path, "/"), "/", "-") {
+			t.Errorf("unexpected name %q or path %q of group %v", g.Name, g.Path, g.Webhooks)
+		}
+	}
+	if len(groups) != 5 {
+		t.Fatalf("expected 5 groups, got %v", got)
+	}
+	if w := got["/mutate/fail"]; !reflect.DeepEqual(w, []string{"a", "b"}) {
+		t.Errorf("expected /mutate/fail to hold a and b, got %v", w)
+	}
+	if w := got["/mutate/ignore"]; !reflect.DeepEqual(w, []string{"c"}) {
+		t.Errorf("expected /mutate/ignore to hold c, got %v", w)
+	}
+	if w := got["/validate/fail"]; !reflect.DeepEqual(w, []string{"g"}) {
+		t.Errorf("expected /validate/fail to hold g, got %v", w)
+	}
+	// 相同的 selector 在同一组，不同的 selector 在不同的组
+	for _, g := range groups {
+		switch {
+		case slices.Contains(g.Webhooks, "d"):
+			if !reflect.DeepEqual(g.Webhooks, []string{"d", "e"}) || !reflect.DeepEqual(g.NamespaceSelector, teamA) {
+				t.Errorf("expected d and e to share a group with their selector, got %+v", g)
+			}
+		case slices.Contains(g.Webhooks, "f"):
+			if len(g.Webhooks) != 1 {
+				t.Errorf("expected f to have its own group, got %+v", g)
+			}
+		}
+	}
+
+	pods := metav1.GroupVersionResource{Version: "v1", Resource: "pods"}
+	req := &admissionv1.AdmissionRequest{Resource: pods, Operation: admissionv1.Create, Namespace: "default"}
+	for _, g := range groups {
+		if g.Path != "/mutate/fail" {
+			continue
+		}
+		matched, err := MatchGroup(context.Background(), g, req, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, d := range matched {
+			names = append(names, d.Name)
+		}
+		if !reflect.DeepEqual(names, []string{"a", "b"}) {
+			t.Errorf("expected MatchGroup to return a and b, got %v", names)
+		}
+	}
+}
@@ -1,17 +1,21 @@
 package api
 
 import (
-	"errors"
 	"fmt"
 	"net/http"
 	_ "net/http/pprof"
 	"time"
 
+	"github.com/aloys.zy/aloys-webhook-example/internal/auth"
 	"github.com/aloys.zy/aloys-webhook-example/internal/configs"
+	"github.com/aloys.zy/aloys-webhook-example/internal/health"
+	"github.com/aloys.zy/aloys-webhook-example/internal/tls"
+	"github.com/aloys.zy/aloys-webhook-example/internal/util"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
+// MetricsStart 创建 metrics 和健康检查服务器，服务器的启动和关闭由 lifecycle.Manager 负责
 func MetricsStart(cfg *configs.Config) *http.Server {
 
 	setupLog := ctrl.Log.WithName("metrics Start")
@@ -19,53 +23,41 @@ func MetricsStart(cfg *configs.Config) *http.Server {
 	// 启动metrics服务器
 	setupLog.V(1).Info("Creating HTTP server mux for metrics endpoints")
 	metricsMux := http.NewServeMux()
-	metricsMux.Handle("/metrics", promhttp.Handler())
-
-	// 简化健康检查和就绪检查的处理函数
-	handleCheck := func(w http.ResponseWriter, req *http.Request, endpoint string) {
-		startTime := time.Now()
-		_, _ = w.Write([]byte("ok"))
-		// 记录请求完成的日志
-		setupLog.Info(
-			"Request completed",
-			"method", req.Method,
-			"url", req.URL.String(),
-			"remoteAddr", req.RemoteAddr,
-			"userAgent", req.UserAgent(),
-			"path", req.RequestURI,
-			"status", http.StatusOK,
-			"elapsed_time", time.Since(startTime))
+	metricsHandler := promhttp.Handler()
+	// 与 kubebuilder 受保护的 metrics 一致，调用方需要有 nonResourceURLs: ["/metrics"] 的 get 权限
+	if cfg.MetricsSecure {
+		metricsHandler = auth.NewRequestAuthorizer(util.GetClientSet(), cfg.MetricsAuthCacheTTL).WithAuthnAuthz(metricsHandler)
 	}
+	metricsMux.Handle("/metrics", metricsHandler)
 
-	metricsMux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
-		handleCheck(w, req, "Readyz")
-	})
-	metricsMux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
-		handleCheck(w, req, "Healthz")
-	})
+	// 健康检查不做认证，kubelet 探针不携带 token
+	// 就绪检查和存活检查分开：就绪检查由各子系统注册检查项，存活检查只确认进程能够响应。
+	// /healthz 保留为 /livez 的别名，兼容已有的探针配置
+	metricsMux.Handle("/readyz", health.ReadyzHandler())
+	metricsMux.Handle("/readyz/", health.ReadyzHandler())
+	metricsMux.Handle("/livez", health.LivezHandler())
+	metricsMux.Handle("/livez/", health.LivezHandler())
+	metricsMux.Handle("/healthz", health.LivezHandler())
 
 	metricsServer := &http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.MetricsBindPort),
-		Handler: metricsMux,
-		// TLSConfig:      tls.ConfigTLS(),
+		Addr:           fmt.Sprintf(":%d", cfg.MetricsBindPort),
+		Handler:        metricsMux,
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   15 * time.Second,
 		IdleTimeout:    60 * time.Second,
 		MaxHeaderBytes: 1 << 20, // 1MB
 	}
 
-	setupLog.Info("Starting metrics server on port", "port", cfg.MetricsBindPort)
-
-	go func() {
-		defer setupLog.Info("Metrics server goroutine has exited")
-
-		// http监听
-		err := metricsServer.ListenAndServe()
-		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			setupLog.Error(err, "Failed to listen and serve metrics server:")
-			panic(err)
+	if cfg.MetricsSecure {
+		tlsConfig, err := tls.ConfigMetricsTLS(cfg)
+		if err != nil {
+			setupLog.Error(err, "Failed to configure TLS for metrics server")
+			return nil
 		}
-	}()
+		metricsServer.TLSConfig = tlsConfig
+	}
+
+	setupLog.Info("Created metrics server", "port", cfg.MetricsBindPort, "secure", cfg.MetricsSecure)
 
 	return metricsServer
 }
@@ -1,58 +1,133 @@
 package api
 
 import (
-	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/aloys.zy/aloys-webhook-example/internal/auth"
 	"github.com/aloys.zy/aloys-webhook-example/internal/configs"
+	_ "github.com/aloys.zy/aloys-webhook-example/internal/controller" // 注册所有 webhook
 	"github.com/aloys.zy/aloys-webhook-example/internal/metrics"
+	"github.com/aloys.zy/aloys-webhook-example/internal/registry"
+	"github.com/aloys.zy/aloys-webhook-example/internal/routers"
+	"github.com/aloys.zy/aloys-webhook-example/internal/setting"
 	"github.com/aloys.zy/aloys-webhook-example/internal/tls"
+	"github.com/aloys.zy/aloys-webhook-example/internal/util"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
-func WebhookStart(cfg *configs.Config) *http.Server {
+// WebhookStart 创建 webhook 服务器，服务器的启动和关闭由 lifecycle.Manager 负责。
+// namespaceLabels 返回 namespace 的标签，分发请求时用于匹配 webhook 的 namespaceSelector
+func WebhookStart(cfg *configs.Config, namespaceLabels registry.NamespaceLabelsFunc) *http.Server {
 	setupLog := ctrl.Log.WithName("webhook Start")
 
 	// 创建 HTTP 服务器多路复用器并注册处理函数
 	setupLog.V(1).Info("Creating HTTP server mux for webhook endpoints")
 	webhook := http.NewServeMux()
 
-	// 注册各个 webhook 处理函数，并包裹上 metrics 中间件
-	endpoints := map[string]string{
-		"/mutating-cpu-oversell": "ServeMutateCPUOversell",
-		"/mutating-pod-dns":      "MutatePodDNSConfig",
-		// "/always-allow-delay-5s":    "ServeAlwaysAllowDelayFiveSeconds",
-		// "/always-deny":              "ServeAlwaysDeny",
-		// "/add-label":                "ServeAddLabel",
-		// "/pods":                     "ServePods",
-		// "/pods/attach":              "ServeAttachingPods",
-		// "/mutating-pods":            "ServeMutatePods",
-		// "/mutating-pods-sidecar":    "ServeMutatePodsSidecar",
-		// "/configmaps":               "ServeConfigmaps",
-		// "/mutating-configmaps":      "ServeMutateConfigmaps",
-		// "/custom-resource":          "ServeCustomResource",
-		// "/mutating-custom-resource": "ServeMutateCustomResource",
-		// "/crd":                      "ServeCRD",
-		// "/validating-pod-container-limit": "ServeValidatePodContainerLimit", // Commented out for now
-	}
-
-	for endpoint, handlerName := range endpoints {
-		handlerFunc := metrics.WithMetrics(getHandlerFuncByName(handlerName))
-		webhook.HandleFunc(endpoint, handlerFunc)
+	// 开启 bearer token 认证时，在处理函数之前校验调用方身份
+	var tokenAuthenticator *auth.TokenAuthenticator
+	if cfg.TokenAuth {
+		var err error
+		tokenAuthenticator, err = auth.NewTokenAuthenticator(util.GetClientSet(), cfg.TokenAuthCacheTTL,
+			cfg.TokenAllowedUsers, cfg.TokenAllowedGroups)
+		if err != nil {
+			setupLog.Error(err, "Failed to configure token authentication for webhook server")
+			return nil
+		}
+	}
+
+	// 从 registry 注册所有 webhook 处理函数，并包裹上认证、并发限制和 metrics 中间件。
+	// 禁用的 webhook 同样注册路由，由 WithEnabled 返回 404，这样启用和禁用不需要重建路由
+	// 处理函数包裹上时间预算和错误策略，超时、内部错误或 panic 时返回配置的响应，分发路径调用 webhook 时同样使用
+	handlers := make(map[string]setting.AdmitHandler)
+	for _, d := range registry.List() {
+		budget, fallback, err := handlerTimeout(cfg, d)
+		if err != nil {
+			setupLog.Error(err, "Failed to configure handler timeout for webhook endpoint", "endpoint", d.Path)
+			return nil
+		}
+		policy, err := errorPolicy(cfg, d.Path, d.ErrorPolicy)
+		if err != nil {
+			setupLog.Error(err, "Failed to configure error policy for webhook endpoint", "endpoint", d.Path)
+			return nil
+		}
+		handlers[d.Name] = routers.WithErrorPolicy(d.Name, policy, routers.WithTimeout(d.Name, budget, fallback, d.Handler))
+	}
+	handler := func(d registry.Descriptor) setting.AdmitHandler { return handlers[d.Name] }
+
+	for _, d := range registry.List() {
+		limiter, err := newLimiter(cfg, d.Path, d.FailurePolicy)
+		if err != nil {
+			setupLog.Error(err, "Failed to configure concurrency limit for webhook endpoint", "endpoint", d.Path)
+			return nil
+		}
+		handlerFunc := withAuth(routers.Handler(handlers[d.Name]), limiter, tokenAuthenticator)
+		handlerFunc = routers.WithEnabled(d.Name, handlerFunc)
+		handlerFunc = metrics.WithMetrics(handlerFunc)
+		webhook.HandleFunc(d.Path, handlerFunc)
 		setupLog.Info(
 			"Registered webhook endpoint",
-			"endpoint", endpoint, // 键值对：endpoint
-			"handler", handlerName, // 键值对：handler
+			"endpoint", d.Path, // 键值对：endpoint
+			"name", d.Name, // 键值对：name
+			"type", d.Type,
+			"enabled", registry.IsEnabled(d.Name),
 		)
+	}
+
+	// 通用的 /mutate 和 /validate 按请求的资源、子资源和操作分发给匹配的 webhook，
+	// 各 webhook 自己的路径继续保留，兼容已有的 webhook 配置。
+	// 生成的分发配置使用组的路径（比如 /mutate/fail），每个组的 selector 和失败策略由 API server 处理，分发时只调用组内的 webhook
+	type dispatchEndpoint struct {
+		path          string
+		typ           registry.Type
+		failurePolicy admissionregistrationv1.FailurePolicyType
+		admit         setting.AdmitHandler
+	}
+	var endpoints []dispatchEndpoint
+	for _, typ := range []registry.Type{registry.Mutating, registry.Validating} {
+		endpoints = append(endpoints, dispatchEndpoint{registry.DispatchPath(typ), typ, admissionregistrationv1.Fail,
+			routers.Dispatch(typ, namespaceLabels, handler)})
+	}
+	for _, g := range registry.DispatchGroups() {
+		endpoints = append(endpoints, dispatchEndpoint{g.Path, g.Type, g.FailurePolicy,
+			routers.DispatchGroup(g, namespaceLabels, handler)})
+	}
+	for _, e := range endpoints {
+		// 分发本身出错（比如查询 namespace 失败）时默认按失败策略处理，匹配的 webhook 按各自的错误策略处理
+		defaultPolicy := registry.FallbackDeny
+		if e.failurePolicy == admissionregistrationv1.Ignore {
+			defaultPolicy = registry.FallbackAllow
+		}
+		policy, err := errorPolicy(cfg, e.path, defaultPolicy)
+		if err != nil {
+			setupLog.Error(err, "Failed to configure error policy for webhook endpoint", "endpoint", e.path)
+			return nil
+		}
+		dispatch := routers.WithErrorPolicy(strings.ReplaceAll(strings.TrimPrefix(e.path, "/"), "/", "-"), policy, e.admit)
+		limiter, err := newLimiter(cfg, e.path, e.failurePolicy)
+		if err != nil {
+			setupLog.Error(err, "Failed to configure concurrency limit for webhook endpoint", "endpoint", e.path)
+			return nil
+		}
+		handlerFunc := metrics.WithMetrics(withAuth(routers.Handler(dispatch), limiter, tokenAuthenticator))
+		webhook.HandleFunc(e.path, handlerFunc)
+		setupLog.Info("Registered dispatching webhook endpoint", "endpoint", e.path, "type", e.typ)
+	}
 
+	tlsConfig, err := tls.ConfigTLS(cfg)
+	if err != nil {
+		setupLog.Error(err, "Failed to configure TLS for webhook server")
+		return nil
 	}
 
 	// 创建并配置 HTTP 服务器
 	webhookServer := &http.Server{
 		Addr:           fmt.Sprintf(":%d", cfg.WebhookBindPort),
-		TLSConfig:      tls.ConfigTLS(),
+		TLSConfig:      tlsConfig,
 		Handler:        webhook,
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   15 * time.Second,
@@ -60,19 +135,77 @@ func WebhookStart(cfg *configs.Config) *http.Server {
 		MaxHeaderBytes: 1 << 20, // 1MB
 	}
 
-	setupLog.Info("Starting webhook server on port:", "port:", cfg.WebhookBindPort)
-
-	// 启动服务
-	go func() {
-		defer setupLog.Info("Webhook server goroutine has exited")
-		err := webhookServer.ListenAndServeTLS("", "")
-		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			setupLog.Error(err, "Failed to listen and serve webhook server:")
-			panic(err)
-		} else if errors.Is(err, http.ErrServerClosed) {
-			setupLog.Info("Webhook server closed gracefully")
-		}
-	}()
+	setupLog.Info("Created webhook server", "port", cfg.WebhookBindPort)
 
 	return webhookServer
 }
+
+// withAuth 包装认证和并发限制：只检查请求的客户端证书和 bearer token 是否存在的校验放在并发限制之前，
+// 未认证的调用方不占用并发槽位，被拒绝的请求记录在 webhook_auth_rejections_total 中，不计入 webhook_requests_shed_total；
+// 调用 TokenReview 的校验放在并发限制之后，过载时不会再增加 TokenReview 的调用。
+// token 无效的调用方在 TokenReview 返回前仍然占用槽位，校验结果有缓存
+func withAuth(handlerFunc http.HandlerFunc, limiter *routers.Limiter, tokenAuthenticator *auth.TokenAuthenticator) http.HandlerFunc {
+	if tokenAuthenticator != nil {
+		handlerFunc = tokenAuthenticator.WithTokenAuth(handlerFunc)
+	}
+	handlerFunc = limiter.WithLimit(handlerFunc)
+	if tokenAuthenticator != nil {
+		handlerFunc = auth.WithBearerToken(handlerFunc)
+	}
+	return routers.WithClientCert(handlerFunc)
+}
+
+// newLimiter 按 endpoint 的配置创建并发限制，未单独配置的 endpoint 使用默认值，
+// 失败策略默认与 webhook 注册的 failurePolicy 一致
+func newLimiter(cfg *configs.Config, endpoint string, defaultPolicy admissionregistrationv1.FailurePolicyType) (*routers.Limiter, error) {
+	maxInflight, ok := cfg.MaxInflight[endpoint]
+	if !ok {
+		maxInflight = cfg.DefaultMaxInflight
+	}
+	maxQueue, ok := cfg.MaxQueue[endpoint]
+	if !ok {
+		maxQueue = cfg.DefaultMaxQueue
+	}
+	failurePolicy, ok := cfg.FailurePolicies[endpoint]
+	if !ok {
+		failurePolicy = string(defaultPolicy)
+	}
+	return routers.NewLimiter(endpoint, maxInflight, maxQueue, cfg.QueueTimeout, failurePolicy)
+}
+
+// handlerTimeout 返回 webhook 处理函数的时间预算和超时后的响应，命令行参数优先于注册时的配置
+func handlerTimeout(cfg *configs.Config, d registry.Descriptor) (time.Duration, registry.Fallback, error) {
+	budget, ok := cfg.HandlerTimeouts[d.Path]
+	if !ok {
+		budget = d.HandlerTimeout
+	}
+	if budget < 0 {
+		return 0, "", fmt.Errorf("handler timeout of %s must not be negative, got %s", d.Path, budget)
+	}
+	fallback, err := fallbackFor(cfg.TimeoutFallbacks, d.Path, d.TimeoutFallback)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid timeout fallback: %w", err)
+	}
+	return budget, fallback, nil
+}
+
+// errorPolicy 返回 endpoint 处理函数内部错误或 panic 时的响应，命令行参数优先于注册时的配置
+func errorPolicy(cfg *configs.Config, endpoint string, defaultPolicy registry.Fallback) (registry.Fallback, error) {
+	policy, err := fallbackFor(cfg.ErrorPolicies, endpoint, defaultPolicy)
+	if err != nil {
+		return "", fmt.Errorf("invalid error policy: %w", err)
+	}
+	return policy, nil
+}
+
+// fallbackFor 返回 endpoint 在命令行参数中配置的响应，没有配置时使用 defaultFallback
+func fallbackFor(values configs.StringMap, endpoint string, defaultFallback registry.Fallback) (registry.Fallback, error) {
+	fallback := defaultFallback
+	if v, ok := values[endpoint]; ok {
+		fallback = registry.Fallback(v)
+	}
+	if fallback != registry.FallbackAllow && fallback != registry.FallbackDeny {
+		return "", fmt.Errorf("%q for %s must be %s or %s", fallback, endpoint, registry.FallbackAllow, registry.FallbackDeny)
+	}
+	return fallback, nil
+}
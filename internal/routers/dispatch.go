@@ -0,0 +1,139 @@
+package routers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/registry"
+	"github.com/aloys.zy/aloys-webhook-example/internal/setting"
+	"github.com/aloys.zy/aloys-webhook-example/internal/util"
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	admissionv1 "k8s.io/api/admission/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// Dispatch 返回 /mutate 或 /validate 的 AdmitHandler，按请求的资源、子资源和操作分发给所有匹配的已启用 webhook，
+// 这样所有 webhook 只需要一个 MutatingWebhookConfiguration 和一个 ValidatingWebhookConfiguration。
+//
+// mutating webhook 按路径顺序执行，每个 webhook 看到的是前一个 webhook 修改后的对象，
+// 最后返回对比原始对象生成的一个合并的 JSON Patch；validating webhook 有一个拒绝就拒绝请求。
+//
+// handler 返回调用 webhook 时使用的 AdmitHandler，用于包裹超时等中间件，为 nil 时直接使用 Descriptor.Handler。
+func Dispatch(typ registry.Type, namespaceLabels registry.NamespaceLabelsFunc, handler func(registry.Descriptor) setting.AdmitHandler) setting.AdmitHandler {
+	match := func(ctx context.Context, req *admissionv1.AdmissionRequest) ([]registry.Descriptor, error) {
+		return registry.Match(ctx, typ, req, namespaceLabels)
+	}
+	return newDispatcher(typ, match, handler)
+}
+
+// DispatchGroup 返回分发组路径的 AdmitHandler，与 Dispatch 相同，只调用组内匹配的 webhook
+func DispatchGroup(g registry.DispatchGroup, namespaceLabels registry.NamespaceLabelsFunc, handler func(registry.Descriptor) setting.AdmitHandler) setting.AdmitHandler {
+	match := func(ctx context.Context, req *admissionv1.AdmissionRequest) ([]registry.Descriptor, error) {
+		return registry.MatchGroup(ctx, g, req, namespaceLabels)
+	}
+	return newDispatcher(g.Type, match, handler)
+}
+
+func newDispatcher(typ registry.Type, match matchFunc, handler func(registry.Descriptor) setting.AdmitHandler) setting.AdmitHandler {
+	if handler == nil {
+		handler = func(d registry.Descriptor) setting.AdmitHandler { return d.Handler }
+	}
+	d := &dispatcher{typ: typ, match: match, handler: handler}
+	return setting.NewDelegateToV1AdmitHandler(d.admit)
+}
+
+// matchFunc 返回应该处理请求的 webhook
+type matchFunc func(ctx context.Context, req *admissionv1.AdmissionRequest) ([]registry.Descriptor, error)
+
+type dispatcher struct {
+	typ     registry.Type
+	match   matchFunc
+	handler func(registry.Descriptor) setting.AdmitHandler
+}
+
+func (d *dispatcher) admit(ctx context.Context, ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+	setupLog := ctrl.Log.WithName("dispatcher").WithValues("type", d.typ)
+
+	req := ar.Request
+	if req == nil {
+		return setting.ToV1AdmissionResponse(fmt.Errorf("admission review has no request"))
+	}
+	matched, err := d.match(ctx, req)
+	if err != nil {
+		setupLog.Error(err, "Failed to match webhooks", "resource", req.Resource, "subResource", req.SubResource)
+		return setting.ToV1AdmissionResponse(err)
+	}
+	if len(matched) == 0 {
+		setupLog.V(1).Info("No webhook matches the request",
+			"resource", req.Resource, "subResource", req.SubResource, "operation", req.Operation)
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	if d.typ == registry.Mutating {
+		return d.mutate(ctx, ar, matched)
+	}
+	return d.validate(ctx, ar, matched)
+}
+
+// mutate 依次调用匹配的 mutating webhook，把每个 webhook 返回的 Patch 应用到对象上再交给下一个
+func (d *dispatcher) mutate(ctx context.Context, ar admissionv1.AdmissionReview, matched []registry.Descriptor) *admissionv1.AdmissionResponse {
+	setupLog := ctrl.Log.WithName("dispatcher").WithValues("type", d.typ)
+
+	original := ar.Request.Object.Raw
+	current := original
+	var warnings []string
+	for _, webhook := range matched {
+		req := *ar.Request
+		req.Object.Raw = current
+		resp := d.handler(webhook).V1(ctx, admissionv1.AdmissionReview{Request: &req})
+		if resp == nil {
+			return setting.ToV1AdmissionResponse(fmt.Errorf("webhook %s returned no response", webhook.Name))
+		}
+		warnings = append(warnings, resp.Warnings...)
+		if !resp.Allowed {
+			resp.Warnings = warnings
+			return resp
+		}
+		if len(resp.Patch) == 0 {
+			continue
+		}
+		patch, err := jsonpatch.DecodePatch(resp.Patch)
+		if err == nil {
+			current, err = patch.Apply(current)
+		}
+		if err != nil {
+			setupLog.Error(err, "Failed to apply patch", "webhook", webhook.Name)
+			return setting.ToV1AdmissionResponse(fmt.Errorf("failed to apply patch of webhook %s: %w", webhook.Name, err))
+		}
+	}
+
+	resp := &admissionv1.AdmissionResponse{Allowed: true, Warnings: warnings}
+	patch, err := util.CreateJSONPatch(original, current)
+	if err != nil {
+		setupLog.Error(err, "Failed to create merged patch")
+		return setting.ToV1AdmissionResponse(err)
+	}
+	if patch != nil {
+		pt := admissionv1.PatchTypeJSONPatch
+		resp.Patch = patch
+		resp.PatchType = &pt
+	}
+	return resp
+}
+
+// validate 调用所有匹配的 validating webhook，返回第一个拒绝的响应
+func (d *dispatcher) validate(ctx context.Context, ar admissionv1.AdmissionReview, matched []registry.Descriptor) *admissionv1.AdmissionResponse {
+	var warnings []string
+	for _, webhook := range matched {
+		resp := d.handler(webhook).V1(ctx, ar)
+		if resp == nil {
+			return setting.ToV1AdmissionResponse(fmt.Errorf("webhook %s returned no response", webhook.Name))
+		}
+		warnings = append(warnings, resp.Warnings...)
+		if !resp.Allowed {
+			resp.Warnings = warnings
+			return resp
+		}
+	}
+	return &admissionv1.AdmissionResponse{Allowed: true, Warnings: warnings}
+}
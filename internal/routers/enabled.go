@@ -0,0 +1,29 @@
+package routers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/metrics"
+	"github.com/aloys.zy/aloys-webhook-example/internal/registry"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// WithEnabled 包装处理函数，webhook 被禁用时返回 404，启用状态每次请求时检查，修改配置后立即生效
+func WithEnabled(name string, next http.HandlerFunc) http.HandlerFunc {
+	setupLog := ctrl.Log.WithName("server")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !registry.IsEnabled(name) {
+			metrics.RecordDisabledRequest(r.URL.Path)
+			setupLog.V(1).Info("Rejected request to disabled webhook",
+				"name", name,
+				"url", r.URL.String(),
+				"remoteAddr", r.RemoteAddr,
+			)
+			http.Error(w, fmt.Sprintf("webhook %q is disabled", name), http.StatusNotFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,128 @@
+package routers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/metrics"
+	"github.com/aloys.zy/aloys-webhook-example/internal/registry"
+	"github.com/aloys.zy/aloys-webhook-example/internal/setting"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// WithErrorPolicy 包装 AdmitHandler，处理函数返回内部错误（setting.ToV1AdmissionResponse）、没有返回响应或 panic 时，
+// 按 policy 放行或拒绝，并在响应中附带说明降级原因的警告。这样内部错误的结果由 webhook 自己决定，
+// 总是返回合法的 AdmissionReview，而不是依赖集群中 webhook 配置的 failurePolicy 处理 HTTP 500。
+func WithErrorPolicy(name string, policy registry.Fallback, admit setting.AdmitHandler) setting.AdmitHandler {
+	e := &errorPolicy{name: name, policy: policy}
+	return setting.AdmitHandler{
+		V1: func(ctx context.Context, review admissionv1.AdmissionReview) (resp *admissionv1.AdmissionResponse) {
+			defer func() {
+				if r := recover(); r != nil {
+					resp = e.recovered(r)
+				}
+			}()
+			resp = admit.V1(ctx, review)
+			if resp == nil {
+				return e.degrade("error", nil, fmt.Sprintf("webhook %s returned no response", e.name))
+			}
+			if setting.IsInternalError(resp) && !degraded(resp.Result) {
+				return e.internalError(resp.Warnings, resp.Result.Message)
+			}
+			return resp
+		},
+		V1beta1: func(ctx context.Context, review v1beta1.AdmissionReview) (resp *v1beta1.AdmissionResponse) {
+			defer func() {
+				if r := recover(); r != nil {
+					resp = setting.ConvertAdmissionResponseToV1beta1(e.recovered(r))
+				}
+			}()
+			resp = admit.V1beta1(ctx, review)
+			if resp == nil {
+				return setting.ConvertAdmissionResponseToV1beta1(e.degrade("error", nil, fmt.Sprintf("webhook %s returned no response", e.name)))
+			}
+			if !resp.Allowed && resp.Result != nil && resp.Result.Code == http.StatusInternalServerError && !degraded(resp.Result) {
+				return setting.ConvertAdmissionResponseToV1beta1(e.internalError(resp.Warnings, resp.Result.Message))
+			}
+			return resp
+		},
+	}
+}
+
+// causeErrorPolicy 标记按 policy 降级后的拒绝响应，分发路径外层的 WithErrorPolicy 不再重复处理
+const causeErrorPolicy metav1.CauseType = "WebhookErrorPolicy"
+
+type errorPolicy struct {
+	name   string
+	policy registry.Fallback
+}
+
+// recovered 记录 panic 的值和堆栈，返回按 policy 降级的响应
+func (e *errorPolicy) recovered(r interface{}) *admissionv1.AdmissionResponse {
+	setupLog := ctrl.Log.WithName("server")
+
+	value, stack := r, debug.Stack()
+	// 超时包装在另一个 goroutine 中调用处理函数，panic 时带回了那个 goroutine 的堆栈
+	if p, ok := r.(handlerPanic); ok {
+		value, stack = p.value, p.stack
+	}
+	setupLog.Error(nil, "Recovered from panic in admission handler",
+		"webhook", e.name,
+		"error", value,
+		"policy", e.policy,
+		"stacktrace", string(stack),
+	)
+	return e.degrade("panic", nil, fmt.Sprintf("webhook %s panicked: %v", e.name, value))
+}
+
+// internalError 返回处理函数内部错误降级后的响应
+func (e *errorPolicy) internalError(warnings []string, message string) *admissionv1.AdmissionResponse {
+	return e.degrade("error", warnings, fmt.Sprintf("webhook %s failed with an internal error: %s", e.name, message))
+}
+
+// degrade 记录指标并按 policy 返回响应：Allow 放行且不修改对象，Deny 拒绝并返回 500
+func (e *errorPolicy) degrade(reason string, warnings []string, message string) *admissionv1.AdmissionResponse {
+	setupLog := ctrl.Log.WithName("server")
+
+	metrics.RecordHandlerError(e.name, reason, string(e.policy))
+	if e.policy == registry.FallbackAllow {
+		setupLog.Info("Allowing request after an internal error of admission handler", "webhook", e.name, "reason", reason, "message", message)
+		return &admissionv1.AdmissionResponse{
+			Allowed:  true,
+			Warnings: append(warnings, message+", request allowed without admission"),
+		}
+	}
+	setupLog.Info("Denying request after an internal error of admission handler", "webhook", e.name, "reason", reason, "message", message)
+	return &admissionv1.AdmissionResponse{
+		Allowed:  false,
+		Warnings: append(warnings, message+", request denied"),
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: message,
+			Reason:  metav1.StatusReasonInternalError,
+			Code:    http.StatusInternalServerError,
+			Details: &metav1.StatusDetails{
+				Name:   e.name,
+				Causes: []metav1.StatusCause{{Type: causeErrorPolicy, Message: reason}},
+			},
+		},
+	}
+}
+
+// degraded 判断拒绝响应是否已经由 WithErrorPolicy 降级
+func degraded(status *metav1.Status) bool {
+	if status.Details == nil {
+		return false
+	}
+	for _, c := range status.Details.Causes {
+		if c.Type == causeErrorPolicy {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package routers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/registry"
+	"github.com/aloys.zy/aloys-webhook-example/internal/setting"
+)
+
+// handlerErrors 返回 webhook_handler_errors_total 中一个 webhook 某种原因和策略的次数
+func handlerErrors(t *testing.T, webhook, reason string, policy registry.Fallback) float64 {
+	t.Helper()
+	return counterValue(t, "webhook_handler_errors_total", map[string]string{"webhook": webhook, "reason": reason, "policy": string(policy)})
+}
+
+// reviewResponse 是 v1 和 v1beta1 AdmissionReview 响应中测试关心的字段
+type reviewResponse struct {
+	Response struct {
+		UID      types.UID      `json:"uid"`
+		Allowed  bool           `json:"allowed"`
+		Warnings []string       `json:"warnings"`
+		Result   *metav1.Status `json:"status"`
+	} `json:"response"`
+}
+
+func TestWithErrorPolicyPanic(t *testing.T) {
+	const uid = types.UID("0b6a0e4c-3b0e-4f1e-9d7c-5b0f4f6c2a11")
+	reviews := map[string]runtime.Object{
+		"v1": &admissionv1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: admissionv1.SchemeGroupVersion.String(), Kind: "AdmissionReview"},
+			Request:  &admissionv1.AdmissionRequest{UID: uid},
+		},
+		"v1beta1": &v1beta1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: v1beta1.SchemeGroupVersion.String(), Kind: "AdmissionReview"},
+			Request:  &v1beta1.AdmissionRequest{UID: uid},
+		},
+	}
+
+	testCases := []struct {
+		version     string
+		policy      registry.Fallback
+		wantAllowed bool
+	}{
+		{version: "v1", policy: registry.FallbackAllow, wantAllowed: true},
+		{version: "v1", policy: registry.FallbackDeny},
+		{version: "v1beta1", policy: registry.FallbackAllow, wantAllowed: true},
+		{version: "v1beta1", policy: registry.FallbackDeny},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.version+"/"+string(tc.policy), func(t *testing.T) {
+			name := "errorpolicy-panic-" + tc.version
+			before := handlerErrors(t, name, "panic", tc.policy)
+			// 与 WebhookStart 相同，panic 发生在 WithTimeout 启动的 goroutine 中
+			admit := WithErrorPolicy(name, tc.policy, WithTimeout(name, time.Second, registry.FallbackDeny,
+				testHandler(func(context.Context) *admissionv1.AdmissionResponse { panic("boom") })))
+
+			body, err := json.Marshal(reviews[tc.version])
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/"+name, bytes.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			Handler(admit).ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			var review reviewResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &review); err != nil {
+				t.Fatalf("failed to decode AdmissionReview: %v", err)
+			}
+			resp := review.Response
+			if resp.UID != uid {
+				t.Errorf("UID = %q, want %q", resp.UID, uid)
+			}
+			if resp.Allowed != tc.wantAllowed {
+				t.Errorf("Allowed = %v, want %v", resp.Allowed, tc.wantAllowed)
+			}
+			if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "panicked: boom") {
+				t.Errorf("Warnings = %q, want a degradation warning", resp.Warnings)
+			}
+			if !tc.wantAllowed && (resp.Result == nil || resp.Result.Code != http.StatusInternalServerError) {
+				t.Errorf("Result = %v, want code %d", resp.Result, http.StatusInternalServerError)
+			}
+			if got := handlerErrors(t, name, "panic", tc.policy); got != before+1 {
+				t.Errorf("webhook_handler_errors_total = %v, want %v", got, before+1)
+			}
+		})
+	}
+}
+
+func TestWithErrorPolicy(t *testing.T) {
+	testCases := []struct {
+		name        string
+		policy      registry.Fallback
+		resp        *admissionv1.AdmissionResponse
+		wantAllowed bool
+		wantReason  string
+	}{
+		{
+			name:        "allowed response is kept",
+			policy:      registry.FallbackDeny,
+			resp:        &admissionv1.AdmissionResponse{Allowed: true},
+			wantAllowed: true,
+		},
+		{
+			name:   "denied response is kept",
+			policy: registry.FallbackAllow,
+			resp:   &admissionv1.AdmissionResponse{Result: &metav1.Status{Code: http.StatusForbidden}},
+		},
+		{
+			name:        "internal error allowed",
+			policy:      registry.FallbackAllow,
+			resp:        setting.ToV1AdmissionResponse(errors.New("lookup failed")),
+			wantAllowed: true,
+			wantReason:  "error",
+		},
+		{
+			name:       "internal error denied",
+			policy:     registry.FallbackDeny,
+			resp:       setting.ToV1AdmissionResponse(errors.New("lookup failed")),
+			wantReason: "error",
+		},
+		{
+			name:        "no response allowed",
+			policy:      registry.FallbackAllow,
+			wantAllowed: true,
+			wantReason:  "error",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			name := "errorpolicy-" + strings.ReplaceAll(tc.name, " ", "-")
+			admit := WithErrorPolicy(name, tc.policy, testHandler(func(context.Context) *admissionv1.AdmissionResponse {
+				if tc.resp == nil {
+					return nil
+				}
+				copied := *tc.resp
+				return &copied
+			}))
+
+			for version, call := range map[string]func() (bool, []string){
+				"v1": func() (bool, []string) {
+					resp := admit.V1(context.Background(), admissionv1.AdmissionReview{})
+					return resp.Allowed, resp.Warnings
+				},
+				"v1beta1": func() (bool, []string) {
+					resp := admit.V1beta1(context.Background(), v1beta1.AdmissionReview{})
+					return resp.Allowed, resp.Warnings
+				},
+			} {
+				before := handlerErrors(t, name, tc.wantReason, tc.policy)
+				allowed, warnings := call()
+				if allowed != tc.wantAllowed {
+					t.Errorf("%s Allowed = %v, want %v", version, allowed, tc.wantAllowed)
+				}
+				if tc.wantReason == "" {
+					continue
+				}
+				if len(warnings) == 0 {
+					t.Errorf("%s has no degradation warning", version)
+				}
+				if got := handlerErrors(t, name, tc.wantReason, tc.policy); got != before+1 {
+					t.Errorf("%s webhook_handler_errors_total = %v, want %v", version, got, before+1)
+				}
+			}
+		})
+	}
+}
+
+// TestDispatchDegradedOnce 检查 webhook 按自己的错误策略降级后，分发路径外层的 WithErrorPolicy 不再重复处理
+func TestDispatchDegradedOnce(t *testing.T) {
+	for _, typ := range []registry.Type{registry.Mutating, registry.Validating} {
+		t.Run(string(typ), func(t *testing.T) {
+			inner, outer := "errorpolicy-inner-"+string(typ), "errorpolicy-dispatch-"+string(typ)
+			webhook := registry.Descriptor{Name: inner, Type: typ}
+			failing := WithErrorPolicy(inner, registry.FallbackDeny, testHandler(func(context.Context) *admissionv1.AdmissionResponse {
+				panic("boom")
+			}))
+			match := func(context.Context, *admissionv1.AdmissionRequest) ([]registry.Descriptor, error) {
+				return []registry.Descriptor{webhook}, nil
+			}
+			dispatch := WithErrorPolicy(outer, registry.FallbackAllow,
+				newDispatcher(typ, match, func(registry.Descriptor) setting.AdmitHandler { return failing }))
+
+			innerBefore := handlerErrors(t, inner, "panic", registry.FallbackDeny)
+			outerBefore := handlerErrors(t, outer, "error", registry.FallbackAllow)
+			resp := dispatch.V1(context.Background(), admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{
+				Object: runtime.RawExtension{Raw: []byte(`{"kind":"Pod"}`)},
+			}})
+
+			if resp.Allowed {
+				t.Errorf("Allowed = true, want the denial of the webhook error policy")
+			}
+			if len(resp.Warnings) != 1 {
+				t.Errorf("Warnings = %q, want only the warning of the webhook", resp.Warnings)
+			}
+			if got := handlerErrors(t, inner, "panic", registry.FallbackDeny); got != innerBefore+1 {
+				t.Errorf("webhook webhook_handler_errors_total = %v, want %v", got, innerBefore+1)
+			}
+			if got := handlerErrors(t, outer, "error", registry.FallbackAllow); got != outerBefore {
+				t.Errorf("dispatch webhook_handler_errors_total = %v, want %v", got, outerBefore)
+			}
+		})
+	}
+}
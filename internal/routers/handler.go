@@ -0,0 +1,45 @@
+package routers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/metrics"
+	"github.com/aloys.zy/aloys-webhook-example/internal/setting"
+	"github.com/aloys.zy/aloys-webhook-example/internal/tls"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// Handler 把 AdmitHandler 包装为 HTTP 处理函数
+func Handler(admit setting.AdmitHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, admit)
+	}
+}
+
+// WithClientCert 包装处理函数，在读取请求体之前校验调用方的客户端证书。
+// 校验只检查握手时已经验证的证书，开销很小，放在并发限制之前，未认证的调用方不会占用并发槽位和队列
+func WithClientCert(next http.HandlerFunc) http.HandlerFunc {
+	setupLog := ctrl.Log.WithName("server")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := tls.VerifyClientCertificate(r); err != nil {
+			var authErr *tls.ClientAuthError
+			reason, code := "client_auth_failed", http.StatusUnauthorized
+			if errors.As(err, &authErr) {
+				reason, code = authErr.Reason, authErr.Code
+			}
+			metrics.RecordAuthRejection(r.URL.Path, reason)
+			setupLog.Info("Rejected unauthenticated webhook caller",
+				"reason", reason,
+				"error", err.Error(),
+				"method", r.Method,
+				"url", r.URL.String(),
+				"remoteAddr", r.RemoteAddr,
+			)
+			http.Error(w, err.Error(), code)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,185 @@
+package routers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/metrics"
+	"github.com/aloys.zy/aloys-webhook-example/internal/setting"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// 失败策略与 webhook 配置中的 failurePolicy 对应，决定过载时放行还是拒绝请求
+const (
+	FailurePolicyFail   = "Fail"
+	FailurePolicyIgnore = "Ignore"
+)
+
+// Limiter 限制单个 endpoint 的并发请求数。超过并发数的请求排队等待，
+// 队列已满或等待超时的请求不再处理，按失败策略直接返回 AdmissionResponse。
+type Limiter struct {
+	path          string
+	slots         chan struct{}
+	maxQueue      int64
+	queued        atomic.Int64
+	queueTimeout  time.Duration
+	failurePolicy string
+}
+
+// NewLimiter 创建 Limiter，maxInflight <= 0 表示不限制并发
+func NewLimiter(path string, maxInflight, maxQueue int, queueTimeout time.Duration, failurePolicy string) (*Limiter, error) {
+	if failurePolicy != FailurePolicyFail && failurePolicy != FailurePolicyIgnore {
+		return nil, fmt.Errorf("invalid failure policy %q for %s, must be %s or %s",
+			failurePolicy, path, FailurePolicyFail, FailurePolicyIgnore)
+	}
+	l := &Limiter{
+		path:          path,
+		maxQueue:      int64(maxQueue),
+		queueTimeout:  queueTimeout,
+		failurePolicy: failurePolicy,
+	}
+	if maxInflight > 0 {
+		l.slots = make(chan struct{}, maxInflight)
+	}
+	return l, nil
+}
+
+// acquire 获取并发槽位，成功时返回释放函数，失败时返回原因
+func (l *Limiter) acquire(ctx context.Context) (func(), string) {
+	release := func() { <-l.slots }
+
+	select {
+	case l.slots <- struct{}{}:
+		return release, ""
+	default:
+	}
+
+	// 没有空闲槽位，进入队列等待
+	if l.queued.Add(1) > l.maxQueue {
+		l.queued.Add(-1)
+		return nil, "queue_full"
+	}
+	metrics.AddQueued(l.path, 1)
+	defer func() {
+		l.queued.Add(-1)
+		metrics.AddQueued(l.path, -1)
+	}()
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return release, ""
+	case <-timer.C:
+		return nil, "queue_timeout"
+	case <-ctx.Done():
+		return nil, "canceled"
+	}
+}
+
+// WithLimit 包装处理函数，获取到并发槽位后才调用处理函数
+func (l *Limiter) WithLimit(next http.HandlerFunc) http.HandlerFunc {
+	if l.slots == nil {
+		return next
+	}
+	setupLog := ctrl.Log.WithName("limiter")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		release, reason := l.acquire(r.Context())
+		if release == nil {
+			metrics.RecordShed(l.path, reason, l.failurePolicy)
+			setupLog.Info("Shedding webhook request",
+				"reason", reason,
+				"failurePolicy", l.failurePolicy,
+				"inflight", len(l.slots),
+				"queued", l.queued.Load(),
+				"url", r.URL.String(),
+				"remoteAddr", r.RemoteAddr,
+			)
+			shed(w, r, l.failurePolicy)
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	}
+}
+
+// shed 不调用处理函数，按失败策略返回合法的 AdmissionReview：
+// Ignore 放行并附带警告，Fail 拒绝并在状态中返回 429。API server 不会重试 webhook，
+// 拒绝的结果直接返回给发起请求的客户端，由客户端（比如 kubelet、控制器）按自己的逻辑重试
+func shed(w http.ResponseWriter, r *http.Request, failurePolicy string) {
+	setupLog := ctrl.Log.WithName("limiter")
+
+	allowed := failurePolicy == FailurePolicyIgnore
+	message := fmt.Sprintf("webhook %s is overloaded", r.URL.Path)
+	status := &metav1.Status{
+		Status:  metav1.StatusFailure,
+		Message: message,
+		Reason:  metav1.StatusReasonTooManyRequests,
+		Code:    http.StatusTooManyRequests,
+	}
+	var warnings []string
+	if allowed {
+		status = nil
+		warnings = []string{message + ", request allowed without admission"}
+	}
+
+	// 只解码请求，拿到 UID 和版本后直接返回，不做其他处理
+	var body []byte
+	if r.Body != nil {
+		body, _ = io.ReadAll(r.Body)
+	}
+	obj, gvk, err := setting.Codecs.UniversalDeserializer().Decode(body, nil, nil)
+	if err != nil {
+		setupLog.V(1).Info("Failed to decode shed request", "error", err.Error(), "url", r.URL.String())
+		http.Error(w, message, http.StatusTooManyRequests)
+		return
+	}
+
+	var responseObj runtime.Object
+	switch review := obj.(type) {
+	case *admissionv1.AdmissionReview:
+		if review.Request == nil {
+			http.Error(w, message, http.StatusTooManyRequests)
+			return
+		}
+		response := &admissionv1.AdmissionReview{
+			Response: &admissionv1.AdmissionResponse{
+				UID:      review.Request.UID,
+				Allowed:  allowed,
+				Result:   status,
+				Warnings: warnings,
+			},
+		}
+		response.SetGroupVersionKind(*gvk)
+		responseObj = response
+	case *v1beta1.AdmissionReview:
+		if review.Request == nil {
+			http.Error(w, message, http.StatusTooManyRequests)
+			return
+		}
+		response := &v1beta1.AdmissionReview{
+			Response: &v1beta1.AdmissionResponse{
+				UID:      review.Request.UID,
+				Allowed:  allowed,
+				Result:   status,
+				Warnings: warnings,
+			},
+		}
+		response.SetGroupVersionKind(*gvk)
+		responseObj = response
+	default:
+		http.Error(w, message, http.StatusTooManyRequests)
+		return
+	}
+
+	writeResponse(w, r, responseObj)
+}
@@ -1,11 +1,13 @@
 package routers
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"runtime/debug"
-	
+	"time"
+
 	"github.com/aloys.zy/aloys-webhook-example/internal/setting"
 	admissionv1 "k8s.io/api/admission/v1"
 	"k8s.io/api/admission/v1beta1"
@@ -14,6 +16,9 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
+// maxTimeoutMargin 是 API server 超时时间中留给返回响应的最长时间，超时较短时按 10% 预留
+const maxTimeoutMargin = time.Second
+
 // serve handles the HTTP portion of a request prior to handing to an admit function.
 func serve(w http.ResponseWriter, r *http.Request, admit setting.AdmitHandler) {
 	setupLog := ctrl.Log.WithName("server")
@@ -71,6 +76,10 @@ func serve(w http.ResponseWriter, r *http.Request, admit setting.AdmitHandler) {
 		return
 	}
 
+	// 处理函数使用的 ctx，截止时间比 API server 的超时稍短，超时前还有时间返回响应
+	ctx, cancel := admissionContext(r)
+	defer cancel()
+
 	var responseObj runtime.Object
 	// 处理不同API版本的请求
 	switch *gvk {
@@ -89,11 +98,16 @@ func serve(w http.ResponseWriter, r *http.Request, admit setting.AdmitHandler) {
 			http.Error(w, "Unexpected object type for v1beta1.AdmissionReview", http.StatusBadRequest)
 			return
 		}
+		// 没有 request 时无法返回带 UID 的响应
+		if requestedAdmissionReview.Request == nil {
+			http.Error(w, "AdmissionReview has no request", http.StatusBadRequest)
+			return
+		}
 
 		// 创建一个新的 v1beta1.AdmissionReview 对象作为响应。
 		responseAdmissionReview := &v1beta1.AdmissionReview{}
 		responseAdmissionReview.SetGroupVersionKind(*gvk)
-		responseAdmissionReview.Response = admit.V1beta1(*requestedAdmissionReview)
+		responseAdmissionReview.Response = admit.V1beta1(ctx, *requestedAdmissionReview)
 		responseAdmissionReview.Response.UID = requestedAdmissionReview.Request.UID
 		responseObj = responseAdmissionReview
 
@@ -112,11 +126,16 @@ func serve(w http.ResponseWriter, r *http.Request, admit setting.AdmitHandler) {
 			http.Error(w, "Unexpected object type for admissionv1.AdmissionReview", http.StatusBadRequest)
 			return
 		}
+		// 没有 request 时无法返回带 UID 的响应
+		if requestedAdmissionReview.Request == nil {
+			http.Error(w, "AdmissionReview has no request", http.StatusBadRequest)
+			return
+		}
 
 		// 创建一个新的 admissionv1.AdmissionReview 对象作为响应。
 		responseAdmissionReview := &admissionv1.AdmissionReview{}
 		responseAdmissionReview.SetGroupVersionKind(*gvk)
-		responseAdmissionReview.Response = admit.V1(*requestedAdmissionReview)
+		responseAdmissionReview.Response = admit.V1(ctx, *requestedAdmissionReview)
 		responseAdmissionReview.Response.UID = requestedAdmissionReview.Request.UID
 		responseObj = responseAdmissionReview
 
@@ -133,6 +152,24 @@ func serve(w http.ResponseWriter, r *http.Request, admit setting.AdmitHandler) {
 		return
 	}
 
+	writeResponse(w, r, responseObj)
+}
+
+// admissionContext 返回处理 AdmissionReview 使用的 ctx。API server 调用 webhook 时在 ?timeout= 中带上超时时间，
+// ctx 的截止时间比它少 timeoutMargin，留给序列化和返回响应；没有 timeout 参数时只使用请求的 ctx
+func admissionContext(r *http.Request) (context.Context, context.CancelFunc) {
+	timeout, err := time.ParseDuration(r.URL.Query().Get("timeout"))
+	if err != nil || timeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	margin := min(timeout/10, maxTimeoutMargin)
+	return context.WithTimeout(r.Context(), timeout-margin)
+}
+
+// writeResponse 把 AdmissionReview 序列化为 JSON 并写入 HTTP 响应
+func writeResponse(w http.ResponseWriter, r *http.Request, responseObj runtime.Object) {
+	setupLog := ctrl.Log.WithName("server")
+
 	// 将响应对象序列化为JSON格式
 	respBytes, err := json.Marshal(responseObj)
 	if err != nil {
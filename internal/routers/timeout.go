@@ -0,0 +1,197 @@
+package routers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/metrics"
+	"github.com/aloys.zy/aloys-webhook-example/internal/registry"
+	"github.com/aloys.zy/aloys-webhook-example/internal/setting"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// WithTimeout 包装 AdmitHandler，处理函数在 budget 内或 ctx 的截止时间前（取较早的）没有返回时，
+// 不再等待，按 fallback 返回放行或拒绝的响应，记录指标和处理函数的堆栈。
+// 超时的处理函数无法被中断，会在后台继续运行直到返回，之后的 panic 只记录日志和指标，处理函数应该使用 ctx 调用 API。
+func WithTimeout(name string, budget time.Duration, fallback registry.Fallback, admit setting.AdmitHandler) setting.AdmitHandler {
+	t := &timeout{name: name, budget: budget, fallback: fallback}
+	return setting.AdmitHandler{
+		V1: func(ctx context.Context, review admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+			return runWithTimeout(ctx, t, func(ctx context.Context) *admissionv1.AdmissionResponse {
+				return admit.V1(ctx, review)
+			}, t.fallbackV1)
+		},
+		V1beta1: func(ctx context.Context, review v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+			return runWithTimeout(ctx, t, func(ctx context.Context) *v1beta1.AdmissionResponse {
+				return admit.V1beta1(ctx, review)
+			}, func() *v1beta1.AdmissionResponse {
+				return setting.ConvertAdmissionResponseToV1beta1(t.fallbackV1())
+			})
+		},
+	}
+}
+
+type timeout struct {
+	name     string
+	budget   time.Duration
+	fallback registry.Fallback
+}
+
+// handlerPanic 把处理函数 goroutine 中的 panic 带回调用方，由 WithMetrics 统一恢复
+type handlerPanic struct {
+	value interface{}
+	stack []byte
+}
+
+func (p handlerPanic) String() string {
+	return fmt.Sprintf("%v\n%s", p.value, p.stack)
+}
+
+// runWithTimeout 在新的 goroutine 中调用处理函数，超时后返回 fallback 的响应
+func runWithTimeout[R any](ctx context.Context, t *timeout, call func(context.Context) *R, fallback func() *R) *R {
+	setupLog := ctrl.Log.WithName("server")
+
+	if t.budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.budget)
+		defer cancel()
+	}
+	// 没有预算也没有截止时间时不需要额外的 goroutine
+	if _, ok := ctx.Deadline(); !ok {
+		return call(ctx)
+	}
+
+	done := make(chan *R, 1)
+	panicked := make(chan handlerPanic, 1)
+	started := make(chan uint64, 1)
+	var mu sync.Mutex
+	timedOut := false
+	go func() {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			p := handlerPanic{value: r, stack: debug.Stack()}
+			mu.Lock()
+			late := timedOut
+			mu.Unlock()
+			if !late {
+				panicked <- p
+				return
+			}
+			// 已经返回了 fallback 的响应，没有调用方再恢复这个 panic，只记录下来
+			metrics.RecordHandlerError(t.name, "panic_after_timeout", string(t.fallback))
+			setupLog.Error(nil, "Admission handler panicked after the fallback response was returned",
+				"webhook", t.name,
+				"error", p.value,
+				"stacktrace", string(p.stack),
+			)
+		}()
+		started <- goroutineID()
+		done <- call(ctx)
+	}()
+	id := <-started
+
+	select {
+	case resp := <-done:
+		return resp
+	case p := <-panicked:
+		panic(p)
+	case <-ctx.Done():
+	}
+
+	mu.Lock()
+	timedOut = true
+	mu.Unlock()
+	// 超时的同时处理函数可能刚好返回或 panic，这时使用它的结果
+	select {
+	case resp := <-done:
+		return resp
+	case p := <-panicked:
+		panic(p)
+	default:
+	}
+
+	metrics.RecordHandlerTimeout(t.name, string(t.fallback))
+	setupLog.Error(ctx.Err(), "Admission handler ran out of time, returning the fallback response",
+		"webhook", t.name,
+		"budget", t.budget,
+		"fallback", t.fallback,
+		"stacktrace", goroutineStack(id),
+	)
+	return fallback()
+}
+
+// fallbackV1 返回超时后的响应：Allow 放行并附带警告，Deny 拒绝并说明超时
+func (t *timeout) fallbackV1() *admissionv1.AdmissionResponse {
+	message := fmt.Sprintf("webhook %s did not respond in time", t.name)
+	if t.fallback == registry.FallbackAllow {
+		return &admissionv1.AdmissionResponse{
+			Allowed:  true,
+			Warnings: []string{message + ", request allowed without admission"},
+		}
+	}
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: message,
+			Reason:  metav1.StatusReasonTimeout,
+			Code:    http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// goroutineID 从当前 goroutine 的堆栈第一行 "goroutine 123 [running]:" 解析 id
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		id, _ := strconv.ParseUint(string(buf[:i]), 10, 64)
+		return id
+	}
+	return 0
+}
+
+const (
+	// stackDumpInterval 是两次获取处理函数堆栈的最小间隔。只能通过所有 goroutine 的堆栈找到处理函数的堆栈，
+	// runtime.Stack(buf, true) 会暂停所有 goroutine，大量请求同时超时时只记录第一个
+	stackDumpInterval = time.Minute
+	// maxStackDumpSize 是所有 goroutine 堆栈的最大长度，超出的部分被截断
+	maxStackDumpSize = 256 << 10
+)
+
+// lastStackDump 是上一次获取堆栈的时间（UnixNano）
+var lastStackDump atomic.Int64
+
+// goroutineStack 返回指定 goroutine 当前的堆栈，用于定位卡住的处理函数，stackDumpInterval 内只获取一次
+func goroutineStack(id uint64) string {
+	now := time.Now().UnixNano()
+	last := lastStackDump.Load()
+	if last != 0 && now-last < int64(stackDumpInterval) || !lastStackDump.CompareAndSwap(last, now) {
+		return "omitted, stacks are dumped at most once per " + stackDumpInterval.String()
+	}
+
+	buf := make([]byte, maxStackDumpSize)
+	buf = buf[:runtime.Stack(buf, true)]
+	prefix := []byte("goroutine " + strconv.FormatUint(id, 10) + " ")
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(stack, prefix) {
+			return string(stack)
+		}
+	}
+	return "not found in the first " + strconv.Itoa(maxStackDumpSize) + " bytes of goroutine stacks"
+}
@@ -0,0 +1,181 @@
+package routers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/registry"
+	"github.com/aloys.zy/aloys-webhook-example/internal/setting"
+)
+
+// counterValue 返回默认注册表中计数器 name 在 labels 下的值
+func counterValue(t *testing.T, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if want, ok := labels[l.GetName()]; ok && want != l.GetValue() {
+					continue metrics
+				}
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+// testHandler 返回 V1 和 V1beta1 都调用 admit 的 AdmitHandler
+func testHandler(admit func(ctx context.Context) *admissionv1.AdmissionResponse) setting.AdmitHandler {
+	return setting.AdmitHandler{
+		V1: func(ctx context.Context, _ admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+			return admit(ctx)
+		},
+		V1beta1: func(ctx context.Context, _ v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+			return setting.ConvertAdmissionResponseToV1beta1(admit(ctx))
+		},
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	blocked := func(ctx context.Context) *admissionv1.AdmissionResponse {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	testCases := []struct {
+		name        string
+		fallback    registry.Fallback
+		admit       func(ctx context.Context) *admissionv1.AdmissionResponse
+		wantAllowed bool
+		wantCode    int32
+		wantTimeout bool
+	}{
+		{
+			name:     "handler within budget",
+			fallback: registry.FallbackDeny,
+			admit: func(context.Context) *admissionv1.AdmissionResponse {
+				return &admissionv1.AdmissionResponse{Allowed: true}
+			},
+			wantAllowed: true,
+		},
+		{
+			name:        "allow fallback",
+			fallback:    registry.FallbackAllow,
+			admit:       blocked,
+			wantAllowed: true,
+			wantTimeout: true,
+		},
+		{
+			name:        "deny fallback",
+			fallback:    registry.FallbackDeny,
+			admit:       blocked,
+			wantCode:    http.StatusGatewayTimeout,
+			wantTimeout: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			name := "timeout-" + strings.ReplaceAll(tc.name, " ", "-")
+			labels := map[string]string{"webhook": name, "fallback": string(tc.fallback)}
+			before := counterValue(t, "webhook_handler_timeouts_total", labels)
+			handler := WithTimeout(name, 20*time.Millisecond, tc.fallback, testHandler(tc.admit))
+
+			resp := handler.V1(context.Background(), admissionv1.AdmissionReview{})
+			if resp.Allowed != tc.wantAllowed {
+				t.Errorf("V1 Allowed = %v, want %v", resp.Allowed, tc.wantAllowed)
+			}
+			if tc.wantCode != 0 && (resp.Result == nil || resp.Result.Code != tc.wantCode) {
+				t.Errorf("V1 Result = %v, want code %d", resp.Result, tc.wantCode)
+			}
+			if tc.wantTimeout && tc.wantAllowed && len(resp.Warnings) == 0 {
+				t.Errorf("V1 allowed fallback has no warning")
+			}
+
+			beta := handler.V1beta1(context.Background(), v1beta1.AdmissionReview{})
+			if beta.Allowed != tc.wantAllowed {
+				t.Errorf("V1beta1 Allowed = %v, want %v", beta.Allowed, tc.wantAllowed)
+			}
+
+			want := before
+			if tc.wantTimeout {
+				want += 2
+			}
+			if got := counterValue(t, "webhook_handler_timeouts_total", labels); got != want {
+				t.Errorf("webhook_handler_timeouts_total = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestWithTimeoutPanic(t *testing.T) {
+	handler := WithTimeout("timeout-panic", time.Second, registry.FallbackDeny, testHandler(func(context.Context) *admissionv1.AdmissionResponse {
+		panic("boom")
+	}))
+
+	defer func() {
+		p, ok := recover().(handlerPanic)
+		if !ok {
+			t.Fatalf("recovered %T, want handlerPanic", p)
+		}
+		if p.value != "boom" || !strings.Contains(string(p.stack), "timeout_test.go") {
+			t.Errorf("handlerPanic = %v, want the value and stack of the handler goroutine", p)
+		}
+	}()
+	handler.V1(context.Background(), admissionv1.AdmissionReview{})
+	t.Fatal("V1 returned, want the panic to propagate")
+}
+
+func TestWithTimeoutLatePanic(t *testing.T) {
+	labels := map[string]string{"webhook": "timeout-late-panic", "reason": "panic_after_timeout"}
+	before := counterValue(t, "webhook_handler_errors_total", labels)
+	panicked := make(chan struct{})
+	handler := WithTimeout("timeout-late-panic", 10*time.Millisecond, registry.FallbackAllow, testHandler(func(ctx context.Context) *admissionv1.AdmissionResponse {
+		<-ctx.Done()
+		defer close(panicked)
+		time.Sleep(10 * time.Millisecond)
+		panic("late")
+	}))
+
+	if resp := handler.V1(context.Background(), admissionv1.AdmissionReview{}); !resp.Allowed {
+		t.Errorf("V1 Allowed = false, want the allow fallback")
+	}
+	<-panicked
+	// panic 在 close(panicked) 之后才被恢复并记录
+	deadline := time.Now().Add(time.Second)
+	for counterValue(t, "webhook_handler_errors_total", labels) != before+1 {
+		if time.Now().After(deadline) {
+			t.Fatal("panic after the fallback response was not recorded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestGoroutineStackRateLimit(t *testing.T) {
+	lastStackDump.Store(0)
+	t.Cleanup(func() { lastStackDump.Store(0) })
+
+	id := goroutineID()
+	if stack := goroutineStack(id); !strings.Contains(stack, "TestGoroutineStackRateLimit") {
+		t.Errorf("goroutineStack() = %q, want the stack of the current goroutine", stack)
+	}
+	if stack := goroutineStack(id); !strings.HasPrefix(stack, "omitted") {
+		t.Errorf("second goroutineStack() = %q, want it omitted", stack)
+	}
+}
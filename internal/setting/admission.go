@@ -1,6 +1,8 @@
 package setting
 
 import (
+	"context"
+
 	admissionv1 "k8s.io/api/admission/v1"
 	"k8s.io/api/admission/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1" // 确保导入 metav1 包
@@ -8,10 +10,11 @@ import (
 )
 
 // admitv1beta1Func handles a v1beta1 admission review.
-type admitv1beta1Func func(v1beta1.AdmissionReview) *v1beta1.AdmissionResponse
+// ctx 来自 HTTP 请求，截止时间比 API server 调用 webhook 的超时稍短，处理函数中调用 API 时应该使用它。
+type admitv1beta1Func func(context.Context, v1beta1.AdmissionReview) *v1beta1.AdmissionResponse
 
 // admitv1Func handles a v1 admission review.
-type admitv1Func func(admissionv1.AdmissionReview) *admissionv1.AdmissionResponse
+type admitv1Func func(context.Context, admissionv1.AdmissionReview) *admissionv1.AdmissionResponse
 
 // AdmitHandler is a handler, for both validators and mutators, that supports multiple admission review versions.
 type AdmitHandler struct {
@@ -32,7 +35,7 @@ func NewDelegateToV1AdmitHandler(f admitv1Func) AdmitHandler {
 func delegateV1beta1AdmitToV1(f admitv1Func) admitv1beta1Func {
 	setupLog := ctrl.Log.WithName("delegateV1beta1AdmitToV1")
 
-	return func(review v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+	return func(ctx context.Context, review v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
 		setupLog.Info("Received v1beta1 AdmissionReview", "UID", review.Request.UID)
 
 		// Convert v1beta1 request to v1
@@ -49,7 +52,7 @@ func delegateV1beta1AdmitToV1(f admitv1Func) admitv1beta1Func {
 		}
 
 		// Process the v1 request
-		v1Resp := f(admissionv1.AdmissionReview{Request: v1Req})
+		v1Resp := f(ctx, admissionv1.AdmissionReview{Request: v1Req})
 		if v1Resp == nil {
 			setupLog.V(1).Info("v1 handler returned a nil AdmissionResponse")
 			return &v1beta1.AdmissionResponse{
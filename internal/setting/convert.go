@@ -1,6 +1,8 @@
 package setting
 
 import (
+	"net/http"
+
 	admissionv1 "k8s.io/api/admission/v1"
 	admissionv1beta1 "k8s.io/api/admission/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -56,13 +58,24 @@ func ConvertAdmissionResponseToV1beta1(r *admissionv1.AdmissionResponse) *admiss
 }
 
 // ToV1AdmissionResponse creates a v1.AdmissionResponse from an error.
+// The error is treated as an internal error of the webhook and reported with code 500,
+// see IsInternalError.
 func ToV1AdmissionResponse(err error) *admissionv1.AdmissionResponse {
 	if err == nil {
 		return &admissionv1.AdmissionResponse{Allowed: true}
 	}
 	return &admissionv1.AdmissionResponse{
 		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
 			Message: err.Error(),
+			Reason:  metav1.StatusReasonInternalError,
+			Code:    http.StatusInternalServerError,
 		},
 	}
 }
+
+// IsInternalError reports whether the response was created by ToV1AdmissionResponse from an error,
+// as opposed to a deliberate denial.
+func IsInternalError(r *admissionv1.AdmissionResponse) bool {
+	return r != nil && !r.Allowed && r.Result != nil && r.Result.Code == http.StatusInternalServerError
+}
@@ -0,0 +1,330 @@
+package tls
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/configs"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// secret 中保存证书的 key，tls.crt/tls.key 与 cert-manager 生成的 secret 保持一致
+	secretCertKey   = corev1.TLSCertKey
+	secretKeyKey    = corev1.TLSPrivateKeyKey
+	secretCACertKey = "ca.crt"
+	secretCAKeyKey  = "ca.key"
+	// 轮换前的 CA 证书，过期前一直保留在 caBundle 中
+	secretPreviousCACertKey = "ca-previous.crt"
+
+	caValidity          = 10 * 365 * 24 * time.Hour
+	bootstrapCheckEvery = time.Hour
+	// 多副本同时写 Secret 冲突时重新读取的次数
+	secretSaveAttempts = 3
+)
+
+// CertBootstrapper 在没有 cert-manager 的集群中自签 CA 和服务端证书，保存到 Secret，
+// 写入证书文件供 CertWatcher 加载，并把 caBundle 注入到本服务的 webhook 配置中
+type CertBootstrapper struct {
+	client kubernetes.Interface
+
+	secretName       string
+	serviceName      string
+	serviceNamespace string
+	certFile         string
+	keyFile          string
+	validity         time.Duration
+	renewBefore      time.Duration
+
+	mu       sync.RWMutex
+	caBundle []byte
+}
+
+// NewCertBootstrapper 根据配置创建 CertBootstrapper
+func NewCertBootstrapper(client kubernetes.Interface, cfg *configs.Config) *CertBootstrapper {
+	return &CertBootstrapper{
+		client:           client,
+		secretName:       cfg.CertSecretName,
+		serviceName:      cfg.WebhookServiceName,
+		serviceNamespace: cfg.WebhookServiceNamespace,
+		certFile:         cfg.CertFile,
+		keyFile:          cfg.KeyFile,
+		validity:         cfg.CertValidity,
+		renewBefore:      cfg.CertRenewBefore,
+	}
+}
+
+// CABundle 返回当前使用的 CA 证书（PEM）
+func (b *CertBootstrapper) CABundle() []byte {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.caBundle
+}
+
+// Start 定期检查证书是否即将过期，需要时重新签发，直到 ctx 结束
+func (b *CertBootstrapper) Start(ctx context.Context) error {
+	setupLog := ctrl.Log.WithName("cert-bootstrap")
+
+	ticker := time.NewTicker(bootstrapCheckEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := b.Bootstrap(ctx); err != nil {
+				setupLog.Error(err, "Failed to refresh self-signed certificates")
+			}
+		}
+	}
+}
+
+// Bootstrap 确保 Secret 中有有效的证书，写入证书文件，并注入 caBundle
+func (b *CertBootstrapper) Bootstrap(ctx context.Context) error {
+	setupLog := ctrl.Log.WithName("cert-bootstrap")
+
+	ca, serving, caBundle, err := b.ensureSecret(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := b.writeFiles(serving); err != nil {
+		return err
+	}
+	// 证书文件更新后立即重新加载，不等待下一次轮询
+	if w := GetCertWatcher(); w != nil {
+		if err := w.ReadCertificate(); err != nil {
+			return fmt.Errorf("failed to reload bootstrapped certificate: %w", err)
+		}
+	}
+
+	b.mu.Lock()
+	b.caBundle = caBundle
+	b.mu.Unlock()
+
+	if err := b.injectCABundle(ctx, caBundle); err != nil {
+		return err
+	}
+
+	setupLog.Info("Self-signed certificates are ready",
+		"secret", b.serviceNamespace+"/"+b.secretName,
+		"notAfter", serving.Cert.NotAfter,
+		"caNotAfter", ca.Cert.NotAfter)
+	return nil
+}
+
+// dnsNames 返回 webhook Service 的 DNS 名称
+func (b *CertBootstrapper) dnsNames() []string {
+	return []string{
+		b.serviceName,
+		fmt.Sprintf("%s.%s", b.serviceName, b.serviceNamespace),
+		fmt.Sprintf("%s.%s.svc", b.serviceName, b.serviceNamespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", b.serviceName, b.serviceNamespace),
+	}
+}
+
+// ensureSecret 读取 Secret 中的证书，不存在或即将过期时重新签发并写回 Secret。
+// 多副本同时签发时只有一个能写入成功，其余副本重新读取 Secret，使用写入成功的证书。
+// 返回的 caBundle 包含当前的 CA 和轮换前仍未过期的 CA
+func (b *CertBootstrapper) ensureSecret(ctx context.Context) (ca, serving *keyPair, caBundle []byte, err error) {
+	setupLog := ctrl.Log.WithName("cert-bootstrap")
+
+	for attempt := 1; ; attempt++ {
+		ca, serving, caBundle, err = b.syncSecret(ctx)
+		if attempt < secretSaveAttempts && (apierrors.IsAlreadyExists(err) || apierrors.IsConflict(err)) {
+			setupLog.Info("Secret was written by another replica, reading it again", "secret", b.serviceNamespace+"/"+b.secretName, "error", err.Error())
+			continue
+		}
+		return ca, serving, caBundle, err
+	}
+}
+
+// syncSecret 读取 Secret，需要时签发新的证书并写回，写入冲突时返回 AlreadyExists 或 Conflict 错误
+func (b *CertBootstrapper) syncSecret(ctx context.Context) (*keyPair, *keyPair, []byte, error) {
+	setupLog := ctrl.Log.WithName("cert-bootstrap")
+	secrets := b.client.CoreV1().Secrets(b.serviceNamespace)
+
+	secret, err := secrets.Get(ctx, b.secretName, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, nil, nil, fmt.Errorf("failed to get secret %s/%s: %w", b.serviceNamespace, b.secretName, err)
+	}
+	exists := err == nil
+
+	var ca, serving *keyPair
+	var previousCA []byte
+	if exists {
+		ca, _ = parseKeyPair(secret.Data[secretCACertKey], secret.Data[secretCAKeyKey])
+		serving, _ = parseKeyPair(secret.Data[secretCertKey], secret.Data[secretKeyKey])
+		previousCA = secret.Data[secretPreviousCACertKey]
+	}
+
+	renewCA := ca == nil || b.expiring(ca)
+	renewServing := renewCA || serving == nil || b.expiring(serving) || serving.Cert.CheckSignatureFrom(ca.Cert) != nil
+	if !renewServing {
+		return ca, serving, caBundleOf(ca, previousCA), nil
+	}
+
+	if renewCA {
+		// 其他副本重新加载证书之前仍在使用旧 CA 签发的证书，旧 CA 过期前保留在 caBundle 中
+		previousCA = nil
+		if ca != nil {
+			previousCA = ca.CertPEM
+		}
+		setupLog.Info("Generating self-signed CA", "secret", b.serviceNamespace+"/"+b.secretName)
+		if ca, err = generateCA(fmt.Sprintf("%s-ca", b.serviceName), caValidity); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	setupLog.Info("Issuing serving certificate", "dnsNames", b.dnsNames())
+	if serving, err = generateServingCert(ca, b.dnsNames(), b.validity); err != nil {
+		return nil, nil, nil, err
+	}
+
+	data := map[string][]byte{
+		secretCertKey:   serving.CertPEM,
+		secretKeyKey:    serving.KeyPEM,
+		secretCACertKey: ca.CertPEM,
+		secretCAKeyKey:  ca.KeyPEM,
+	}
+	if unexpired(previousCA) {
+		data[secretPreviousCACertKey] = previousCA
+	}
+	if exists {
+		secret.Type = corev1.SecretTypeTLS
+		secret.Data = data
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	} else {
+		_, err = secrets.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: b.secretName, Namespace: b.serviceNamespace},
+			Type:       corev1.SecretTypeTLS,
+			Data:       data,
+		}, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to save certificates to secret %s/%s: %w", b.serviceNamespace, b.secretName, err)
+	}
+	return ca, serving, caBundleOf(ca, previousCA), nil
+}
+
+// caBundleOf 返回当前 CA 和仍未过期的旧 CA 组成的 caBundle
+func caBundleOf(ca *keyPair, previousCA []byte) []byte {
+	bundle := append([]byte(nil), ca.CertPEM...)
+	if unexpired(previousCA) {
+		bundle = append(bundle, previousCA...)
+	}
+	return bundle
+}
+
+// unexpired 判断 PEM 编码的证书是否有效且未过期
+func unexpired(certPEM []byte) bool {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	return err == nil && time.Now().Before(cert.NotAfter)
+}
+
+// expiring 判断证书是否在 renewBefore 时间内过期
+func (b *CertBootstrapper) expiring(kp *keyPair) bool {
+	return time.Now().Add(b.renewBefore).After(kp.Cert.NotAfter)
+}
+
+// writeFiles 把服务端证书写入 --tls-cert-file/--tls-private-key-file 指定的路径
+func (b *CertBootstrapper) writeFiles(serving *keyPair) error {
+	files := []struct {
+		path string
+		data []byte
+		mode os.FileMode
+	}{
+		{b.certFile, serving.CertPEM, 0o644},
+		{b.keyFile, serving.KeyPEM, 0o600},
+	}
+	for _, f := range files {
+		if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+			return fmt.Errorf("failed to create certificate directory: %w", err)
+		}
+		existing, err := os.ReadFile(f.path)
+		if err == nil && bytes.Equal(existing, f.data) {
+			continue
+		}
+		// 先写临时文件再 rename，避免 CertWatcher 读到写了一半的文件
+		tmp := f.path + ".tmp"
+		if err := os.WriteFile(tmp, f.data, f.mode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", tmp, err)
+		}
+		if err := os.Rename(tmp, f.path); err != nil {
+			return fmt.Errorf("failed to rename %s: %w", tmp, err)
+		}
+	}
+	return nil
+}
+
+// injectCABundle 把 caBundle 写入所有指向本服务的 Mutating/ValidatingWebhookConfiguration
+func (b *CertBootstrapper) injectCABundle(ctx context.Context, caBundle []byte) error {
+	setupLog := ctrl.Log.WithName("cert-bootstrap")
+	admissionClient := b.client.AdmissionregistrationV1()
+
+	mutatingList, err := admissionClient.MutatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list MutatingWebhookConfigurations: %w", err)
+	}
+	for i := range mutatingList.Items {
+		mwc := &mutatingList.Items[i]
+		changed := false
+		for j := range mwc.Webhooks {
+			if b.ownsService(mwc.Webhooks[j].ClientConfig.Service) && !bytes.Equal(mwc.Webhooks[j].ClientConfig.CABundle, caBundle) {
+				mwc.Webhooks[j].ClientConfig.CABundle = caBundle
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		if _, err := admissionClient.MutatingWebhookConfigurations().Update(ctx, mwc, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to inject caBundle into MutatingWebhookConfiguration %s: %w", mwc.Name, err)
+		}
+		setupLog.Info("Injected caBundle", "MutatingWebhookConfiguration", mwc.Name)
+	}
+
+	validatingList, err := admissionClient.ValidatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list ValidatingWebhookConfigurations: %w", err)
+	}
+	for i := range validatingList.Items {
+		vwc := &validatingList.Items[i]
+		changed := false
+		for j := range vwc.Webhooks {
+			if b.ownsService(vwc.Webhooks[j].ClientConfig.Service) && !bytes.Equal(vwc.Webhooks[j].ClientConfig.CABundle, caBundle) {
+				vwc.Webhooks[j].ClientConfig.CABundle = caBundle
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		if _, err := admissionClient.ValidatingWebhookConfigurations().Update(ctx, vwc, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to inject caBundle into ValidatingWebhookConfiguration %s: %w", vwc.Name, err)
+		}
+		setupLog.Info("Injected caBundle", "ValidatingWebhookConfiguration", vwc.Name)
+	}
+	return nil
+}
+
+// ownsService 判断 webhook 是否指向本服务
+func (b *CertBootstrapper) ownsService(svc *admissionregistrationv1.ServiceReference) bool {
+	return svc != nil && svc.Name == b.serviceName && svc.Namespace == b.serviceNamespace
+}
@@ -0,0 +1,209 @@
+package tls
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newTestBootstrapper(client *fake.Clientset) *CertBootstrapper {
+	return &CertBootstrapper{
+		client:           client,
+		secretName:       "webhook-server-cert",
+		serviceName:      "webhook-service",
+		serviceNamespace: "system",
+		validity:         365 * 24 * time.Hour,
+		renewBefore:      30 * 24 * time.Hour,
+	}
+}
+
+// testSecret 返回保存 ca 和 ca 签发的服务端证书的 Secret
+func testSecret(t *testing.T, b *CertBootstrapper, ca *keyPair) *corev1.Secret {
+	t.Helper()
+	serving, err := generateServingCert(ca, b.dnsNames(), b.validity)
+	if err != nil {
+		t.Fatalf("generateServingCert() error = %v", err)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: b.secretName, Namespace: b.serviceNamespace},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			secretCertKey:   serving.CertPEM,
+			secretKeyKey:    serving.KeyPEM,
+			secretCACertKey: ca.CertPEM,
+			secretCAKeyKey:  ca.KeyPEM,
+		},
+	}
+}
+
+func mustGenerateCA(t *testing.T, validity time.Duration) *keyPair {
+	t.Helper()
+	ca, err := generateCA("test-ca", validity)
+	if err != nil {
+		t.Fatalf("generateCA() error = %v", err)
+	}
+	return ca
+}
+
+func TestEnsureSecret(t *testing.T) {
+	secretsResource := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+	testCases := []struct {
+		name string
+		// setup 准备 Secret 和模拟其他副本的写入，返回期望使用的 Secret，为 nil 时只检查签发了新证书
+		setup      func(t *testing.T, b *CertBootstrapper, client *fake.Clientset) *corev1.Secret
+		wantBundle int
+	}{
+		{
+			name:       "creates the secret",
+			setup:      func(*testing.T, *CertBootstrapper, *fake.Clientset) *corev1.Secret { return nil },
+			wantBundle: 1,
+		},
+		{
+			name: "reuses valid certificates",
+			setup: func(t *testing.T, b *CertBootstrapper, client *fake.Clientset) *corev1.Secret {
+				secret := testSecret(t, b, mustGenerateCA(t, caValidity))
+				if err := client.Tracker().Add(secret); err != nil {
+					t.Fatal(err)
+				}
+				return secret
+			},
+			wantBundle: 1,
+		},
+		{
+			name: "another replica created the secret first",
+			setup: func(t *testing.T, b *CertBootstrapper, client *fake.Clientset) *corev1.Secret {
+				winner := testSecret(t, b, mustGenerateCA(t, caValidity))
+				client.PrependReactor("create", "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+					if err := client.Tracker().Add(winner); err != nil {
+						t.Fatal(err)
+					}
+					return true, nil, apierrors.NewAlreadyExists(secretsResource.GroupResource(), winner.Name)
+				})
+				return winner
+			},
+			wantBundle: 1,
+		},
+		{
+			name: "another replica renewed the secret first",
+			setup: func(t *testing.T, b *CertBootstrapper, client *fake.Clientset) *corev1.Secret {
+				// 即将过期的 CA 需要轮换
+				if err := client.Tracker().Add(testSecret(t, b, mustGenerateCA(t, 24*time.Hour))); err != nil {
+					t.Fatal(err)
+				}
+				winner := testSecret(t, b, mustGenerateCA(t, caValidity))
+				client.PrependReactor("update", "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+					if err := client.Tracker().Update(secretsResource, winner, winner.Namespace); err != nil {
+						t.Fatal(err)
+					}
+					return true, nil, apierrors.NewConflict(secretsResource.GroupResource(), winner.Name, nil)
+				})
+				return winner
+			},
+			wantBundle: 1,
+		},
+		{
+			name: "rotated CA keeps the previous CA in the bundle",
+			setup: func(t *testing.T, b *CertBootstrapper, client *fake.Clientset) *corev1.Secret {
+				if err := client.Tracker().Add(testSecret(t, b, mustGenerateCA(t, 24*time.Hour))); err != nil {
+					t.Fatal(err)
+				}
+				return nil
+			},
+			wantBundle: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			b := newTestBootstrapper(client)
+			want := tc.setup(t, b, client)
+
+			ca, serving, caBundle, err := b.ensureSecret(context.Background())
+			if err != nil {
+				t.Fatalf("ensureSecret() error = %v", err)
+			}
+			if err := serving.Cert.CheckSignatureFrom(ca.Cert); err != nil {
+				t.Errorf("serving certificate is not signed by the CA: %v", err)
+			}
+			if want != nil && !bytes.Equal(serving.CertPEM, want.Data[secretCertKey]) {
+				t.Errorf("ensureSecret() issued a new certificate, want the one in the secret")
+			}
+			if got := bytes.Count(caBundle, []byte("BEGIN CERTIFICATE")); got != tc.wantBundle {
+				t.Errorf("caBundle has %d certificates, want %d", got, tc.wantBundle)
+			}
+			if !bytes.HasPrefix(caBundle, ca.CertPEM) {
+				t.Errorf("caBundle does not start with the current CA")
+			}
+
+			stored, err := client.CoreV1().Secrets(b.serviceNamespace).Get(context.Background(), b.secretName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get secret: %v", err)
+			}
+			if !bytes.Equal(stored.Data[secretCertKey], serving.CertPEM) {
+				t.Errorf("secret does not hold the returned serving certificate")
+			}
+		})
+	}
+}
+
+func TestInjectCABundle(t *testing.T) {
+	service := func(name string) admissionregistrationv1.WebhookClientConfig {
+		return admissionregistrationv1.WebhookClientConfig{
+			Service:  &admissionregistrationv1.ServiceReference{Name: name, Namespace: "system"},
+			CABundle: []byte("old"),
+		}
+	}
+	client := fake.NewSimpleClientset(
+		&admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "owned"},
+			Webhooks: []admissionregistrationv1.MutatingWebhook{
+				{Name: "a.kb.io", ClientConfig: service("webhook-service")},
+				{Name: "b.kb.io", ClientConfig: service("other-service")},
+			},
+		},
+		&admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "other"},
+			Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "c.kb.io", ClientConfig: service("other-service")}},
+		},
+		&admissionregistrationv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "owned"},
+			Webhooks:   []admissionregistrationv1.ValidatingWebhook{{Name: "d.kb.io", ClientConfig: service("webhook-service")}},
+		},
+	)
+	b := newTestBootstrapper(client)
+	bundle := []byte("new-ca\nprevious-ca\n")
+
+	if err := b.injectCABundle(context.Background(), bundle); err != nil {
+		t.Fatalf("injectCABundle() error = %v", err)
+	}
+
+	ctx := context.Background()
+	mutating := client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	owned, _ := mutating.Get(ctx, "owned", metav1.GetOptions{})
+	if got := string(owned.Webhooks[0].ClientConfig.CABundle); got != string(bundle) {
+		t.Errorf("owned webhook caBundle = %q, want %q", got, bundle)
+	}
+	if got := string(owned.Webhooks[1].ClientConfig.CABundle); got != "old" {
+		t.Errorf("webhook of another service caBundle = %q, want unchanged", got)
+	}
+	other, _ := mutating.Get(ctx, "other", metav1.GetOptions{})
+	if got := string(other.Webhooks[0].ClientConfig.CABundle); got != "old" {
+		t.Errorf("configuration of another service caBundle = %q, want unchanged", got)
+	}
+	validating, _ := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, "owned", metav1.GetOptions{})
+	if got := string(validating.Webhooks[0].ClientConfig.CABundle); got != string(bundle) {
+		t.Errorf("validating webhook caBundle = %q, want %q", got, bundle)
+	}
+}
@@ -0,0 +1,151 @@
+package tls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aloys.zy/aloys-webhook-example/internal/metrics"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// CertWatcher 轮询证书和私钥文件，文件变化后重新加载，并通过 GetCertificate 提供给 tls.Config。
+// 重新加载失败时继续使用上一次加载成功的证书。
+type CertWatcher struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	notAfter time.Time
+	certMod  time.Time
+	keyMod   time.Time
+}
+
+// NewCertWatcher 创建 CertWatcher 并立即加载一次证书，首次加载失败只记录日志，之后轮询时会继续重试
+func NewCertWatcher(certFile, keyFile string, interval time.Duration) *CertWatcher {
+	w := &CertWatcher{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+	}
+	if err := w.ReadCertificate(); err != nil {
+		ctrl.Log.WithName("cert-watcher").Error(err, "Failed to load TLS certificate and private key",
+			"CertFile", certFile, "KeyFile", keyFile)
+	}
+	return w
+}
+
+// GetCertificate 返回当前的证书，用于 tls.Config.GetCertificate
+func (w *CertWatcher) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.cert == nil {
+		return nil, fmt.Errorf("no TLS certificate loaded from %s", w.certFile)
+	}
+	return w.cert, nil
+}
+
+// ReadCertificate 从文件读取证书和私钥，成功后替换当前证书
+func (w *CertWatcher) ReadCertificate() error {
+	setupLog := ctrl.Log.WithName("cert-watcher")
+
+	certMod, keyMod, err := w.modTimes()
+	if err != nil {
+		metrics.RecordCertReload(false)
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(w.certFile, w.keyFile)
+	if err != nil {
+		metrics.RecordCertReload(false)
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		metrics.RecordCertReload(false)
+		return fmt.Errorf("failed to parse TLS certificate: %w", err)
+	}
+
+	w.mu.Lock()
+	w.cert = &cert
+	w.notAfter = leaf.NotAfter
+	w.certMod = certMod
+	w.keyMod = keyMod
+	w.mu.Unlock()
+
+	metrics.RecordCertReload(true)
+	metrics.SetCertExpiry(leaf.NotAfter)
+	setupLog.Info("TLS certificate and private key loaded successfully",
+		"CertFile", w.certFile, "KeyFile", w.keyFile, "notAfter", leaf.NotAfter)
+	return nil
+}
+
+// Check 检查当前是否有可用的证书，没有加载成功或已经过期时返回错误
+func (w *CertWatcher) Check() error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.cert == nil {
+		return fmt.Errorf("no TLS certificate loaded from %s", w.certFile)
+	}
+	if time.Now().After(w.notAfter) {
+		return fmt.Errorf("TLS certificate %s expired at %s", w.certFile, w.notAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Start 按 interval 轮询文件的修改时间，直到 ctx 结束
+func (w *CertWatcher) Start(ctx context.Context) error {
+	setupLog := ctrl.Log.WithName("cert-watcher")
+	setupLog.Info("Starting certificate watcher", "CertFile", w.certFile, "KeyFile", w.keyFile, "interval", w.interval)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			setupLog.Info("Stopping certificate watcher")
+			return nil
+		case <-ticker.C:
+			if !w.changed() {
+				continue
+			}
+			if err := w.ReadCertificate(); err != nil {
+				setupLog.Error(err, "Failed to reload TLS certificate, keep serving the previous one",
+					"CertFile", w.certFile, "KeyFile", w.keyFile)
+			}
+		}
+	}
+}
+
+// changed 判断证书或私钥文件是否发生了变化，之前没有加载成功的情况也视为变化以便重试
+func (w *CertWatcher) changed() bool {
+	certMod, keyMod, err := w.modTimes()
+	if err != nil {
+		// 文件暂时不可读（比如 secret 正在切换软链接），等下一个周期再看
+		return false
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.cert == nil || !certMod.Equal(w.certMod) || !keyMod.Equal(w.keyMod)
+}
+
+// modTimes 返回证书和私钥文件的修改时间，os.Stat 会跟随 secret 挂载的软链接
+func (w *CertWatcher) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(w.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	keyInfo, err := os.Stat(w.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
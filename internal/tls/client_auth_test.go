@@ -0,0 +1,206 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeClientCA 生成客户端 CA 并写入临时文件，返回 CA 和文件路径
+func writeClientCA(t *testing.T) (*keyPair, string) {
+	t.Helper()
+	ca := mustGenerateCA(t, time.Hour)
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(caFile, ca.CertPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return ca, caFile
+}
+
+func TestConfigClientAuth(t *testing.T) {
+	_, caFile := writeClientCA(t)
+	defer func() { verifier = &clientVerifier{mode: ClientAuthOff} }()
+
+	testCases := []struct {
+		mode       string
+		caFile     string
+		wantAuth   tls.ClientAuthType
+		wantClient bool
+		wantErr    bool
+	}{
+		{mode: "", wantAuth: tls.NoClientCert},
+		{mode: ClientAuthOff, wantAuth: tls.NoClientCert},
+		{mode: ClientAuthOptional, caFile: caFile, wantAuth: tls.VerifyClientCertIfGiven, wantClient: true},
+		// 缺少证书的请求在 VerifyClientCertificate 中拒绝，而不是在握手时
+		{mode: ClientAuthRequired, caFile: caFile, wantAuth: tls.VerifyClientCertIfGiven, wantClient: true},
+		{mode: ClientAuthRequired, wantErr: true},
+		{mode: "strict", caFile: caFile, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.mode, func(t *testing.T) {
+			tlsConfig := &tls.Config{}
+			err := configClientAuth(tlsConfig, tc.mode, tc.caFile, nil)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("configClientAuth() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if tlsConfig.ClientAuth != tc.wantAuth {
+				t.Errorf("ClientAuth = %v, want %v", tlsConfig.ClientAuth, tc.wantAuth)
+			}
+			if (tlsConfig.ClientCAs != nil) != tc.wantClient {
+				t.Errorf("ClientCAs set = %v, want %v", tlsConfig.ClientCAs != nil, tc.wantClient)
+			}
+		})
+	}
+}
+
+func TestVerifyClientCertificate(t *testing.T) {
+	ca, caFile := writeClientCA(t)
+	defer func() { verifier = &clientVerifier{mode: ClientAuthOff} }()
+
+	apiserver, err := generateClientCert(ca, "kube-apiserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := generateClientCert(ca, "other-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name         string
+		mode         string
+		allowedNames []string
+		cert         *x509.Certificate
+		wantReason   string
+	}{
+		{name: "off without certificate", mode: ClientAuthOff},
+		{name: "optional without certificate", mode: ClientAuthOptional},
+		{name: "optional with certificate", mode: ClientAuthOptional, cert: other},
+		{name: "required without certificate", mode: ClientAuthRequired, wantReason: "no_client_certificate"},
+		{name: "required with certificate", mode: ClientAuthRequired, cert: other},
+		{name: "allowed name", mode: ClientAuthRequired, allowedNames: []string{"kube-apiserver"}, cert: apiserver},
+		{name: "name not allowed", mode: ClientAuthRequired, allowedNames: []string{"kube-apiserver"}, cert: other, wantReason: "client_not_allowed"},
+		{name: "optional name not allowed", mode: ClientAuthOptional, allowedNames: []string{"kube-apiserver"}, cert: other, wantReason: "client_not_allowed"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := configClientAuth(&tls.Config{}, tc.mode, caFile, tc.allowedNames); err != nil {
+				t.Fatalf("configClientAuth() error = %v", err)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/mutating-pod-dns", nil)
+			r.TLS = &tls.ConnectionState{}
+			if tc.cert != nil {
+				r.TLS.PeerCertificates = []*x509.Certificate{tc.cert}
+			}
+
+			err := VerifyClientCertificate(r)
+			var authErr *ClientAuthError
+			switch {
+			case tc.wantReason == "" && err != nil:
+				t.Errorf("VerifyClientCertificate() error = %v, want nil", err)
+			case tc.wantReason != "" && (!errors.As(err, &authErr) || authErr.Reason != tc.wantReason):
+				t.Errorf("VerifyClientCertificate() error = %v, want reason %s", err, tc.wantReason)
+			}
+		})
+	}
+}
+
+// TestRequiredClientAuthHandshake 检查 required 模式下没有证书的连接能完成握手，由 VerifyClientCertificate 拒绝
+func TestRequiredClientAuthHandshake(t *testing.T) {
+	ca, caFile := writeClientCA(t)
+	defer func() { verifier = &clientVerifier{mode: ClientAuthOff} }()
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := VerifyClientCertificate(r); err != nil {
+			var authErr *ClientAuthError
+			errors.As(err, &authErr)
+			http.Error(w, authErr.Reason, authErr.Code)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = &tls.Config{}
+	if err := configClientAuth(srv.TLS, ClientAuthRequired, caFile, nil); err != nil {
+		t.Fatalf("configClientAuth() error = %v", err)
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	// 每个请求使用自己的 Transport 和 tls.Config，不复用连接，也不恢复之前没有客户端证书的 TLS 会话
+	get := func(certificates ...tls.Certificate) int {
+		t.Helper()
+		transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certificates}}
+		defer transport.CloseIdleConnections()
+		resp, err := (&http.Client{Transport: transport}).Get(srv.URL)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := get(); code != http.StatusUnauthorized {
+		t.Errorf("status without client certificate = %d, want %d", code, http.StatusUnauthorized)
+	}
+	client, err := generateClientKeyPair(ca, "kube-apiserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := get(client); code != http.StatusOK {
+		t.Errorf("status with client certificate = %d, want %d", code, http.StatusOK)
+	}
+}
+
+// generateClientKeyPair 使用 ca 签发客户端证书
+func generateClientKeyPair(ca *keyPair, commonName string) (tls.Certificate, error) {
+	kp, err := signClientCert(ca, commonName)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(kp.CertPEM, kp.KeyPEM)
+}
+
+func generateClientCert(ca *keyPair, commonName string) (*x509.Certificate, error) {
+	kp, err := signClientCert(ca, commonName)
+	if err != nil {
+		return nil, err
+	}
+	return kp.Cert, nil
+}
+
+func signClientCert(ca *keyPair, commonName string) (*keyPair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	return signKeyPair(tmpl, ca.Cert, key, ca.Key)
+}
@@ -0,0 +1,108 @@
+package tls
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// TLS 安全配置档，参考 Mozilla Server Side TLS 推荐配置
+const (
+	ProfileOld          = "Old"
+	ProfileIntermediate = "Intermediate"
+	ProfileModern       = "Modern"
+	ProfileCustom       = "Custom"
+)
+
+// tlsProfile 描述一个配置档的最低 TLS 版本和允许的 cipher suites（仅对 TLS 1.2 及以下生效）
+type tlsProfile struct {
+	minVersion   uint16
+	cipherSuites []uint16
+}
+
+var tlsProfiles = map[string]tlsProfile{
+	ProfileOld: {
+		minVersion: tls.VersionTLS10,
+		cipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+			tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+		},
+	},
+	ProfileIntermediate: {
+		minVersion: tls.VersionTLS12,
+		cipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+	},
+	ProfileModern: {
+		// TLS 1.3 的 cipher suites 由 Go 固定，不可配置
+		minVersion: tls.VersionTLS13,
+	},
+}
+
+var tlsVersions = map[string]uint16{
+	"VersionTLS10": tls.VersionTLS10,
+	"VersionTLS11": tls.VersionTLS11,
+	"VersionTLS12": tls.VersionTLS12,
+	"VersionTLS13": tls.VersionTLS13,
+}
+
+// applyProfile 把配置档的最低版本和 cipher suites 写入 tls.Config，
+// Custom 配置档使用 minVersion 和 cipherSuites 参数
+func applyProfile(tlsConfig *tls.Config, profile, minVersion string, cipherSuites []string) error {
+	if profile != ProfileCustom {
+		p, ok := tlsProfiles[profile]
+		if !ok {
+			return fmt.Errorf("unknown TLS profile %q, must be one of Old, Intermediate, Modern, Custom", profile)
+		}
+		tlsConfig.MinVersion = p.minVersion
+		tlsConfig.CipherSuites = p.cipherSuites
+		return nil
+	}
+
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return fmt.Errorf("unknown TLS version %q, must be one of VersionTLS10, VersionTLS11, VersionTLS12, VersionTLS13", minVersion)
+	}
+	tlsConfig.MinVersion = version
+
+	if len(cipherSuites) == 0 {
+		return nil
+	}
+	ids := make(map[string]uint16)
+	for _, c := range tls.CipherSuites() {
+		ids[c.Name] = c.ID
+	}
+	for _, c := range tls.InsecureCipherSuites() {
+		ids[c.Name] = c.ID
+	}
+	for _, name := range cipherSuites {
+		id, ok := ids[strings.TrimSpace(name)]
+		if !ok {
+			return fmt.Errorf("unknown TLS cipher suite %q", name)
+		}
+		tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+	}
+	return nil
+}
@@ -3,31 +3,69 @@ package tls
 import (
 	"crypto/tls"
 
+	"github.com/aloys.zy/aloys-webhook-example/internal/configs"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
-func ConfigTLS() *tls.Config {
+var certWatcher *CertWatcher
+
+// InitCertWatcher 根据配置创建全局的 CertWatcher，需要在 ConfigTLS 之前调用
+func InitCertWatcher(cfg *configs.Config) {
 	setupLog := ctrl.Log.WithName("config-tls")
 
 	// Log the paths of the certificate and key files
 	setupLog.V(1).Info("Loading TLS certificate and private key from files",
-		"CertFile", "./certs/tls.crt", "KeyFile", "./certs/tls.key")
+		"CertFile", cfg.CertFile, "KeyFile", cfg.KeyFile)
+
+	certWatcher = NewCertWatcher(cfg.CertFile, cfg.KeyFile, cfg.CertReloadInterval)
+}
+
+// GetCertWatcher 返回全局的 CertWatcher
+func GetCertWatcher() *CertWatcher {
+	return certWatcher
+}
 
-	// Load the X509 key pair
-	sCert, err := tls.LoadX509KeyPair("./certs/tls.crt", "./certs/tls.key")
+// ConfigTLS 创建 webhook 服务端的 TLS 配置
+func ConfigTLS(cfg *configs.Config) (*tls.Config, error) {
+	setupLog := ctrl.Log.WithName("config-tls")
+
+	tlsConfig, err := newServerTLSConfig(cfg)
 	if err != nil {
-		setupLog.Error(err, "Failed to load TLS certificate and private key:")
+		return nil, err
 	}
-	setupLog.Info("TLS certificate and private key loaded successfully")
 
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{sCert},
-		// TODO: uses mutual tls after we agree on what cert the apiserver should use.
-		// ClientAuth:   tls.RequireAndVerifyClientCert,
+	// 双向 TLS：校验 kube-apiserver 的客户端证书
+	if err := configClientAuth(tlsConfig, cfg.ClientAuthMode, cfg.ClientCAFile, cfg.ClientAllowedNames); err != nil {
+		return nil, err
 	}
 
 	// Optionally log the resulting TLS configuration (excluding sensitive information)
-	setupLog.V(1).Info("TLS configuration created successfully", "Certificates count:", len(tlsConfig.Certificates))
+	setupLog.V(1).Info("TLS configuration created successfully",
+		"CertFile", cfg.CertFile, "KeyFile", cfg.KeyFile, "reloadInterval", cfg.CertReloadInterval,
+		"tlsProfile", cfg.TLSProfile, "clientAuthMode", cfg.ClientAuthMode, "clientCAFile", cfg.ClientCAFile)
+
+	return tlsConfig, nil
+}
+
+// ConfigMetricsTLS 创建 metrics 服务端的 TLS 配置，与 webhook 共用证书和 TLS profile，
+// 不校验客户端证书，调用方通过 bearer token 认证
+func ConfigMetricsTLS(cfg *configs.Config) (*tls.Config, error) {
+	return newServerTLSConfig(cfg)
+}
+
+func newServerTLSConfig(cfg *configs.Config) (*tls.Config, error) {
+	if certWatcher == nil {
+		InitCertWatcher(cfg)
+	}
 
-	return tlsConfig
+	tlsConfig := &tls.Config{
+		// 每次握手都从 CertWatcher 取证书，证书轮换后无需重启
+		GetCertificate: certWatcher.GetCertificate,
+	}
+
+	// 最低 TLS 版本和 cipher suites
+	if err := applyProfile(tlsConfig, cfg.TLSProfile, cfg.TLSMinVersion, cfg.TLSCipherSuites); err != nil {
+		return nil, err
+	}
+	return tlsConfig, nil
 }
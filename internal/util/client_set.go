@@ -1,8 +1,11 @@
 package util
 
 import (
+	"context"
+	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -35,3 +38,10 @@ func InitClientSet() error {
 func GetClientSet() *kubernetes.Clientset {
 	return clientSet
 }
+
+// CheckAPIServer 检查 kube-apiserver 是否可达，用于就绪检查
+func CheckAPIServer(req *http.Request) error {
+	ctx, cancel := context.WithTimeout(req.Context(), 3*time.Second)
+	defer cancel()
+	return clientSet.Discovery().RESTClient().Get().AbsPath("/readyz").Do(ctx).Error()
+}
@@ -11,9 +11,9 @@ import (
 )
 
 // GetControllerName 根据 Pod 的 OwnerReferences 查找并记录控制器的相关信息。
-// reason 和 message 作为参数传递，用于记录事件。
+// reason 和 message 作为参数传递，用于记录事件，查询控制器时使用 ctx 的超时。
 // 只返回一个错误。
-func GetControllerName(pod *corev1.Pod, reason, message string) error {
+func GetControllerName(ctx context.Context, pod *corev1.Pod, reason, message string) error {
 	setupLog := ctrl.Log.WithName("GetControllerName")
 
 	// 获取 Pod 名称，优先使用 pod.Name，如果为空则使用 GenerateName
@@ -29,13 +29,13 @@ func GetControllerName(pod *corev1.Pod, reason, message string) error {
 	for _, owner := range pod.GetOwnerReferences() {
 		switch owner.Kind {
 		case "ReplicaSet":
-			return handleReplicaSet(pod, owner, reason, message)
+			return handleReplicaSet(ctx, pod, owner, reason, message)
 
 		case "Job":
-			return handleJob(pod, owner, reason, message)
+			return handleJob(ctx, pod, owner, reason, message)
 
 		case "StatefulSet":
-			return handleStatefulSet(pod, owner, reason, message)
+			return handleStatefulSet(ctx, pod, owner, reason, message)
 
 		default:
 			setupLog.V(1).Info("Logged event for unknown controller", "kind", owner.Kind, "controller", owner.Name)
@@ -53,8 +53,8 @@ func GetControllerName(pod *corev1.Pod, reason, message string) error {
 }
 
 // 辅助函数：处理 ReplicaSet
-func handleReplicaSet(pod *corev1.Pod, owner metav1.OwnerReference, reason, message string) error {
-	rs, err := clientSet.AppsV1().ReplicaSets(pod.Namespace).Get(context.Background(), owner.Name, metav1.GetOptions{})
+func handleReplicaSet(ctx context.Context, pod *corev1.Pod, owner metav1.OwnerReference, reason, message string) error {
+	rs, err := clientSet.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to get ReplicaSet: %w", err)
 	}
@@ -62,7 +62,7 @@ func handleReplicaSet(pod *corev1.Pod, owner metav1.OwnerReference, reason, mess
 	// 查找 ReplicaSet 的 OwnerReference，确认是否为 Deployment
 	for _, rsOwner := range rs.OwnerReferences {
 		if rsOwner.Kind == "Deployment" {
-			return handleDeployment(pod, rsOwner, reason, message)
+			return handleDeployment(ctx, pod, rsOwner, reason, message)
 		}
 	}
 
@@ -77,7 +77,7 @@ func handleReplicaSet(pod *corev1.Pod, owner metav1.OwnerReference, reason, mess
 }
 
 // 辅助函数：处理 Deployment
-func handleDeployment(pod *corev1.Pod, owner metav1.OwnerReference, reason, message string) error {
+func handleDeployment(ctx context.Context, pod *corev1.Pod, owner metav1.OwnerReference, reason, message string) error {
 	parts := strings.Split(owner.Name, "-")
 	if len(parts) == 0 {
 		return fmt.Errorf("failed to split Deployment name: %s", owner.Name)
@@ -85,7 +85,7 @@ func handleDeployment(pod *corev1.Pod, owner metav1.OwnerReference, reason, mess
 
 	deploymentName := parts[0]
 
-	dep, err := clientSet.AppsV1().Deployments(pod.Namespace).Get(context.Background(), deploymentName, metav1.GetOptions{})
+	dep, err := clientSet.AppsV1().Deployments(pod.Namespace).Get(ctx, deploymentName, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to get Deployment: %w", err)
 	}
@@ -101,8 +101,8 @@ func handleDeployment(pod *corev1.Pod, owner metav1.OwnerReference, reason, mess
 }
 
 // 辅助函数：处理 Job
-func handleJob(pod *corev1.Pod, owner metav1.OwnerReference, reason, message string) error {
-	job, err := clientSet.BatchV1().Jobs(pod.Namespace).Get(context.Background(), owner.Name, metav1.GetOptions{})
+func handleJob(ctx context.Context, pod *corev1.Pod, owner metav1.OwnerReference, reason, message string) error {
+	job, err := clientSet.BatchV1().Jobs(pod.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to get Job: %w", err)
 	}
@@ -117,7 +117,7 @@ func handleJob(pod *corev1.Pod, owner metav1.OwnerReference, reason, message str
 }
 
 // 辅助函数：处理 StatefulSet
-func handleStatefulSet(pod *corev1.Pod, owner metav1.OwnerReference, reason, message string) error {
+func handleStatefulSet(ctx context.Context, pod *corev1.Pod, owner metav1.OwnerReference, reason, message string) error {
 	parts := strings.Split(owner.Name, "-")
 	if len(parts) < 2 {
 		return fmt.Errorf("failed to split StatefulSet name: %s", owner.Name)
@@ -125,7 +125,7 @@ func handleStatefulSet(pod *corev1.Pod, owner metav1.OwnerReference, reason, mes
 
 	statefulSetName := strings.Join(parts[:2], "-")
 
-	sts, err := clientSet.AppsV1().StatefulSets(pod.Namespace).Get(context.Background(), statefulSetName, metav1.GetOptions{})
+	sts, err := clientSet.AppsV1().StatefulSets(pod.Namespace).Get(ctx, statefulSetName, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to get StatefulSet: %w", err)
 	}
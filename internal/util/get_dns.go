@@ -4,41 +4,96 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
 )
 
+// dnsLookupTimeout 是一次查询 DNS 地址的超时时间，查询由所有等待的调用共享，不使用单个调用者的 ctx
+const dnsLookupTimeout = 10 * time.Second
+
 var (
-	localDnsBindAddress string    // 用于存储 bind 值
-	coreDNSBindAddress  string    // 用于存储 CoreDNS Service 的 IP 值
-	initialized         bool      // 初始化标志
-	mu                  sync.Once // 确保初始化只执行一次
+	localDnsBindAddress string     // 用于存储 bind 值
+	coreDNSBindAddress  string     // 用于存储 CoreDNS Service 的 IP 值
+	initialized         bool       // 初始化标志
+	inflight            *dnsCall   // 正在进行的查询，并发调用共用同一次查询
+	mu                  sync.Mutex // 保护上面的变量，查询 API server 时不持有
+	// lookupDNS 查询 node-local-dns 和 coreDNS 的地址，测试时替换
+	lookupDNS = lookupDNSIP
 )
 
-// GetDNSIP 获取 localDns 的 bind 值和coreDNS并缓存它
-func GetDNSIP() (string, string, error) {
-	var err error
-	mu.Do(func() {
-		// 只有在第一次调用时执行以下代码
-		localDnsBindAddress, err = getLocalIPFromDaemonSet()
-		coreDNSBindAddress, err = getCoreIPFromService()
-		if err == nil {
-			initialized = true
+// dnsCall 是一次正在进行的查询，done 关闭后结果可读
+type dnsCall struct {
+	done    chan struct{}
+	localIP string
+	coreIP  string
+	err     error
+}
+
+// GetDNSIP 获取 localDns 的 bind 值和coreDNS并缓存它，coreDNS 获取失败时不缓存，下次调用重试。
+// 并发调用共用同一次查询，每个调用在自己的 ctx 结束时返回，避免超过 API server 调用 webhook 的超时时间
+func GetDNSIP(ctx context.Context) (string, string, error) {
+	mu.Lock()
+	if initialized {
+		mu.Unlock()
+		return localDnsBindAddress, coreDNSBindAddress, nil
+	}
+	call := inflight
+	if call == nil {
+		call = &dnsCall{done: make(chan struct{})}
+		inflight = call
+		// 查询不随发起者的 ctx 取消，其他调用还在等待结果
+		go runDNSLookup(context.WithoutCancel(ctx), call)
+	}
+	mu.Unlock()
+
+	select {
+	case <-call.done:
+		if call.err != nil {
+			return "", "", call.err
 		}
-	})
+		return call.localIP, call.coreIP, nil
+	case <-ctx.Done():
+		return "", "", fmt.Errorf("waiting for DNS addresses: %w", ctx.Err())
+	}
+}
 
-	if !initialized {
-		return "", "", fmt.Errorf("failed to initialize coreDNS bind value: %v", err)
+// runDNSLookup 执行查询，成功时缓存结果，并唤醒等待的调用
+func runDNSLookup(ctx context.Context, call *dnsCall) {
+	ctx, cancel := context.WithTimeout(ctx, dnsLookupTimeout)
+	defer cancel()
+	call.localIP, call.coreIP, call.err = lookupDNS(ctx)
+
+	mu.Lock()
+	if call.err == nil {
+		localDnsBindAddress, coreDNSBindAddress = call.localIP, call.coreIP
+		initialized = true
 	}
+	inflight = nil
+	mu.Unlock()
+	close(call.done)
+}
 
-	return localDnsBindAddress, coreDNSBindAddress, nil
+// lookupDNSIP 从 API server 查询 node-local-dns 和 coreDNS 的地址
+func lookupDNSIP(ctx context.Context) (string, string, error) {
+	// node-local-dns 是可选的，获取失败时只使用 coreDNS
+	localIP, localErr := getLocalIPFromDaemonSet(ctx)
+	coreIP, err := getCoreIPFromService(ctx)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to initialize coreDNS bind value: %v", err)
+	}
+	if localErr != nil {
+		ctrl.Log.WithName("GetDNSIP").V(1).Info("node-local-dns address not found, using coreDNS only", "error", localErr.Error())
+	}
+	return localIP, coreIP, nil
 }
 
 // getLocalIPFromDaemonSet 获取 DaemonSet 中指定容器的 -localip 参数值
-func getLocalIPFromDaemonSet() (string, error) {
+func getLocalIPFromDaemonSet(ctx context.Context) (string, error) {
 	// 获取指定命名空间中的 DaemonSet
-	localDNSDS, err := clientSet.AppsV1().DaemonSets("kube-system").Get(context.TODO(), "node-local-dns", metav1.GetOptions{})
+	localDNSDS, err := clientSet.AppsV1().DaemonSets("kube-system").Get(ctx, "node-local-dns", metav1.GetOptions{})
 	if err != nil {
 		if errors.IsNotFound(err) {
 			return "", fmt.Errorf("daemonset node-local-dns not found in namespace kube-system")
@@ -60,9 +115,9 @@ func getLocalIPFromDaemonSet() (string, error) {
 }
 
 // getCoreIPFromService 获取coreNDs ip
-func getCoreIPFromService() (string, error) {
+func getCoreIPFromService(ctx context.Context) (string, error) {
 	// 获取 CoreDNS Service
-	coreDNSService, err := clientSet.CoreV1().Services("kube-system").Get(context.TODO(), "kube-dns", metav1.GetOptions{})
+	coreDNSService, err := clientSet.CoreV1().Services("kube-system").Get(ctx, "kube-dns", metav1.GetOptions{})
 	if err != nil {
 		if errors.IsNotFound(err) {
 			return "", fmt.Errorf("service kube-dns not found in namespace kube-system")
@@ -0,0 +1,80 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// resetDNSCache 清空缓存，并在测试结束时恢复查询函数
+func resetDNSCache(t *testing.T, lookup func(context.Context) (string, string, error)) {
+	t.Helper()
+	reset := func() {
+		mu.Lock()
+		localDnsBindAddress, coreDNSBindAddress, initialized, inflight = "", "", false, nil
+		mu.Unlock()
+	}
+	reset()
+	lookupDNS = lookup
+	t.Cleanup(func() {
+		reset()
+		lookupDNS = lookupDNSIP
+	})
+}
+
+func TestGetDNSIPWaiterHonorsContext(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	resetDNSCache(t, func(context.Context) (string, string, error) {
+		calls.Add(1)
+		<-release
+		return "169.254.20.10", "10.96.0.10", nil
+	})
+
+	// 查询阻塞时，等待的调用在自己的 ctx 结束时返回
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := GetDNSIP(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetDNSIP() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// 取消的调用不影响正在进行的查询，后面的调用共用它的结果
+	result := make(chan error, 1)
+	go func() {
+		local, core, err := GetDNSIP(context.Background())
+		if err == nil && (local != "169.254.20.10" || core != "10.96.0.10") {
+			err = errors.New("unexpected addresses " + local + " " + core)
+		}
+		result <- err
+	}()
+	close(release)
+	if err := <-result; err != nil {
+		t.Fatalf("GetDNSIP() error = %v", err)
+	}
+	if _, _, err := GetDNSIP(context.Background()); err != nil {
+		t.Fatalf("cached GetDNSIP() error = %v", err)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("lookup called %d times, want 1", got)
+	}
+}
+
+func TestGetDNSIPRetriesAfterFailure(t *testing.T) {
+	var calls atomic.Int32
+	resetDNSCache(t, func(context.Context) (string, string, error) {
+		if calls.Add(1) == 1 {
+			return "", "", errors.New("service kube-dns not found")
+		}
+		return "", "10.96.0.10", nil
+	})
+
+	if _, _, err := GetDNSIP(context.Background()); err == nil {
+		t.Fatal("GetDNSIP() error = nil, want the lookup error")
+	}
+	_, core, err := GetDNSIP(context.Background())
+	if err != nil || core != "10.96.0.10" {
+		t.Fatalf("GetDNSIP() = %q, %v, want 10.96.0.10", core, err)
+	}
+}
@@ -33,19 +33,12 @@ func GeneratePatchAndResponse(originalObj, modifiedObj runtime.Object, allowed b
 	}
 
 	// 生成 JSON Patch
-	patch, err := createJSONPatch(originalNodeBytes, modifiedNodeBytes)
+	patchBytes, err := CreateJSONPatch(originalNodeBytes, modifiedNodeBytes)
 	if err != nil {
 		setupLog.Error(err, "failed to create JSON patch")
 		return setting.ToV1AdmissionResponse(err)
 	}
 
-	// 序列化 JSON Patch
-	patchBytes, err := marshalPatch(patch)
-	if err != nil {
-		setupLog.Error(err, "failed to marshal JSON patch")
-		return setting.ToV1AdmissionResponse(err)
-	}
-
 	// 构造 AdmissionResponse
 	return constructAdmissionResponse(allowed, patchBytes, warning, message)
 
@@ -61,6 +54,18 @@ func marshalObject(obj runtime.Object) ([]byte, error) {
 // 	return json.Marshal(node)
 // }
 
+// CreateJSONPatch 生成从 original 到 modified 的 JSON Patch，没有变化时返回 nil
+func CreateJSONPatch(original, modified []byte) ([]byte, error) {
+	patch, err := createJSONPatch(original, modified)
+	if err != nil {
+		return nil, err
+	}
+	if len(patch) == 0 {
+		return nil, nil
+	}
+	return marshalPatch(patch)
+}
+
 // createJSONPatch 生成从原始节点到修改后节点的 JSON Patch
 func createJSONPatch(original, modified []byte) ([]jsonpatch.JsonPatchOperation, error) {
 	return jsonpatch.CreatePatch(original, modified)
@@ -0,0 +1,72 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// NamespaceCache 通过 informer 缓存 namespace，分发请求时匹配 webhook 的 namespaceSelector 不需要每次请求 API server
+type NamespaceCache struct {
+	client   kubernetes.Interface
+	factory  informers.SharedInformerFactory
+	informer cache.SharedIndexInformer
+	lister   corev1listers.NamespaceLister
+}
+
+// NewNamespaceCache 创建 NamespaceCache，需要加入 lifecycle.Manager 启动
+func NewNamespaceCache(client kubernetes.Interface) *NamespaceCache {
+	c := &NamespaceCache{client: client}
+	c.factory = informers.NewSharedInformerFactory(client, 0)
+	namespaces := c.factory.Core().V1().Namespaces()
+	c.informer = namespaces.Informer()
+	c.lister = namespaces.Lister()
+	return c
+}
+
+// Start 启动 informer，直到 ctx 结束
+func (c *NamespaceCache) Start(ctx context.Context) error {
+	setupLog := ctrl.Log.WithName("namespace-cache")
+	setupLog.Info("Starting namespace cache")
+
+	c.factory.Start(ctx.Done())
+	<-ctx.Done()
+	c.factory.Shutdown()
+	setupLog.Info("Stopped namespace cache")
+	return nil
+}
+
+// HasSynced 返回 informer 是否已经完成第一次 list，用于就绪检查
+func (c *NamespaceCache) HasSynced() bool {
+	return c.informer.HasSynced()
+}
+
+// Labels 返回 namespace 的标签。刚创建的 namespace 可能还没有同步到缓存，这时直接查询 API server
+func (c *NamespaceCache) Labels(ctx context.Context, namespace string) (map[string]string, error) {
+	if !c.HasSynced() {
+		return nil, fmt.Errorf("namespace cache has not synced")
+	}
+	ns, err := c.lister.Get(namespace)
+	if err == nil {
+		return ns.Labels, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	live, err := c.client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return live.Labels, nil
+}